@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const apiPrefix = "/api/v1"
+
+// apiError is the JSON body of API error responses
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeAPIError writes err as a JSON API error response
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// webcamErrorStatus maps errors from the webcam operations to HTTP status
+func webcamErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errWebcamNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errWebcamExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// validateTLDef applies the rules handleNew applies to form submissions to
+// a definition decoded from JSON, and sets its First/Last flags
+func (s *server) validateTLDef(tld *TLDef) error {
+	if err := s.validate.Struct(tld); err != nil {
+		return err
+	}
+	if err := s.config.resolveFolder(tld); err != nil {
+		return err
+	}
+	if tld.Additional < 0 || tld.Additional > 16 {
+		return fmt.Errorf("Additional must be 0-16")
+	}
+	if err := tld.SetFirstLastFlags(); err != nil {
+		return err
+	}
+	if err := tld.Fallback.Check(); err != nil {
+		return err
+	}
+	return tld.Transforms.Check()
+}
+
+// decodeTLDef decodes and validates the TLDef in the request body, writing
+// an error response if it is invalid
+func (s *server) decodeTLDef(w http.ResponseWriter, r *http.Request) (*TLDef, bool) {
+	sn := "decodeTLDef"
+
+	tld := newTLDef()
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(tld); err != nil {
+		log.Printf("%s, json.Decode: %v\n", sn, err)
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	if err := s.validateTLDef(tld); err != nil {
+		log.Printf("%s, %s validateTLDef: %v\n", sn, tld.Name, err)
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	return tld, true
+}
+
+// handleAPIList is the handler for GET "/api/v1/webcams"
+func (s *server) handleAPIList() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.mu.Lock()
+		list := masterTLDefs{}
+		for _, tld := range *s.mtld {
+			list = append(list, tld.definition()) // see TLDef.definition
+		}
+		s.mu.Unlock()
+
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// handleAPIGet is the handler for GET "/api/v1/webcams/:name"
+func (s *server) handleAPIGet() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.mu.Lock()
+		tld := s.mtld.Find(p.ByName("name"))
+		if tld != nil {
+			tld = tld.definition() // see TLDef.definition
+		}
+		s.mu.Unlock()
+
+		if tld == nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("webcam %q not found", p.ByName("name")))
+			return
+		}
+		writeJSON(w, http.StatusOK, tld)
+	}
+}
+
+// handleAPICreate is the handler for POST "/api/v1/webcams". The new
+// definition is saved and its capture go routine started.
+func (s *server) handleAPICreate() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleAPICreate"
+
+		tld, ok := s.decodeTLDef(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.requireProbe(r.Context(), tld); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		if err := s.addWebcam(tld); err != nil {
+			log.Printf("%s, s.addWebcam: %v\n", sn, err)
+			writeAPIError(w, webcamErrorStatus(err), err)
+			return
+		}
+
+		w.Header().Set("Location", apiPrefix+"/webcams/"+url.PathEscape(tld.Name))
+		writeJSON(w, http.StatusCreated, tld)
+	}
+}
+
+// handleAPIUpdate is the handler for PUT "/api/v1/webcams/:name". The
+// definition is replaced, and its capture go routine restarted so the
+// new definition takes effect immediately.
+func (s *server) handleAPIUpdate() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleAPIUpdate"
+		name := p.ByName("name")
+
+		tld, ok := s.decodeTLDef(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.updateWebcam(name, tld); err != nil {
+			log.Printf("%s, s.updateWebcam: %v\n", sn, err)
+			writeAPIError(w, webcamErrorStatus(err), err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, tld)
+	}
+}
+
+// handleAPIDelete is the handler for DELETE "/api/v1/webcams/:name". The
+// capture go routine is stopped; captured images are removed only with
+// query parameter "frames=delete".
+func (s *server) handleAPIDelete() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleAPIDelete"
+		name := p.ByName("name")
+
+		removeFrames := r.URL.Query().Get("frames") == "delete"
+		if err := s.removeWebcam(name, removeFrames); err != nil {
+			log.Printf("%s, s.removeWebcam: %v\n", sn, err)
+			writeAPIError(w, webcamErrorStatus(err), err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_server_handleAPI(t *testing.T) {
+	folder, err := ioutil.TempDir("", "timelapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	valid := `{"name": "testAPI", "webcamUrl": "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg",
+		"latitude": 40.437787, "longitude": -121.5360307, "firstSunrise": true, "lastSunset": true,
+		"additional": 3, "folder": "` + folder + `"}`
+	updated := strings.Replace(valid, `"additional": 3`, `"additional": 5`, 1)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		substring  string
+		location   string
+	}{
+		{name: "list",
+			method:     "GET",
+			path:       "/api/v1/webcams",
+			wantStatus: http.StatusOK,
+			substring:  `"name":"Kohm Yah-man-yeh"`,
+		},
+		{name: "get missing",
+			method:     "GET",
+			path:       "/api/v1/webcams/" + url.PathEscape("testAPI"),
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "create invalid url",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       strings.Replace(valid, "https://", "", 1),
+			wantStatus: http.StatusBadRequest,
+			substring:  `'url' tag`,
+		},
+		{name: "create additional too big",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       strings.Replace(valid, `"additional": 3`, `"additional": 17`, 1),
+			wantStatus: http.StatusBadRequest,
+			substring:  "Additional must be 0-16",
+		},
+		{name: "create first and last missing",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       strings.Replace(valid, `"firstSunrise": true`, `"firstSunrise": false`, 1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "create unknown field",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       strings.Replace(valid, `"additional"`, `"additionnal"`, 1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "create",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       valid,
+			wantStatus: http.StatusCreated,
+			substring:  `"additional":3`,
+			location:   "/api/v1/webcams/testAPI",
+		},
+		{name: "create escaped",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       strings.Replace(valid, "testAPI", "test API?#", 1),
+			wantStatus: http.StatusCreated,
+			location:   "/api/v1/webcams/test%20API%3F%23",
+		},
+		{name: "delete escaped",
+			method:     "DELETE",
+			path:       "/api/v1/webcams/test%20API%3F%23",
+			wantStatus: http.StatusNoContent,
+		},
+		{name: "create duplicate",
+			method:     "POST",
+			path:       "/api/v1/webcams",
+			body:       valid,
+			wantStatus: http.StatusConflict,
+		},
+		{name: "get",
+			method:     "GET",
+			path:       "/api/v1/webcams/testAPI",
+			wantStatus: http.StatusOK,
+			substring:  `"additional":3`,
+		},
+		{name: "update",
+			method:     "PUT",
+			path:       "/api/v1/webcams/testAPI",
+			body:       updated,
+			wantStatus: http.StatusOK,
+			substring:  `"additional":5`,
+		},
+		{name: "update missing",
+			method:     "PUT",
+			path:       "/api/v1/webcams/testMissing",
+			body:       strings.Replace(valid, "testAPI", "testMissing", 1),
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "delete",
+			method:     "DELETE",
+			path:       "/api/v1/webcams/testAPI",
+			wantStatus: http.StatusNoContent,
+		},
+		{name: "delete missing",
+			method:     "DELETE",
+			path:       "/api/v1/webcams/testAPI",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			} else if tt.substring != "" && !strings.Contains(rr.Body.String(), tt.substring) {
+				t.Errorf("%s want substring %q, not found in %q", tt.name, tt.substring, rr.Body.String())
+			}
+			if got := rr.Header().Get("Location"); got != tt.location {
+				t.Errorf("%s Location got %q, want %q", tt.name, got, tt.location)
+			}
+			if rr.Code >= 400 {
+				var body apiError
+				if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error == "" {
+					t.Errorf("%s, error response not JSON: %q", tt.name, rr.Body.String())
+				}
+			}
+		})
+	}
+
+	srv.mu.Lock()
+	running := false
+	for tld := range srv.captures {
+		if tld.Name == "testAPI" {
+			running = true
+		}
+	}
+	srv.mu.Unlock()
+	if running {
+		t.Errorf("capture go routine for deleted webcam still registered")
+	}
+}
+
+func Test_masterTLDefs_Remove(t *testing.T) {
+	mtld := masterTLDefs{{Name: "first"}, {Name: "second"}, {Name: "third"}}
+
+	if got := mtld.Remove("second"); got == nil || got.Name != "second" {
+		t.Errorf("masterTLDefs.Remove() got %v, want second", got)
+	}
+	if len(mtld) != 2 || mtld[0].Name != "first" || mtld[1].Name != "third" {
+		t.Errorf("masterTLDefs.Remove() left %d elements", len(mtld))
+	}
+	if got := mtld.Remove("second"); got != nil {
+		t.Errorf("masterTLDefs.Remove() of missing got %v, want nil", got)
+	}
+}
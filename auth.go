@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	roleViewer = "viewer" // may view webcams, frames and status
+	roleAdmin  = "admin"  // may also add, change and remove webcams
+
+	sessionCookie   = "timelapse_session"
+	loginCookie     = "timelapse_login" // CSRF token of the sign in form, see handleLoginForm
+	sessionLifetime = 12 * time.Hour
+	csrfField       = "csrf"         // form field holding the CSRF token
+	csrfHeader      = "X-CSRF-Token" // header holding the CSRF token, for scripts using a session
+)
+
+// dummyHash is compared against when a user is unknown, so unknown and
+// known users take as long to reject. It is computed on first use, as
+// bcrypt is slow by design.
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// principal is an authenticated user, or script using an API token
+type principal struct {
+	Name     string
+	Role     string
+	session  *session // nil unless authenticated by session cookie
+	password bool     // authenticated by "Authorization: Basic", which browsers resend by themselves
+}
+
+// session is a signed-in user of the web UI
+type session struct {
+	id      string
+	user    string
+	role    string
+	csrf    string // token required on form posts
+	expires time.Time
+}
+
+// authUser is a user read from the users file
+type authUser struct {
+	hash []byte // bcrypt
+	role string
+}
+
+// authStore holds users, API tokens and sessions. Users and tokens are read
+// at startup; sessions live in memory, so restarting signs everyone out.
+type authStore struct {
+	users  map[string]authUser
+	tokens map[string]principal // by SHA-256 of the token, hex encoded
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+type principalKey struct{}
+
+// loadAuth reads the users file, in htpasswd format with bcrypt hashes and
+// an optional role ("name:hash[:role]"), and the tokens file, holding the
+// SHA-256 of each token ("name:sha256hex[:role]"). The role defaults to
+// viewer. Without either file, authentication is disabled and nil returned.
+func loadAuth(usersPath, tokensPath string) (*authStore, error) {
+	if usersPath == "" && tokensPath == "" {
+		return nil, nil
+	}
+
+	a := &authStore{
+		users:    make(map[string]authUser),
+		tokens:   make(map[string]principal),
+		sessions: make(map[string]*session),
+	}
+	if err := readAuthFile(usersPath, func(name, secret, role string) error {
+		if !strings.HasPrefix(secret, "$2") {
+			return fmt.Errorf("user %q: password must be a bcrypt hash, e.g., from \"htpasswd -nB %s\"", name, name)
+		}
+		a.users[name] = authUser{hash: []byte(secret), role: role}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := readAuthFile(tokensPath, func(name, secret, role string) error {
+		if b, err := hex.DecodeString(secret); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("token %q: want the hex SHA-256 of the token, e.g., from \"sha256sum\"", name)
+		}
+		a.tokens[strings.ToLower(secret)] = principal{Name: name, Role: role}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// readAuthFile calls add for each "name:secret[:role]" line of the file at
+// path, if any, skipping blank lines and "#" comments
+func readAuthFile(path string, add func(name, secret, role string) error) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" {
+			return fmt.Errorf("%s:%d: want name:secret[:role]", path, n)
+		}
+		role := roleViewer
+		if len(fields) == 3 {
+			role = fields[2]
+		}
+		if role != roleViewer && role != roleAdmin {
+			return fmt.Errorf("%s:%d: role %q, want %s or %s", path, n, role, roleViewer, roleAdmin)
+		}
+		if err := add(fields[0], fields[1], role); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// checkPassword returns the user if password is theirs, or nil
+func (a *authStore) checkPassword(name, password string) *principal {
+	u, ok := a.users[name]
+	if !ok {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("timelapse"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return nil
+	}
+	return &principal{Name: name, Role: u.role}
+}
+
+// checkToken returns the script the API token was issued to, or nil
+func (a *authStore) checkToken(token string) *principal {
+	sum := sha256.Sum256([]byte(token))
+	if p, ok := a.tokens[hex.EncodeToString(sum[:])]; ok {
+		return &p
+	}
+	return nil
+}
+
+// newSession signs in a user, returning the new session
+func (a *authStore) newSession(p *principal) (*session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{id: id, user: p.Name, role: p.Role, csrf: csrf, expires: time.Now().Add(sessionLifetime)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, old := range a.sessions { // forget expired sessions
+		if time.Now().After(old.expires) {
+			delete(a.sessions, id)
+		}
+	}
+	a.sessions[sess.id] = sess
+	return sess, nil
+}
+
+// findSession returns the unexpired session with id, or nil
+func (a *authStore) findSession(id string) *session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, ok := a.sessions[id]
+	if !ok || time.Now().After(sess.expires) {
+		return nil
+	}
+	return sess
+}
+
+// endSession signs out the session with id
+func (a *authStore) endSession(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessions, id)
+}
+
+// authenticate returns who made the request: the script presenting an API
+// token ("Authorization: Bearer"), the user presenting a password
+// ("Authorization: Basic") or the user signed in by session cookie. ok is
+// false if credentials were presented but are not valid.
+func (a *authStore) authenticate(r *http.Request) (p *principal, ok bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if strings.HasPrefix(h, "Bearer ") {
+			p = a.checkToken(strings.TrimPrefix(h, "Bearer "))
+		} else if name, password, basic := r.BasicAuth(); basic {
+			if p = a.checkPassword(name, password); p != nil {
+				p.password = true
+			}
+		}
+		return p, p != nil
+	}
+
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if sess := a.findSession(c.Value); sess != nil {
+			return &principal{Name: sess.user, Role: sess.role, session: sess}, true
+		}
+	}
+	return nil, true
+}
+
+// randomToken returns 32 random bytes, base64 URL encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// principalFrom returns who made the request, nil if not authenticated
+func principalFrom(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	return p
+}
+
+// isPublic reports whether path may be requested without signing in
+func isPublic(path string) bool {
+	return path == "/login" || path == "/healthz" || path == "/readyz" || strings.HasPrefix(path, "/static/")
+}
+
+// isSafe reports whether method only reads; other methods change state and
+// require the admin role and, for sessions, the CSRF token
+func isSafe(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// isFormLike reports whether a browser could send the request cross-site
+// without a CORS preflight, e.g., by submitting a form: a POST with no
+// body, or a form or plain text body
+func isFormLike(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	switch strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]) {
+	case "", "application/x-www-form-urlencoded", "multipart/form-data", "text/plain":
+		return true
+	}
+	return false
+}
+
+// needsAdmin reports whether the request requires the admin role: any
+// change, other than signing out, and the edit form
+func needsAdmin(r *http.Request) bool {
+	if isSafe(r.Method) {
+		return strings.HasSuffix(r.URL.Path, "/edit")
+	}
+	return r.URL.Path != "/logout"
+}
+
+// authorize wraps next, requiring authentication unless disabled: the
+// viewer role to read and the admin role to change anything. Form posts
+// from signed-in users must carry the session's CSRF token. As a password
+// is resent by the browser, like a cookie, form posts can't be made with
+// one, only with a session or an API token.
+func (s *server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil || isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := s.auth.authenticate(r)
+		if p == nil {
+			s.unauthenticated(w, r, ok)
+			return
+		}
+
+		if !isSafe(r.Method) && p.session != nil {
+			token := r.Header.Get(csrfHeader)
+			if token == "" {
+				token = r.PostFormValue(csrfField)
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(p.session.csrf)) != 1 {
+				http.Error(w, "invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		if !isSafe(r.Method) && p.password && isFormLike(r) {
+			http.Error(w, "sign in to submit forms, or send JSON", http.StatusForbidden)
+			return
+		}
+		if needsAdmin(r) && p.Role != roleAdmin {
+			http.Error(w, fmt.Sprintf("%s may not change webcams", p.Name), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// unauthenticated sends browsers to sign in, and scripts, or requests with
+// invalid credentials, a 401 challenge. Browsers aren't challenged for a
+// password, which they would remember and resend, see authorize.
+func (s *server) unauthenticated(w http.ResponseWriter, r *http.Request, credentialsValid bool) {
+	browser := strings.Contains(r.Header.Get("Accept"), "text/html")
+	if credentialsValid && browser && isSafe(r.Method) && !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	w.Header().Add("WWW-Authenticate", `Bearer realm="timelapse"`)
+	if !browser {
+		w.Header().Add("WWW-Authenticate", `Basic realm="timelapse"`)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// safeNext returns the local path to continue to after signing in
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleLoginForm is the handler for GET "/login". The form carries a
+// CSRF token, also set as a cookie, so other sites can't sign a browser
+// in, see handleLogin.
+func (s *server) handleLoginForm() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleLoginForm"
+
+		next := safeNext(r.URL.Query().Get("next"))
+		if s.auth == nil {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		csrf, err := randomToken()
+		if err != nil {
+			log.Printf("%s, randomToken: %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    csrf,
+			Path:     "/login",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		s.render(w, r, http.StatusOK, page{Company: "Timelapse", Login: &loginPage{Next: next, CSRF: csrf}})
+	}
+}
+
+// handleLogin is the handler for POST "/login", signing in with the
+// "username" and "password" form fields. The form's CSRF token must match
+// the cookie set with it.
+func (s *server) handleLogin() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleLogin"
+
+		next := safeNext(r.PostFormValue("next"))
+		if s.auth == nil {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		csrf := r.PostFormValue(csrfField)
+		c, err := r.Cookie(loginCookie)
+		if err != nil || csrf == "" || subtle.ConstantTimeCompare([]byte(csrf), []byte(c.Value)) != 1 {
+			http.Error(w, "invalid or missing CSRF token, reload the sign in page and try again", http.StatusForbidden)
+			return
+		}
+
+		name := r.PostFormValue("username")
+		user := s.auth.checkPassword(name, r.PostFormValue("password"))
+		if user == nil {
+			log.Printf("%s, sign in failed for %q from %s\n", sn, name, r.RemoteAddr)
+			s.render(w, r, http.StatusUnauthorized, page{Company: "Timelapse",
+				Login: &loginPage{Next: next, Username: name, Error: "Unknown user name or wrong password", CSRF: csrf}})
+			return
+		}
+
+		sess, err := s.auth.newSession(user)
+		if err != nil {
+			log.Printf("%s, newSession: %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Value: "", Path: "/login", MaxAge: -1, HttpOnly: true})
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    sess.id,
+			Path:     "/",
+			Expires:  sess.expires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+// handleLogout is the handler for POST "/logout"
+func (s *server) handleLogout() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if pr := principalFrom(r); pr != nil && pr.session != nil {
+			s.auth.endSession(pr.session.id)
+		}
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+// loginPage is the data rendered by the "login" template
+type loginPage struct {
+	Next     string // path to continue to after signing in
+	Username string
+	Error    string
+	CSRF     string // token the form must carry, see handleLoginForm
+}
+
+// render executes the layout template with data, adding who is signed in
+// and the CSRF token forms must carry
+func (s *server) render(w http.ResponseWriter, r *http.Request, status int, data page) {
+	data.Admin = s.auth == nil // without authentication, anyone may change webcams
+	if p := principalFrom(r); p != nil {
+		data.User = p.Name
+		data.Admin = p.Role == roleAdmin
+		if p.session != nil {
+			data.CSRF = p.session.csrf
+		}
+	}
+	data.AuthEnabled = s.auth != nil
+	data.DefaultRoot = s.config.defaultRoot()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	s.tmpl.ExecuteTemplate(w, "layout", data)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeAuthFile writes lines to a temporary file, returning its path
+func writeAuthFile(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "timelapse-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// newTestAuth returns an authStore with users "admin" and "viewer", both
+// with password "secret", and tokens "deploy-token" (admin) and
+// "dashboard-token" (viewer)
+func newTestAuth(t *testing.T) *authStore {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := func(token string) string {
+		s := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(s[:])
+	}
+
+	users := writeAuthFile(t, "# users", "admin:"+string(hash)+":admin", "", "viewer:"+string(hash))
+	defer os.Remove(users)
+	tokens := writeAuthFile(t, "deploy:"+sum("deploy-token")+":admin", "dashboard:"+sum("dashboard-token")+":viewer")
+	defer os.Remove(tokens)
+
+	a, err := loadAuth(users, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func Test_loadAuth(t *testing.T) {
+	if a, err := loadAuth("", ""); a != nil || err != nil {
+		t.Errorf("loadAuth() without files got %v %v, want disabled", a, err)
+	}
+
+	a := newTestAuth(t)
+	if a.users["viewer"].role != roleViewer || a.users["admin"].role != roleAdmin {
+		t.Errorf("loadAuth() got roles %q %q, want viewer admin", a.users["viewer"].role, a.users["admin"].role)
+	}
+	if p := a.checkToken("deploy-token"); p == nil || p.Name != "deploy" || p.Role != roleAdmin {
+		t.Errorf("loadAuth() token deploy-token got %+v", p)
+	}
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "plain password", line: "alice:secret"},
+		{name: "unknown role", line: "alice:$2y$05$abc:root"},
+		{name: "no secret", line: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeAuthFile(t, tt.line)
+			defer os.Remove(path)
+			if _, err := loadAuth(path, ""); err == nil {
+				t.Errorf("loadAuth(%q) got no error", tt.line)
+			}
+		})
+	}
+}
+
+func Test_server_authorize(t *testing.T) {
+	s := &server{auth: newTestAuth(t)}
+	viewerSession, _ := s.auth.newSession(&principal{Name: "viewer", Role: roleViewer})
+	adminSession, _ := s.auth.newSession(&principal{Name: "admin", Role: roleAdmin})
+
+	handler := s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := principalFrom(r); p != nil {
+			w.Write([]byte("reached " + p.Name))
+		}
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		form       url.Values
+		header     map[string]string
+		session    *session
+		wantStatus int
+		wantBody   string
+		wantBasic  bool // whether a 401 challenges for a password
+	}{
+		{name: "public", method: "GET", path: "/login", wantStatus: http.StatusOK},
+		{name: "health public", method: "GET", path: "/readyz", header: map[string]string{"Accept": "text/html"}, wantStatus: http.StatusOK},
+		{name: "browser signs in", method: "GET", path: "/webcams/a/gallery", header: map[string]string{"Accept": "text/html"}, wantStatus: http.StatusSeeOther},
+		{name: "api challenged", method: "GET", path: apiPrefix + "/webcams", header: map[string]string{"Accept": "text/html"}, wantStatus: http.StatusUnauthorized},
+		{name: "script challenged", method: "GET", path: apiPrefix + "/webcams", wantStatus: http.StatusUnauthorized, wantBasic: true},
+		{name: "bad token", method: "GET", path: "/", header: map[string]string{"Authorization": "Bearer wrong"}, wantStatus: http.StatusUnauthorized, wantBasic: true},
+		{name: "viewer token reads", method: "GET", path: apiPrefix + "/webcams", header: map[string]string{"Authorization": "Bearer dashboard-token"}, wantStatus: http.StatusOK, wantBody: "reached dashboard"},
+		{name: "viewer token can't change", method: "DELETE", path: apiPrefix + "/webcams/a", header: map[string]string{"Authorization": "Bearer dashboard-token"}, wantStatus: http.StatusForbidden},
+		{name: "admin token changes", method: "DELETE", path: apiPrefix + "/webcams/a", header: map[string]string{"Authorization": "Bearer deploy-token"}, wantStatus: http.StatusOK, wantBody: "reached deploy"},
+		{name: "basic auth", method: "GET", path: "/", header: map[string]string{"Authorization": "Basic " + basic("viewer", "secret")}, wantStatus: http.StatusOK},
+		{name: "basic auth wrong password", method: "GET", path: "/", header: map[string]string{"Authorization": "Basic " + basic("viewer", "nope")}, wantStatus: http.StatusUnauthorized, wantBasic: true},
+		{name: "basic auth form post", method: "POST", path: "/webcams/a/pause", form: url.Values{}, header: map[string]string{"Authorization": "Basic " + basic("admin", "secret")}, wantStatus: http.StatusForbidden},
+		{name: "basic auth plain post", method: "POST", path: "/webcams/a/pause", header: map[string]string{"Authorization": "Basic " + basic("admin", "secret")}, wantStatus: http.StatusForbidden},
+		{name: "basic auth json", method: "POST", path: apiPrefix + "/webcams/a/pause", header: map[string]string{"Authorization": "Basic " + basic("admin", "secret"), "Content-Type": "application/json"}, wantStatus: http.StatusOK, wantBody: "reached admin"},
+		{name: "viewer session reads", method: "GET", path: "/", session: viewerSession, wantStatus: http.StatusOK},
+		{name: "viewer session can't edit", method: "GET", path: "/webcams/a/edit", session: viewerSession, wantStatus: http.StatusForbidden},
+		{name: "viewer session signs out", method: "POST", path: "/logout", form: url.Values{"csrf": {viewerSession.csrf}}, session: viewerSession, wantStatus: http.StatusOK},
+		{name: "session post without csrf", method: "POST", path: "/webcams/a/pause", session: adminSession, wantStatus: http.StatusForbidden},
+		{name: "session post wrong csrf", method: "POST", path: "/webcams/a/pause", form: url.Values{"csrf": {viewerSession.csrf}}, session: adminSession, wantStatus: http.StatusForbidden},
+		{name: "session post with csrf", method: "POST", path: "/webcams/a/pause", form: url.Values{"csrf": {adminSession.csrf}}, session: adminSession, wantStatus: http.StatusOK},
+		{name: "session api with csrf header", method: "DELETE", path: apiPrefix + "/webcams/a", header: map[string]string{csrfHeader: adminSession.csrf}, session: adminSession, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if tt.session != nil {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.session.id})
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("%s, got %q, want %q", tt.name, rr.Body.String(), tt.wantBody)
+			}
+			if rr.Code == http.StatusUnauthorized {
+				challenges := strings.Join(rr.Header().Values("WWW-Authenticate"), ", ")
+				if got := strings.Contains(challenges, "Basic"); got != tt.wantBasic {
+					t.Errorf("%s, got challenges %q, want Basic %t", tt.name, challenges, tt.wantBasic)
+				}
+			}
+		})
+	}
+}
+
+// basic returns the credentials of an "Authorization: Basic" header
+func basic(user, password string) string {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth(user, password)
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Basic ")
+}
+
+func Test_server_handleLogin(t *testing.T) {
+	srv.auth = newTestAuth(t)
+	defer func() { srv.auth = nil }()
+	handler := srv.authorize(srv.router)
+
+	// the sign in form sets a cookie holding the CSRF token it carries
+	req, _ := http.NewRequest("GET", "/login", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	loginCookies := rr.Result().Cookies()
+	if rr.Code != http.StatusOK || len(loginCookies) != 1 || loginCookies[0].Name != loginCookie ||
+		!strings.Contains(rr.Body.String(), `name="csrf" value="`+loginCookies[0].Value+`"`) {
+		t.Fatalf("handleLoginForm got %d, cookies %v, want the form's CSRF token as a cookie", rr.Code, loginCookies)
+	}
+	csrf := loginCookies[0].Value
+
+	post := func(form url.Values, cookie bool) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie {
+			req.AddCookie(loginCookies[0])
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// signing in from another site, without the cookie or token, fails
+	if rr = post(url.Values{"username": {"admin"}, "password": {"secret"}, "csrf": {csrf}}, false); rr.Code != http.StatusForbidden {
+		t.Errorf("handleLogin without cookie got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr = post(url.Values{"username": {"admin"}, "password": {"secret"}}, true); rr.Code != http.StatusForbidden {
+		t.Errorf("handleLogin without token got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	rr = post(url.Values{"username": {"admin"}, "password": {"wrong"}, "csrf": {csrf}}, true)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "wrong password") {
+		t.Errorf("handleLogin wrong password got %d", rr.Code)
+	}
+
+	rr = post(url.Values{"username": {"admin"}, "password": {"secret"}, "csrf": {csrf}, "next": {"//evil.example.com"}}, true)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("handleLogin got %d to %q, want %d to /", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != loginCookie || cookies[0].MaxAge >= 0 ||
+		cookies[1].Name != sessionCookie || !cookies[1].HttpOnly {
+		t.Fatalf("handleLogin got cookies %v, want the sign in cookie removed and an HttpOnly session cookie", cookies)
+	}
+	cookies = cookies[1:]
+
+	// the signed in page carries the CSRF token and the user
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	sess := srv.auth.findSession(cookies[0].Value)
+	if rr.Code != http.StatusOK || sess == nil || !strings.Contains(rr.Body.String(), `name="csrf" value="`+sess.csrf+`"`) {
+		t.Errorf("home page signed in got %d, want the session's CSRF token", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Sign Out") {
+		t.Errorf("home page signed in, want Sign Out")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const captionFolder = "captioned" // sub-folder of FolderPath holding captioned copies
+
+// CaptionDef configures the caption burned into each captured frame
+type CaptionDef struct {
+	Position   string `json:"position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right"` // corner of the frame, default "bottom-left"
+	FontSize   int    `json:"fontSize" validate:"omitempty,min=13,max=130"`                                    // text height in pixels, rounded to a multiple of 13
+	Background bool   `json:"background"`                                                                      // draw a translucent box behind the text
+	Replace    bool   `json:"replace"`                                                                         // replace the original, rather than writing a copy to the "captioned" folder
+}
+
+// CaptionText returns the caption for a frame captured at the specified
+// time and CaptureTimes slot: webcam name, local time at the webcam, slot
+// label and sun elevation
+func (tld *TLDef) CaptionText(at time.Time, slot int) string {
+	loc := tld.webcamLoc()
+	elevation := SunElevation(at, tld.Latitude, tld.Longitude)
+	return fmt.Sprintf("%s  %s  %s  sun %.1f deg",
+		tld.Name, at.In(loc).Format("2006-01-02 15:04 MST"), tld.SlotLabel(slot), elevation)
+}
+
+// CaptionFrame burns the caption into the frame stored at path, and returns
+// the path of the captioned image
+func (tld *TLDef) CaptionFrame(path string, at time.Time, slot int) (string, error) {
+	sn := "TLDef.CaptionFrame"
+
+	img, format, err := LoadFrame(path)
+	if err != nil {
+		log.Printf("%s, %s LoadFrame: %v\n", sn, tld.Name, err)
+		return "", err
+	}
+
+	captioned := toRGBA(img)
+	drawCaption(captioned, tld.CaptionText(at, slot), *tld.Caption)
+
+	target := path
+	if !tld.Caption.Replace {
+		dir := filepath.Join(filepath.Dir(path), captionFolder)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("%s, %s os.MkdirAll: %v\n", sn, tld.Name, err)
+			return "", err
+		}
+		target = filepath.Join(dir, filepath.Base(path))
+	}
+
+	if err := SaveFrame(target, captioned, format, defaultQuality); err != nil {
+		log.Printf("%s, %s SaveFrame: %v\n", sn, tld.Name, err)
+		return "", err
+	}
+	return target, nil
+}
+
+// drawCaption draws text in the corner of dst selected by cd. Text is
+// rendered with a fixed 7x13 font, scaled up to the configured size, over a
+// translucent box or with a drop shadow so it stays legible on any sky.
+func drawCaption(dst *image.RGBA, text string, cd CaptionDef) {
+	face := basicfont.Face7x13
+	lineHeight := face.Ascent + face.Descent
+
+	scale := 1
+	if cd.FontSize > lineHeight {
+		scale = (cd.FontSize + lineHeight/2) / lineHeight
+	}
+
+	text = strings.Map(func(r rune) rune { // the font only covers ASCII
+		switch {
+		case r == 'ʻ' || r == '‘' || r == '’':
+			return '\''
+		case r > '~':
+			return '?'
+		}
+		return r
+	}, text)
+
+	// render at 1x into a mask, then scale with nearest-neighbour to keep the pixels crisp
+	textW := font.MeasureString(face, text).Ceil()
+	mask := image.NewAlpha(image.Rect(0, 0, textW, lineHeight))
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: face, Dot: fixed.P(0, face.Ascent)}
+	d.DrawString(text)
+
+	scaled := image.NewAlpha(image.Rect(0, 0, textW*scale, lineHeight*scale))
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			scaled.SetAlpha(x, y, mask.AlphaAt(x/scale, y/scale))
+		}
+	}
+
+	pad := 4 * scale
+	b := dst.Bounds()
+	boxW, boxH := scaled.Rect.Dx()+2*pad, scaled.Rect.Dy()+2*pad
+
+	origin := image.Point{b.Min.X, b.Max.Y - boxH} // bottom-left
+	switch cd.Position {
+	case "top-left":
+		origin = b.Min
+	case "top-right":
+		origin = image.Point{b.Max.X - boxW, b.Min.Y}
+	case "bottom-right":
+		origin = image.Point{b.Max.X - boxW, b.Max.Y - boxH}
+	}
+	box := image.Rectangle{origin, origin.Add(image.Point{boxW, boxH})}
+	textRect := box.Inset(pad)
+
+	if cd.Background {
+		draw.Draw(dst, box, &image.Uniform{color.RGBA{0, 0, 0, 160}}, image.Point{}, draw.Over)
+	} else {
+		shadow := textRect.Add(image.Point{scale, scale})
+		draw.DrawMask(dst, shadow, image.Black, image.Point{}, scaled, image.Point{}, draw.Over)
+	}
+	draw.DrawMask(dst, textRect, image.White, image.Point{}, scaled, image.Point{}, draw.Over)
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if necessary
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
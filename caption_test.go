@@ -0,0 +1,147 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSunElevation(t *testing.T) {
+	baseTLD := newBaseTLD()
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want float64
+	}{
+		{name: "sunrise", // apparent sunrise, refraction and solar radius put the center below the horizon
+			at:   time.Date(2020, 5, 27, 12, 39, 41, 0, time.UTC),
+			want: -0.8,
+		},
+		{name: "solar noon", // 90 - (latitude - declination)
+			at:   time.Date(2020, 5, 27, 20, 3, 28, 0, time.UTC),
+			want: 90 - (40.437787 - 21.4),
+		},
+		{name: "sunset",
+			at:   time.Date(2020, 5, 28, 3, 27, 15, 0, time.UTC),
+			want: -0.8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SunElevation(tt.at, baseTLD.Latitude, baseTLD.Longitude)
+			if got < tt.want-1 || got > tt.want+1 {
+				t.Errorf("SunElevation() got %.2f, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLDef_SlotLabel(t *testing.T) {
+	baseTLD := newBaseTLD()
+	baseTLD.SolarNoonUTC = solarNoon
+
+	firstLast30 := newBaseTLD()
+	firstLast30.FirstFlags = firstSunrise30
+	firstLast30.LastFlags = lastSunset30
+	firstLast30.Additional = 2
+	firstLast30.CaptureTimes = CaptureTimes{sunrise, sunrise.Add(mins60), solarNoon.Add(mins60), sunset}
+
+	tests := []struct {
+		name string
+		tld  TLDef
+		slot int
+		want string
+	}{
+		{name: "sunrise", tld: baseTLD, slot: 0, want: "sunrise"},
+		{name: "solar noon", tld: baseTLD, slot: 1, want: "solar noon"},
+		{name: "sunset", tld: baseTLD, slot: 2, want: "sunset"},
+		{name: "sunrise30", tld: firstLast30, slot: 0, want: "sunrise +30"},
+		{name: "additional", tld: firstLast30, slot: 2, want: "additional 2"},
+		{name: "sunset30", tld: firstLast30, slot: 3, want: "sunset -30"},
+		{name: "manual", tld: firstLast30, slot: manualSlot, want: "manual"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tld.SlotLabel(tt.slot); got != tt.want {
+				t.Errorf("TLDef.SlotLabel() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLDef_CaptionFrame(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		caption CaptionDef
+		want    string
+	}{
+		{name: "copy",
+			caption: CaptionDef{Position: "top-right", FontSize: 26, Background: true},
+			want:    filepath.Join(tld.FolderPath, captionFolder, tld.Name+" "+sunrise.Format(fileTimeLayout)),
+		},
+		{name: "replace",
+			caption: CaptionDef{Replace: true},
+			want:    filepath.Join(tld.FolderPath, tld.Name+" "+sunrise.Format(fileTimeLayout)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFrame(t, tld, sunrise, 400, 100, color.RGBA{0, 0, 128, 255})
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			caption := tt.caption
+			tld.Caption = &caption
+			got, err := tld.CaptionFrame(path, sunrise, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("TLDef.CaptionFrame() got %q, want %q", got, tt.want)
+			}
+
+			info, err := os.Stat(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0644 {
+				t.Errorf("TLDef.CaptionFrame() mode got %v, want %v", info.Mode().Perm(), os.FileMode(0644))
+			}
+			img, _, err := LoadFrame(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Brightness(img) <= 128*0.114 { // blue frame brightened by white text
+				t.Errorf("TLDef.CaptionFrame() caption not drawn, brightness %.2f", Brightness(img))
+			}
+			if !tt.caption.Replace {
+				after, _ := os.Stat(path)
+				if after.ModTime() != before.ModTime() || after.Size() != before.Size() {
+					t.Errorf("TLDef.CaptionFrame() modified the original")
+				}
+			}
+		})
+	}
+}
+
+func TestTLDef_CaptionText(t *testing.T) {
+	baseTLD := newBaseTLD()
+	baseTLD.WebcamLoc = time.UTC
+	baseTLD.SolarNoonUTC = solarNoon
+
+	got := baseTLD.CaptionText(time.Date(2020, 5, 27, 20, 3, 28, 0, time.UTC), 1)
+	for _, want := range []string{baseTLD.Name, "2020-05-27 20:03 UTC", "solar noon", "sun 71."} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TLDef.CaptionText() got %q, want substring %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// catch-up policies, for capture slots missed while the process was down or
+// the machine asleep
+const (
+	catchUpSkip   = "skip"   // missed slots are skipped, the default
+	catchUpGrace  = "grace"  // the most recent missed slot is captured if it is at most GraceMinutes late
+	catchUpLatest = "latest" // the most recent missed slot is captured, however late
+)
+
+const (
+	defaultGraceMinutes = 15
+	clockJumpThreshold  = time.Minute // wall clock drift between ticks reported as a clock jump
+)
+
+// CatchUpDef configures the capture of slots missed while the process was
+// down or the machine asleep
+type CatchUpDef struct {
+	Policy       string `json:"policy" validate:"omitempty,oneof=skip grace latest"` // default "skip"
+	GraceMinutes int    `json:"graceMinutes" validate:"omitempty,min=1,max=1440"`    // for "grace", default 15
+}
+
+// catches reports whether a slot missed at slot is captured at now
+func (cd *CatchUpDef) catches(slot, now time.Time) bool {
+	if cd == nil {
+		return false
+	}
+	switch cd.Policy {
+	case catchUpGrace:
+		grace := cd.GraceMinutes
+		if grace == 0 {
+			grace = defaultGraceMinutes
+		}
+		return now.Sub(slot) <= time.Duration(grace)*time.Minute
+	case catchUpLatest:
+		return true
+	}
+	return false
+}
+
+// MissedSlot returns the index in CaptureTimes of the most recent slot at
+// or before now, or -1 if there is none or it is in captured
+func (tld *TLDef) MissedSlot(now time.Time, captured []time.Time) int {
+	missed := -1
+	for i, t := range tld.CaptureTimes {
+		if t.After(now) {
+			break
+		}
+		missed = i
+	}
+	if missed >= 0 && capturedAt(captured, tld.CaptureTimes[missed]) {
+		return -1
+	}
+	return missed
+}
+
+// catchUp schedules the next capture of a webcam after a gap in its capture
+// loop, at startup or after the clock jumped: the most recent slot missed
+// during the gap is next if the catch-up policy captures it, otherwise the
+// first slot after now. If that's tomorrow and tomorrow's capture times can't
+// be set, the error of UpdateNextCapture is returned.
+func (s *server) catchUp(tld *TLDef, now time.Time) error {
+	sn := fmt.Sprintf("catchUp.%s", tld.Name)
+
+	var captured []time.Time
+	s.mu.Lock()
+	if st, ok := s.status[tld]; ok {
+		captured = append(captured, st.Captured...)
+	}
+	s.mu.Unlock()
+
+	missed := tld.MissedSlot(now, captured)
+	if missed >= 0 && tld.CatchUp.catches(tld.CaptureTimes[missed], now) {
+		tld.NextCapture = missed
+		log.Printf("%s, catching up %s slot of %s, %s late\n", sn, tld.SlotLabel(missed),
+			tld.CaptureTimes[missed].In(s.providers.Local).Format(time.Kitchen), now.Sub(tld.CaptureTimes[missed]).Truncate(time.Second))
+		return nil
+	}
+	if missed >= 0 {
+		log.Printf("%s, skipping %s slot of %s\n", sn, tld.SlotLabel(missed), tld.CaptureTimes[missed].In(s.providers.Local).Format(time.Kitchen))
+	}
+	return tld.UpdateNextCapture(now)
+}
+
+// clockJump returns how far the wall clock moved between ticks last and
+// now beyond the time elapsed, e.g., while the machine was suspended: the
+// monotonic clock stops during suspend, the wall clock doesn't
+func clockJump(last, now time.Time) time.Duration {
+	return now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+}
+
+// clockJumped reschedules the captures of a webcam after its capture go
+// routine detected a clock jump at now: a schedule of a previous day at
+// the webcam is replaced by today's, then the catch-up policy applies. If
+// the capture times can't be set, the error is returned.
+func (s *server) clockJumped(tld *TLDef, jump time.Duration, now time.Time) error {
+	sn := fmt.Sprintf("clockJumped.%s", tld.Name)
+
+	log.Printf("%s, wall clock jumped %s\n", sn, jump.Truncate(time.Second))
+	var err error
+	if n := len(tld.CaptureTimes); n > 0 && tld.dayOf(tld.CaptureTimes[n-1]) < tld.dayOf(now) {
+		err = tld.SetCaptureTimes(now)
+	}
+	if err == nil {
+		err = s.catchUp(tld, now)
+	}
+	s.publishNextCapture(tld)
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCatchUpDef_catches(t *testing.T) {
+	slot := time.Date(2020, 6, 1, 5, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		cd   *CatchUpDef
+		late time.Duration
+		want bool
+	}{
+		{"no policy", nil, time.Minute, false},
+		{"skip", &CatchUpDef{Policy: catchUpSkip}, time.Minute, false},
+		{"grace default, within", &CatchUpDef{Policy: catchUpGrace}, 15 * time.Minute, true},
+		{"grace default, beyond", &CatchUpDef{Policy: catchUpGrace}, 16 * time.Minute, false},
+		{"grace 2 hours", &CatchUpDef{Policy: catchUpGrace, GraceMinutes: 120}, 90 * time.Minute, true},
+		{"latest", &CatchUpDef{Policy: catchUpLatest}, 10 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cd.catches(slot, slot.Add(tt.late)); got != tt.want {
+				t.Errorf("catches() %s late got %t, want %t", tt.late, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatchUpDef_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cd      CatchUpDef
+		wantErr bool
+	}{
+		{"empty", CatchUpDef{}, false},
+		{"grace", CatchUpDef{Policy: catchUpGrace, GraceMinutes: 30}, false},
+		{"unknown policy", CatchUpDef{Policy: "always"}, true},
+		{"negative grace", CatchUpDef{Policy: catchUpGrace, GraceMinutes: -5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := srv.validate.Struct(tt.cd); (err != nil) != tt.wantErr {
+				t.Errorf("validate.Struct() error %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLDef_MissedSlot(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 6, 1, hour, min, 0, 0, srv.providers.Local)
+	}
+	tld := &TLDef{CaptureTimes: CaptureTimes{at(6, 0), at(12, 0), at(18, 0)}}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		captured []time.Time
+		want     int
+	}{
+		{"before the first slot", at(5, 0), nil, -1},
+		{"sunrise missed", at(7, 30), nil, 0},
+		{"most recent", at(13, 0), nil, 1},
+		{"most recent captured", at(13, 0), []time.Time{at(12, 0)}, -1},
+		{"earlier captured", at(13, 0), []time.Time{at(6, 0)}, 1},
+		{"at the slot", at(18, 0), nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tld.MissedSlot(tt.now, tt.captured); got != tt.want {
+				t.Errorf("MissedSlot() got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_catchUp(t *testing.T) {
+	s := newServerFor(&Config{path: srv.config.path})
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 6, 1, hour, min, 0, 0, s.providers.Local)
+	}
+	wake := at(7, 30) // asleep through the 6:00 sunrise slot
+
+	tests := []struct {
+		name     string
+		cd       *CatchUpDef
+		captured []time.Time
+		want     int
+	}{
+		{"skip", nil, nil, 1},
+		{"grace, too late", &CatchUpDef{Policy: catchUpGrace, GraceMinutes: 60}, nil, 1},
+		{"grace", &CatchUpDef{Policy: catchUpGrace, GraceMinutes: 120}, nil, 0},
+		{"latest", &CatchUpDef{Policy: catchUpLatest}, nil, 0},
+		{"latest, captured before sleeping", &CatchUpDef{Policy: catchUpLatest}, []time.Time{at(6, 0)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := &TLDef{Name: "asleep", CatchUp: tt.cd, CaptureTimes: CaptureTimes{at(6, 0), at(12, 0), at(18, 0)}}
+			s.status = map[*TLDef]*webcamStatus{tld: {Running: true, Captured: tt.captured}}
+
+			s.catchUp(tld, wake)
+			if tld.NextCapture != tt.want {
+				t.Errorf("catchUp() NextCapture %d, want %d", tld.NextCapture, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clockJump(t *testing.T) {
+	last := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if jump := clockJump(last, time.Now()); jump > clockJumpThreshold || jump < -clockJumpThreshold {
+		t.Errorf("clockJump() got %s without a jump", jump)
+	}
+}
+
+func Test_server_clockJumped_webcamDay(t *testing.T) {
+	hawaii := loadLocation(t, "Pacific/Honolulu")
+	california := loadLocation(t, "America/Los_Angeles")
+
+	s := newServerFor(&Config{path: srv.config.path})
+	s.providers.Local = hawaii // hours behind California, still 2020-06-01 after the jump
+	tld := newZoneTLD(california)
+	tld.providers.Local = hawaii
+	s.status = map[*TLDef]*webcamStatus{tld: {Running: true}}
+
+	today := time.Date(2020, 6, 1, 12, 0, 0, 0, california)
+	tld.providers.Clock = newFakeClock(today)
+	if err := tld.SetCaptureTimes(today); err != nil {
+		t.Fatal(err)
+	}
+
+	woke := time.Date(2020, 6, 2, 1, 30, 0, 0, california)
+	tld.providers.Clock = newFakeClock(woke)
+	if err := s.clockJumped(tld, woke.Sub(today), woke); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tld.NextCaptureTime(), time.Date(2020, 6, 2, 6, 30, 0, 0, california); !got.Equal(want) {
+		t.Errorf("clockJumped() next capture %s, want sunrise %s", got.In(california), want)
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/spf13/pflag"
+)
+
+// command is a subcommand run instead of the server, e.g.,
+// "timelapse list --json", for scripting from cron and CI
+type command struct {
+	name     string
+	args     string // positional arguments, for usage
+	summary  string
+	readDefs bool // read the definitions file before running
+	// setup defines the command's flags in fs, and returns the function
+	// running the command with the positional arguments once parsed
+	setup func(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error
+}
+
+// commands are the subcommands, in the order they are listed in usage
+var commands = []*command{
+	{name: "list", summary: "list the webcams", readDefs: true, setup: listCommand},
+	{name: "show", args: "<name>", summary: "show a webcam's definition and computed schedule", readDefs: true, setup: showCommand},
+	{name: "add", summary: "add a webcam, without capturing until the server is restarted", readDefs: true, setup: addCommand},
+	{name: "remove", args: "<name>", summary: "remove a webcam", readDefs: true, setup: removeCommand},
+	{name: "capture-now", args: "<name>", summary: "capture an image now, outside the schedule", readDefs: true, setup: captureNowCommand},
+	{name: "validate", args: "[file]", summary: "check the definitions file, without starting", setup: validateCommand},
+	{name: "render", args: "<name>", summary: "render a date range of captured images", readDefs: true, setup: renderCommand},
+	{name: "simulate", args: "<name>", summary: "replay a webcam's schedule over a date range, without capturing", readDefs: true, setup: simulateCommand},
+	{name: "healthcheck", summary: "check the health of the server running on --port, e.g., as a Docker HEALTHCHECK", setup: healthcheckCommand},
+}
+
+var errUsage = errors.New("invalid arguments")
+
+// findCommand returns the subcommand with the specified name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// printUsage writes the command line forms and the subcommands to w
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: timelapse [flags]                     run the server\n")
+	fmt.Fprintf(w, "       timelapse <command> [flags] [args]    run a command, see timelapse <command> -h\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nFlags:\n")
+}
+
+// runCommand runs the subcommand with its command line arguments,
+// returning the process exit status: 0 on success, 1 if the command
+// failed and 2 for invalid arguments
+func runCommand(cmd *command, args []string) int {
+	sn := "timelapse " + cmd.name
+
+	c := &Config{}
+	fs := pflag.NewFlagSet(cmd.name, pflag.ContinueOnError)
+	run := cmd.setup(fs)
+	c.addFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: timelapse %s [flags] %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	c.bind(fs)
+
+	srv = newServerFor(c) // TLDef methods use the server's configuration
+	if cmd.readDefs {
+		if err := srv.loadDefinitions(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", sn, err)
+			return 1
+		}
+	}
+
+	if err := run(srv, fs.Args(), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", sn, err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// oneName returns the single positional argument, the webcam name
+func oneName(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: want a webcam name", errUsage)
+	}
+	return args[0], nil
+}
+
+// webcamArg returns the webcam named by the single positional argument
+func (s *server) webcamArg(args []string) (*TLDef, error) {
+	name, err := oneName(args)
+	if err != nil {
+		return nil, err
+	}
+	tld := s.findWebcam(name)
+	if tld == nil {
+		return nil, fmt.Errorf("%q: %w", name, errWebcamNotFound)
+	}
+	return tld, nil
+}
+
+// addDateFlags defines the "date", "from" and "to" flags in fs, selecting
+// a date range in local as the query parameters of the same names do, see
+// dateRange
+func addDateFlags(fs *pflag.FlagSet) func(local *time.Location) (time.Time, time.Time, error) {
+	date := fs.String("date", "", "single day, YYYY-MM-DD (default today)")
+	from := fs.String("from", "", "first day, YYYY-MM-DD")
+	to := fs.String("to", "", "last day, YYYY-MM-DD (default from)")
+
+	return func(local *time.Location) (time.Time, time.Time, error) {
+		q := url.Values{}
+		for key, value := range map[string]string{"date": *date, "from": *from, "to": *to} {
+			if value != "" {
+				q[key] = []string{value}
+			}
+		}
+		return dateRange(q, local)
+	}
+}
+
+// writeIndented writes v to out as indented JSON
+func writeIndented(out io.Writer, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", buf)
+	return err
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// listCommand is "timelapse list [--json]"
+func listCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	asJSON := fs.Bool("json", false, "write the definitions as JSON")
+
+	return func(s *server, args []string, out io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: list takes no arguments", errUsage)
+		}
+		if *asJSON {
+			return writeIndented(out, s.mtld)
+		}
+
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "NAME\tSTATE\tFIRST\tLAST\tADDITIONAL\tFOLDER\n")
+		for _, tld := range *s.mtld {
+			state := "capturing"
+			if tld.Paused {
+				state = "paused"
+			}
+			p := page{Form: tld}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", tld.Name, state, p.Choice("first"), p.Choice("last"), tld.Additional, tld.FolderPath)
+		}
+		return tw.Flush()
+	}
+}
+
+// showCommand is "timelapse show <name> [--date | --from --to] [--json]"
+func showCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	dates := addDateFlags(fs)
+	asJSON := fs.Bool("json", false, "write the definition and schedule as JSON")
+
+	return func(s *server, args []string, out io.Writer) error {
+		tld, err := s.webcamArg(args)
+		if err != nil {
+			return err
+		}
+		from, to, err := dates(s.providers.Local)
+		if err != nil {
+			return err
+		}
+		if err := checkPreviewDays(from, to); err != nil {
+			return err
+		}
+
+		sched, err := tld.Schedule(from, to)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeIndented(out, struct {
+				Webcam   *TLDef    `json:"webcam"`
+				Schedule *Schedule `json:"schedule"`
+			}{tld, sched})
+		}
+
+		p := page{Form: tld}
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "Name:\t%s\n", tld.Name)
+		fmt.Fprintf(tw, "Webcam URL:\t%s\n", tld.URL)
+		fmt.Fprintf(tw, "Location:\t%f, %f\n", tld.Latitude, tld.Longitude)
+		fmt.Fprintf(tw, "Time zone:\t%s\n", sched.WebcamTZ)
+		fmt.Fprintf(tw, "First capture:\t%s\n", p.Choice("first"))
+		fmt.Fprintf(tw, "Last capture:\t%s\n", p.Choice("last"))
+		fmt.Fprintf(tw, "Additional:\t%d\n", tld.Additional)
+		fmt.Fprintf(tw, "Folder:\t%s\n", tld.FolderPath)
+		fmt.Fprintf(tw, "Paused:\t%t\n", tld.Paused)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		for _, day := range sched.Days {
+			fmt.Fprintf(out, "\n%s  sunrise %s  solar noon %s  sunset %s\n", day.Date,
+				day.Sunrise.Format("15:04 MST"), day.SolarNoon.Format("15:04 MST"), day.Sunset.Format("15:04 MST"))
+			for _, a := range day.Anomalies {
+				fmt.Fprintf(out, "  ! %s\n", a)
+			}
+			tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+			for _, c := range day.Captures {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Slot, c.Webcam.Format("15:04:05 MST"), c.Server.Format("15:04:05 MST"))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addCommand is "timelapse add --name ... --url ... --lat ... --lon ...",
+// or "timelapse add --json <file>" with the definition as the API takes it
+func addCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	fromFile := fs.String("json", "", `read the definition from a JSON file, "-" for standard input`)
+	tld := newTLDef()
+	fs.StringVar(&tld.Name, "name", "", "name of the webcam")
+	fs.StringVar(&tld.URL, "url", "", "URL of the webcam image")
+	fs.Float64Var(&tld.Latitude, "lat", 0, "latitude of the webcam")
+	fs.Float64Var(&tld.Longitude, "lon", 0, "longitude of the webcam")
+	first := fs.String("first", "sunrise", "first capture: sunrise, sunrise30 or sunrise60")
+	last := fs.String("last", "sunset", "last capture: sunset, sunset30 or sunset60")
+	fs.IntVar(&tld.Additional, "additional", 0, "additional captures per day, 0-16")
+	fs.StringVar(&tld.FolderPath, "folder", "", "folder to store captures in")
+	fs.BoolVar(&tld.Paused, "paused", false, "add the webcam paused")
+
+	return func(s *server, args []string, out io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: add takes flags only", errUsage)
+		}
+
+		if *fromFile != "" {
+			var err error
+			if tld, err = readTLDef(*fromFile); err != nil {
+				return err
+			}
+		} else {
+			for value, choices := range map[string]map[string]func(tld *TLDef) *bool{*first: firstChoices, *last: lastChoices} {
+				field, ok := choices[value]
+				if !ok {
+					return fmt.Errorf("%w: %q is not a first or last capture choice", errUsage, value)
+				}
+				*field(tld) = true
+			}
+		}
+
+		if err := s.validateTLDef(tld); err != nil {
+			return err
+		}
+		if err := s.requireProbe(context.Background(), tld); err != nil {
+			return err
+		}
+		if err := s.saveWebcam(tld); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "added %s, capturing to %s\n", tld.Name, tld.FolderPath)
+		return nil
+	}
+}
+
+// readTLDef decodes a TLDef from a JSON file, "-" for standard input,
+// rejecting unknown fields as the API does
+func readTLDef(path string) (*TLDef, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	tld := newTLDef()
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(tld); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tld, nil
+}
+
+// removeCommand is "timelapse remove <name> [--frames]"
+func removeCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	frames := fs.Bool("frames", false, "also remove the captured images")
+
+	return func(s *server, args []string, out io.Writer) error {
+		name, err := oneName(args)
+		if err != nil {
+			return err
+		}
+		if err := s.removeWebcam(name, *frames); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "removed %s\n", name)
+		return nil
+	}
+}
+
+// captureNowCommand is "timelapse capture-now <name>", capturing and
+// post-processing an image as the capture go routine does, whether or not
+// the webcam is paused
+func captureNowCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	return func(s *server, args []string, out io.Writer) error {
+		tld, err := s.webcamArg(args)
+		if err != nil {
+			return err
+		}
+		if err := s.config.resolveFolder(tld); err != nil {
+			return err
+		}
+		if err := makeFolder(tld); err != nil {
+			return err
+		}
+
+		now := TimeToSecond(time.Now())
+		tld.CaptureTimes = CaptureTimes{now}
+		tld.NextCapture = 0
+		path, size, err := tld.CaptureImage(s.drain)
+		if err != nil {
+			return err
+		}
+		if err := tld.PostProcess(path, now, manualSlot); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "%s\t%s\n", path, datasize.ByteSize(size).HumanReadable())
+		return nil
+	}
+}
+
+// validateCommand is "timelapse validate [file]", checking every definition
+// as the API checks a new one, and reporting all the problems found
+func validateCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	return func(s *server, args []string, out io.Writer) error {
+		path := s.definitionsPath()
+		switch len(args) {
+		case 0:
+		case 1:
+			path = args[0]
+		default:
+			return fmt.Errorf("%w: want at most one file", errUsage)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var defs masterTLDefs
+		if err := json.Unmarshal(data, &defs); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		invalid := 0
+		names := make(map[string]bool)
+		for i, tld := range defs {
+			label := strconv.Itoa(i + 1)
+			if tld.Name != "" {
+				label += " (" + tld.Name + ")"
+			}
+			if names[tld.Name] {
+				fmt.Fprintf(out, "%s: definition %s: %v\n", path, label, errWebcamExists)
+				invalid++
+				continue
+			}
+			names[tld.Name] = true
+			if err := s.validateTLDef(tld); err != nil {
+				fmt.Fprintf(out, "%s: definition %s: %v\n", path, label, err)
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%s: %d of %d definitions invalid", path, invalid, len(defs))
+		}
+
+		fmt.Fprintf(out, "%s: %d definitions OK\n", path, len(defs))
+		return nil
+	}
+}
+
+// renderCommand is "timelapse render <name> [--date | --from --to]
+// [--maxshift --maxangle --deflicker --gif] [--json]", rendering as
+// "POST /webcams/:name/render" does
+func renderCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	dates := addDateFlags(fs)
+	options := map[string]*string{
+		"maxshift":  fs.String("maxshift", "", "largest camera shift corrected, 0-128 pixels, enables stabilizing"),
+		"maxangle":  fs.String("maxangle", "", "largest camera rotation corrected, 0-10 degrees, enables stabilizing"),
+		"deflicker": fs.String("deflicker", "", "deflicker smoothing window, an odd number of frames"),
+		"gif":       fs.String("gif", "", "also assemble an animated GIF, with this delay between frames in 100ths of a second"),
+	}
+	asJSON := fs.Bool("json", false, "write the render result as JSON")
+
+	return func(s *server, args []string, out io.Writer) error {
+		tld, err := s.webcamArg(args)
+		if err != nil {
+			return err
+		}
+		if err := s.config.resolveFolder(tld); err != nil {
+			return err
+		}
+		from, to, err := dates(s.providers.Local)
+		if err != nil {
+			return err
+		}
+
+		q := url.Values{}
+		for key, value := range options {
+			if *value != "" {
+				q[key] = []string{*value}
+			}
+		}
+		opts, err := renderOptions(tld, from, to, q)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+
+		result, err := tld.Render(opts)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeIndented(out, result)
+		}
+
+		fmt.Fprintf(out, "rendered %d frames to %s\n", len(result.Frames), result.Output)
+		for _, file := range []string{result.GIF, result.Brightness} {
+			if file != "" {
+				fmt.Fprintf(out, "%s\n", filepath.Join(result.Output, file))
+			}
+		}
+		return nil
+	}
+}
+
+// simulateCommand is "timelapse simulate <name> [--date | --from --to]
+// [--json]", writing the replayed schedule as CSV, or JSON, as
+// "GET /webcams/:name/simulate.csv" does
+func simulateCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	dates := addDateFlags(fs)
+	asJSON := fs.Bool("json", false, "write the schedule as JSON instead of CSV")
+
+	return func(s *server, args []string, out io.Writer) error {
+		tld, err := s.webcamArg(args)
+		if err != nil {
+			return err
+		}
+		from, to, err := dates(s.providers.Local)
+		if err != nil {
+			return err
+		}
+		if err := checkSimulateDays(from, to); err != nil {
+			return err
+		}
+
+		sched, err := tld.Simulate(from, to)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeIndented(out, sched)
+		}
+		return writeScheduleCSV(out, sched)
+	}
+}
+
+// healthcheckCommand is "timelapse healthcheck [--ready]", failing unless
+// the server listening on the configured port reports "/healthz", or
+// "/readyz", ok. It needs no shell or curl, so it works in a scratch image.
+func healthcheckCommand(fs *pflag.FlagSet) func(s *server, args []string, out io.Writer) error {
+	ready := fs.Bool("ready", false, "check /readyz instead of /healthz")
+
+	return func(s *server, args []string, out io.Writer) error {
+		if len(args) != 0 {
+			return fmt.Errorf("%w: healthcheck takes no arguments", errUsage)
+		}
+		path := "/healthz"
+		if *ready {
+			path = "/readyz"
+		}
+
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get("http://127.0.0.1:" + s.config.port + path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", path, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+// runTestCommand parses args as the named subcommand does and runs it
+// against srv, returning its output
+func runTestCommand(t *testing.T, name string, args ...string) (string, error) {
+	t.Helper()
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		t.Fatalf("findCommand(%q) got nil", name)
+	}
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	run := cmd.setup(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := run(srv, fs.Args(), &out)
+	return out.String(), err
+}
+
+func Test_listCommand(t *testing.T) {
+	out, err := runTestCommand(t, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "NAME") || !strings.Contains(out, "Manzanita Lake") {
+		t.Errorf("list got %q, want a table of the webcams", out)
+	}
+
+	out, err = runTestCommand(t, "list", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list masterTLDefs
+	if err := json.Unmarshal([]byte(out), &list); err != nil || len(list) != len(*srv.mtld) {
+		t.Errorf("list --json got %d definitions, %v, want %d", len(list), err, len(*srv.mtld))
+	}
+
+	if _, err := runTestCommand(t, "list", "extra"); !errors.Is(err, errUsage) {
+		t.Errorf("list with an argument got %v, want %v", err, errUsage)
+	}
+}
+
+func Test_addCommand_removeCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timelapse-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	folder := filepath.Join(dir, "testCLI")
+
+	add := []string{"--name", "testCLI", "--url", "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg",
+		"--lat", "40.437787", "--lon", "-121.5360307", "--first", "sunrise30", "--additional", "2", "--folder", folder, "--paused"}
+	if _, err := runTestCommand(t, "add", add...); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.removeWebcam("testCLI", false)
+
+	tld := srv.findWebcam("testCLI")
+	if tld == nil || !tld.FirstSunrise30 || !tld.LastSunset || !tld.Paused || tld.Additional != 2 {
+		t.Fatalf("add got %+v", tld)
+	}
+	if _, err := os.Stat(folder); err != nil {
+		t.Errorf("add didn't create the folder: %v", err)
+	}
+	if srv.webcamStatus(tld).Running {
+		t.Errorf("add started capturing")
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "duplicate", args: add, wantErr: errWebcamExists},
+		{name: "bad choice", args: []string{"--name", "x", "--first", "noon"}, wantErr: errUsage},
+		{name: "positional", args: []string{"x"}, wantErr: errUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := runTestCommand(t, "add", tt.args...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("add got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := runTestCommand(t, "add", "--name", "invalid"); err == nil {
+		t.Errorf("add without URL got no error")
+	}
+
+	out, err := runTestCommand(t, "remove", "testCLI")
+	if err != nil || out != "removed testCLI\n" {
+		t.Errorf("remove got %q, %v", out, err)
+	}
+	if _, err := runTestCommand(t, "remove", "testCLI"); !errors.Is(err, errWebcamNotFound) {
+		t.Errorf("remove again got %v, want %v", err, errWebcamNotFound)
+	}
+}
+
+func Test_validateCommand(t *testing.T) {
+	out, err := runTestCommand(t, "validate")
+	if err != nil || !strings.Contains(out, "definitions OK") {
+		t.Errorf("validate got %q, %v", out, err)
+	}
+
+	f, err := ioutil.TempFile("", "timelapse-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	valid := `{"name":"a","webcamUrl":"https://example.com/a.jpg","latitude":40,"longitude":-121,"firstSunrise":true,"lastSunset":true,"folder":"/tmp/a"}`
+	f.WriteString(`[` + valid + `,` + valid + `,{"name":"b","webcamUrl":"https://example.com/b.jpg","latitude":40,"longitude":-121,"firstSunrise":true,"lastSunset":true,"additional":20,"folder":"/tmp/b"}]`)
+	f.Close()
+
+	out, err = runTestCommand(t, "validate", f.Name())
+	if err == nil || !strings.Contains(err.Error(), "2 of 3 definitions invalid") {
+		t.Errorf("validate got %v, want 2 of 3 invalid", err)
+	}
+	for _, want := range []string{"definition 2 (a): webcam already exists", "definition 3 (b): Additional must be 0-16"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("validate got %q, want %q", out, want)
+		}
+	}
+}
+
+func Test_captureNowCommand(t *testing.T) {
+	ts := newWebcamServer()
+	defer ts.Close()
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.URL = ts.URL + "/webcam.png"
+
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	out, err := runTestCommand(t, "capture-now", tld.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.SplitN(out, "\t", 2)[0]
+	if filepath.Dir(path) != tld.FolderPath || !strings.HasPrefix(filepath.Base(path), tld.Name+" ") {
+		t.Errorf("capture-now got %q, want a capture in %s", out, tld.FolderPath)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+		t.Errorf("capture-now stored nothing at %s: %v", path, err)
+	}
+
+	if _, err := runTestCommand(t, "capture-now"); !errors.Is(err, errUsage) {
+		t.Errorf("capture-now without name got %v, want %v", err, errUsage)
+	}
+}
+
+func Test_renderCommand(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	writeGalleryFrames(t, tld)
+
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	out, err := runTestCommand(t, "render", tld.Name, "--from", "2020-05-27", "--to", "2020-05-28", "--gif", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(tld.FolderPath, renderFolder, "2020-05-27_2020-05-28")
+	if !strings.HasPrefix(out, "rendered 4 frames to "+output) || !strings.Contains(out, "timelapse.gif") {
+		t.Errorf("render got %q", out)
+	}
+
+	if _, err := runTestCommand(t, "render", tld.Name, "--deflicker", "-1"); !errors.Is(err, errUsage) {
+		t.Errorf("render with invalid deflicker got %v, want %v", err, errUsage)
+	}
+}
+
+func Test_simulateCommand(t *testing.T) {
+	tld := newSimulateTLD(fakeSolar(fakeSolarTimes))
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	out, err := runTestCommand(t, "simulate", tld.Name, "--from", "2020-06-01", "--to", "2020-06-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 7 || lines[0] != "date,slot,webcam,server,anomalies" {
+		t.Errorf("simulate got %q, want a header and 6 captures", out)
+	}
+
+	out, err = runTestCommand(t, "simulate", tld.Name, "--date", "2020-06-01", "--json")
+	var sched Schedule
+	if err != nil || json.Unmarshal([]byte(out), &sched) != nil || len(sched.Days) != 1 {
+		t.Errorf("simulate --json got %q, %v", out, err)
+	}
+
+	if _, err := runTestCommand(t, "simulate"); !errors.Is(err, errUsage) {
+		t.Errorf("simulate without name got %v, want %v", err, errUsage)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// Clock tells the time, and waits. The schedule and the capture go routines
+// read the time from the Clock of their Providers, so tests can drive them
+// with a fake clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time // like time.After
+}
+
+// systemClock is the Clock of the system
+type systemClock struct{}
+
+// Now implements Clock
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements Clock
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
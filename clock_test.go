@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a channel returned by fakeClock.After
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+// Now implements Clock
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After implements Clock
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the channels returned by
+// After that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiting
+}
+
+// BlockUntil waits until n channels returned by After are pending, i.e.,
+// the go routines using the clock are waiting for it
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.waiters)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("BlockUntil(%d), %d waiting", n, pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func Test_server_capture(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.Name = "fake clock"
+	tld.CaptureTimes = CaptureTimes{}
+
+	s := newServerFor(&Config{path: srv.config.path, pollSecs: 300})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	start := time.Date(2020, 6, 1, 4, 0, 0, 0, s.providers.Local)
+	clock := newFakeClock(start)
+	images := &fakeImages{fail: 1} // the first attempt fails, and is retried
+	s.providers = newFakeProviders(clock, images)
+
+	s.startCapture(tld)
+	end := start.AddDate(0, 0, 2)
+	for clock.Now().Before(end) {
+		clock.BlockUntil(t, 1)
+		clock.Advance(time.Duration(s.config.pollSecs) * time.Second)
+	}
+	clock.BlockUntil(t, 1)
+	s.stopCapture(tld)
+
+	frames, err := tld.Frames(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []time.Time
+	for day := 1; day <= 2; day++ {
+		for _, hour := range []int{6, 12, 18} { // sunrise, solar noon and sunset of fakeSolarTimes
+			want = append(want, time.Date(2020, 6, day, hour, 0, 0, 0, s.providers.Local))
+		}
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("captured %d frames %v, want %d", len(frames), frames, len(want))
+	}
+	for i, f := range frames {
+		if !f.Time.Equal(want[i]) {
+			t.Errorf("frame %d captured for %s, want %s", i, f.Time, want[i])
+		}
+	}
+	if got := images.count(); got != len(want)+1 {
+		t.Errorf("%d image requests, want %d", got, len(want)+1)
+	}
+}
+
+func Test_server_capture_scheduleRetry(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.Name = "fake clock retry"
+	tld.CaptureTimes = CaptureTimes{}
+
+	s := newServerFor(&Config{path: srv.config.path, pollSecs: 300})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	start := time.Date(2020, 6, 1, 4, 0, 0, 0, s.providers.Local)
+	clock := newFakeClock(start)
+	s.providers = newFakeProviders(clock, &fakeImages{})
+	// the solar lookups fail at startup, and after the last capture of the
+	// first day, when setting the second day's capture times
+	s.providers.Solar = fakeSolar(func(date time.Time) (SolarTimes, error) {
+		now := clock.Now()
+		if now.Before(start.Add(30*time.Minute)) || (now.After(start.Add(14*time.Hour)) && now.Before(start.Add(15*time.Hour))) {
+			return SolarTimes{}, errors.New("sunrise-sunset.org unreachable")
+		}
+		return fakeSolarTimes(date)
+	})
+
+	s.startCapture(tld)
+	clock.BlockUntil(t, 1)
+	if st := s.webcamStatus(tld); !st.NextCapture.IsZero() {
+		t.Errorf("status without capture times got next capture %s", st.NextCapture)
+	}
+	end := start.AddDate(0, 0, 2)
+	for clock.Now().Before(end) {
+		clock.BlockUntil(t, 1)
+		clock.Advance(time.Duration(s.config.pollSecs) * time.Second)
+	}
+	clock.BlockUntil(t, 1)
+	s.stopCapture(tld)
+
+	frames, err := tld.Frames(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 6 {
+		t.Errorf("captured %d frames %v, want the 6 of both days", len(frames), frames)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// fallbacks, for the days first and last capture can't be computed from
+// sunrise and sunset
+const (
+	fallbackNoon  = "solarNoon" // capture at solar noon only, the default
+	fallbackFixed = "fixed"     // capture at FirstAt and LastAt, webcam time, with the additional captures between them
+	fallbackSkip  = "skip"      // capture nothing that day
+)
+
+const (
+	clockLayout    = "15:04" // layout of FallbackDef times
+	maxSkippedDays = 366     // days SetCaptureTimes looks ahead for one with captures
+	maxSkipLookups = 14      // solar lookups SetCaptureTimes makes looking ahead, see estimatedPolar
+	polarMargin    = 2.0     // degrees the sun must be estimated below or above the horizon all day
+)
+
+// noCapturesError reports that the fallback policy skips every day from
+// the one SetCaptureTimes was asked for until Until
+type noCapturesError struct {
+	Name  string
+	Until time.Time // start of the first day not looked at, the webcam's
+}
+
+func (e *noCapturesError) Error() string {
+	return fmt.Sprintf("%s: no captures until %s", e.Name, e.Until.Format(dateLayout))
+}
+
+// FallbackDef configures the captures of the days sunrise and sunset don't
+// give a first and last capture: polar day and polar night, when the sun
+// doesn't rise or set, and days so short the first capture isn't before the
+// last, e.g., sunrise +60 and sunset -60 in winter at high latitudes
+type FallbackDef struct {
+	Policy  string `json:"policy" validate:"omitempty,oneof=solarNoon fixed skip"` // default "solarNoon"
+	FirstAt string `json:"firstAt,omitempty"`                                      // for "fixed", e.g., "09:00"
+	LastAt  string `json:"lastAt,omitempty"`                                       // for "fixed", e.g., "15:00"
+}
+
+// policy returns the fallback policy, the default if there is none
+func (fd *FallbackDef) policy() string {
+	if fd == nil || fd.Policy == "" {
+		return fallbackNoon
+	}
+	return fd.Policy
+}
+
+// Check reports an invalid FallbackDef: the fixed times must be "HH:MM",
+// the first before the last
+func (fd *FallbackDef) Check() error {
+	if fd.policy() != fallbackFixed {
+		return nil
+	}
+	first, err := time.Parse(clockLayout, fd.FirstAt)
+	if err != nil {
+		return fmt.Errorf("fallback firstAt %q must be HH:MM", fd.FirstAt)
+	}
+	last, err := time.Parse(clockLayout, fd.LastAt)
+	if err != nil {
+		return fmt.Errorf("fallback lastAt %q must be HH:MM", fd.LastAt)
+	}
+	if !first.Before(last) {
+		return fmt.Errorf("fallback firstAt %s must be before lastAt %s", fd.FirstAt, fd.LastAt)
+	}
+	return nil
+}
+
+// fixedTimes returns the fixed first and last capture times on date, in
+// the webcam's time zone
+func (fd *FallbackDef) fixedTimes(date time.Time, loc *time.Location) (time.Time, time.Time) {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse(clockLayout, clock) // checked by Check
+		return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	}
+	return at(fd.FirstAt), at(fd.LastAt)
+}
+
+// description describes the policy, for warnings
+func (fd *FallbackDef) description() string {
+	switch fd.policy() {
+	case fallbackFixed:
+		return fmt.Sprintf("falling back to fixed times %s-%s", fd.FirstAt, fd.LastAt)
+	case fallbackSkip:
+		return "skipping the day"
+	}
+	return "falling back to solar noon only"
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// noSolarTime reports whether t is not a time of the day, but the sentinel
+// sunrise-sunset.org returns when the sun doesn't rise or set,
+// 1970-01-01T00:00:01+00:00
+func noSolarTime(t time.Time) bool {
+	return t.Before(time.Unix(24*60*60, 0))
+}
+
+// declination returns the approximate solar declination on the day of t,
+// in degrees, within a degree or so
+func declination(t time.Time) float64 {
+	return -23.44 * math.Cos(2*math.Pi/365*float64(t.YearDay()+10))
+}
+
+// sunUpAtNoon reports whether the sun is above the horizon at solar noon,
+// at latitude lat on the day of noon; polar day if it doesn't set
+func sunUpAtNoon(lat float64, noon time.Time) bool {
+	return math.Abs(lat-declination(noon)) < 90
+}
+
+// estimatedPolar reports whether the sun stays below the horizon (polar
+// night) or above it (polar day) all day at latitude lat on the day of
+// date, by more than polarMargin, so the day needs no solar lookup to tell.
+// Refraction and the approximate declination account for the margin.
+func estimatedPolar(lat float64, date time.Time) bool {
+	d := declination(date)
+	noon := 90 - math.Abs(lat-d)     // altitude of the sun at solar noon
+	midnight := math.Abs(lat+d) - 90 // and at midnight
+	return noon < -polarMargin || midnight > polarMargin
+}
+
+// firstLast returns the first and last capture times computed from the
+// solar times, in the order configured
+func (tld *TLDef) firstLast() (time.Time, time.Time, error) {
+	scratch := *tld
+	scratch.CaptureTimes = []time.Time{}
+	if err := scratch.SetFirstCapture(); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if err := scratch.SetLastCapture(); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(scratch.CaptureTimes) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("first and last capture times required")
+	}
+	return scratch.CaptureTimes[0], scratch.CaptureTimes[1], nil
+}
+
+// irregularDay describes why the solar times of the day don't give a first
+// capture before the last, or returns ""
+func (tld *TLDef) irregularDay() string {
+	if noSolarTime(tld.SunriseUTC) || noSolarTime(tld.SunsetUTC) {
+		if sunUpAtNoon(tld.Latitude, tld.SolarNoonUTC) {
+			return "polar day"
+		}
+		return "polar night"
+	}
+
+	first, last, err := tld.firstLast()
+	if err == nil && !first.Before(last) {
+		at := func(t time.Time) string { return t.In(tld.webcamLoc()).Format("15:04 MST") }
+		return fmt.Sprintf("short day, first capture (%s) at %s is not before last capture (%s) at %s",
+			tld.firstLabel(), at(first), tld.lastLabel(), at(last))
+	}
+	return ""
+}
+
+// setFallbackCaptureTimes sets the (empty) CaptureTimes of an irregular day
+// according to the Fallback policy, recording why in warning
+func (tld *TLDef) setFallbackCaptureTimes(date time.Time, why string) {
+	sn := "setFallbackCaptureTimes"
+
+	tld.fallback = tld.Fallback.policy()
+	tld.warning = fmt.Sprintf("%s, %s", why, tld.Fallback.description())
+	log.Printf("%s, %s %s: %s\n", sn, tld.Name, date.Format(dateLayout), tld.warning)
+
+	switch tld.fallback {
+	case fallbackFixed:
+		first, last := tld.Fallback.fixedTimes(date, tld.webcamLoc())
+		tld.addCaptureTimes(first, last)
+	case fallbackNoon:
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SolarNoonUTC.In(tld.webcamLoc()))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// polarSolar is a fakeSolar for the days the sun doesn't rise or set, as
+// sunrise-sunset.org returns them, with solar noon at 12:00 where the code
+// is running
+func polarSolar(date time.Time) (SolarTimes, error) {
+	never := time.Date(1970, 1, 1, 0, 0, 1, 0, time.UTC)
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, srv.providers.Local).UTC()
+	return SolarTimes{Sunrise: never, SolarNoon: noon, Sunset: never}, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func TestFallbackDef_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		fd      *FallbackDef
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"solar noon", &FallbackDef{Policy: fallbackNoon}, false},
+		{"fixed", &FallbackDef{Policy: fallbackFixed, FirstAt: "09:00", LastAt: "15:30"}, false},
+		{"fixed without times", &FallbackDef{Policy: fallbackFixed}, true},
+		{"fixed invalid time", &FallbackDef{Policy: fallbackFixed, FirstAt: "9am", LastAt: "15:00"}, true},
+		{"fixed first after last", &FallbackDef{Policy: fallbackFixed, FirstAt: "15:00", LastAt: "09:00"}, true},
+		{"fixed first at last", &FallbackDef{Policy: fallbackFixed, FirstAt: "12:00", LastAt: "12:00"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fd.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := srv.validate.Struct(FallbackDef{Policy: "sometimes"}); err == nil {
+		t.Errorf("validate.Struct() of an unknown policy got nil")
+	}
+}
+
+func Test_sunUpAtNoon(t *testing.T) {
+	tests := []struct {
+		name string
+		lat  float64
+		date time.Time
+		want bool
+	}{
+		{"arctic summer", 78.2, time.Date(2020, 6, 21, 12, 0, 0, 0, time.UTC), true},
+		{"arctic winter", 78.2, time.Date(2020, 12, 21, 12, 0, 0, 0, time.UTC), false},
+		{"antarctic summer", -77.8, time.Date(2020, 12, 21, 12, 0, 0, 0, time.UTC), true},
+		{"antarctic winter", -77.8, time.Date(2020, 6, 21, 12, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sunUpAtNoon(tt.lat, tt.date); got != tt.want {
+				t.Errorf("sunUpAtNoon(%v, %s) got %t, want %t", tt.lat, tt.date.Format(dateLayout), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLDef_SetDayCaptureTimes_fallback(t *testing.T) {
+	at := func(date time.Time, hour int) time.Time {
+		return time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, srv.providers.Local)
+	}
+	summer := time.Date(2020, 6, 21, 0, 0, 0, 0, srv.providers.Local)
+	winter := time.Date(2020, 12, 21, 0, 0, 0, 0, srv.providers.Local)
+
+	tests := []struct {
+		name        string
+		solar       fakeSolar
+		date        time.Time
+		fallback    *FallbackDef
+		additional  int
+		wantHours   []int
+		wantLabels  []string
+		wantWarning string
+	}{
+		{"regular day", solarHours(5, 12, 19), summer, nil, 1,
+			[]int{6, 12, 18}, []string{"sunrise +60", "solar noon", "sunset -60"}, ""},
+		{"polar day", polarSolar, summer, nil, 1,
+			[]int{12}, []string{"solar noon"}, "polar day, falling back to solar noon only"},
+		{"polar night", polarSolar, winter, &FallbackDef{Policy: fallbackNoon}, 1,
+			[]int{12}, []string{"solar noon"}, "polar night, falling back to solar noon only"},
+		{"polar night, fixed", polarSolar, winter, &FallbackDef{Policy: fallbackFixed, FirstAt: "09:00", LastAt: "15:00"}, 1,
+			[]int{9, 12, 15}, []string{"first", "solar noon", "last"}, "polar night, falling back to fixed times 09:00-15:00"},
+		{"polar night, skip", polarSolar, winter, &FallbackDef{Policy: fallbackSkip}, 1,
+			[]int{}, []string{}, "polar night, skipping the day"},
+		{"short day", solarHours(11, 12, 13), winter, nil, 0,
+			[]int{12}, []string{"solar noon"}, "short day, first capture (sunrise +60) at 12:00"},
+		{"short day, fixed", solarHours(11, 12, 13), winter, &FallbackDef{Policy: fallbackFixed, FirstAt: "10:00", LastAt: "14:00"}, 3,
+			[]int{10, 11, 12, 13, 14}, []string{"first", "additional 1", "solar noon", "additional 3", "last"}, "short day"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := newSimulateTLD(tt.solar)
+			tld.Latitude = 78.2
+			tld.FirstFlags, tld.LastFlags = firstSunrise60, lastSunset60
+			tld.Fallback = tt.fallback
+			tld.Additional = tt.additional
+			if err := tld.SetWebcamTZ(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tld.SetDayCaptureTimes(tt.date); err != nil {
+				t.Fatal(err)
+			}
+			if len(tld.CaptureTimes) != len(tt.wantHours) {
+				t.Fatalf("SetDayCaptureTimes() got %v, want hours %v", tld.CaptureTimes, tt.wantHours)
+			}
+			for i, ct := range tld.CaptureTimes {
+				if want := at(tt.date, tt.wantHours[i]); !ct.Equal(want) {
+					t.Errorf("SetDayCaptureTimes() capture %d at %s, want %s", i, ct, want)
+				}
+				if got := tld.SlotLabel(i); got != tt.wantLabels[i] {
+					t.Errorf("SlotLabel(%d) got %q, want %q", i, got, tt.wantLabels[i])
+				}
+			}
+			if !strings.HasPrefix(tld.warning, tt.wantWarning) || (tt.wantWarning == "") != (tld.warning == "") {
+				t.Errorf("SetDayCaptureTimes() warning %q, want %q", tld.warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestTLDef_SetCaptureTimes_skip(t *testing.T) {
+	dawn := time.Date(2020, 6, 3, 0, 0, 0, 0, srv.providers.Local) // the sun rises again
+	tld := newSimulateTLD(func(date time.Time) (SolarTimes, error) {
+		if date.Before(dawn) {
+			return polarSolar(date)
+		}
+		return fakeSolarTimes(date)
+	})
+	tld.Latitude = 66.0 // too close to the Arctic circle to tell polar days without a lookup
+	tld.Fallback = &FallbackDef{Policy: fallbackSkip}
+	var lookups int
+	tld.providers.Lookups = func(provider string, start time.Time, err error) {
+		if provider == lookupSolar {
+			lookups++
+		}
+	}
+
+	if err := tld.SetCaptureTimes(time.Date(2020, 6, 1, 0, 0, 0, 0, srv.providers.Local)); err != nil {
+		t.Fatal(err)
+	}
+	if len(tld.CaptureTimes) != 3 || tld.CaptureTimes[0].Format(dateLayout) != "2020-06-03" || tld.warning != "" {
+		t.Errorf("SetCaptureTimes() got %v, %q, want the captures of 2020-06-03", tld.CaptureTimes, tld.warning)
+	}
+
+	// looking ahead is bounded, leaving no capture times
+	tld.providers.Solar = fakeSolar(polarSolar)
+	lookups = 0
+	err := tld.SetCaptureTimes(tld.CaptureTimes[2])
+	var skipped *noCapturesError
+	if !errors.As(err, &skipped) || skipped.Until.Format(dateLayout) != "2020-06-17" || len(tld.CaptureTimes) != 0 || lookups != maxSkipLookups {
+		t.Errorf("SetCaptureTimes() without captures got %v, %v after %d lookups, want none until 2020-06-17 after %d",
+			err, tld.CaptureTimes, lookups, maxSkipLookups)
+	}
+
+	// deep in the polar night, days are skipped without lookups
+	tld.Latitude = -78.2
+	lookups = 0
+	err = tld.SetCaptureTimes(time.Date(2020, 6, 1, 0, 0, 0, 0, srv.providers.Local))
+	if !errors.As(err, &skipped) || skipped.Until.Before(time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)) || lookups != maxSkipLookups {
+		t.Errorf("SetCaptureTimes() in the polar night got %v after %d lookups, want none until August after %d", err, lookups, maxSkipLookups)
+	}
+}
+
+func Test_estimatedPolar(t *testing.T) {
+	june := time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC)
+	december := time.Date(2020, 12, 21, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		lat  float64
+		date time.Time
+		want bool
+	}{
+		{"equator", 0, june, false},
+		{"polar day", 78.2, june, true},
+		{"polar night", 78.2, december, true},
+		{"southern polar night", -78.2, june, true},
+		{"near the arctic circle", 66.0, june, false},
+		{"midnight sun at the arctic circle, too close to tell", 67.0, june, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimatedPolar(tt.lat, tt.date); got != tt.want {
+				t.Errorf("estimatedPolar(%.1f, %s) got %t, want %t", tt.lat, tt.date.Format(dateLayout), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTLDef_SetAdditional_withoutLast(t *testing.T) {
+	tld := newBaseTLD()
+	tld.LastFlags = lastTime // not computed from the solar times
+	tld.CaptureTimes = CaptureTimes{sunrise}
+	if err := tld.SetAdditional(); err == nil {
+		t.Errorf("SetAdditional() without a last capture got %v", tld.CaptureTimes)
+	}
+}
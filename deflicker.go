@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultDeflickerWindow = 5   // frames averaged to compute each frame's target brightness
+	minDeflickerGamma      = 0.5 // limits on the correction, so a single black or
+	maxDeflickerGamma      = 2.0 // blown-out frame isn't stretched beyond recognition
+)
+
+// DeflickerSample holds the brightness statistics and correction of one frame
+type DeflickerSample struct {
+	Frame
+	Before float64 `json:"before"` // mean brightness as captured (after the pipeline)
+	Target float64 `json:"target"` // smoothed brightness across the window
+	Gamma  float64 `json:"gamma"`  // correction applied to reach Target
+	After  float64 `json:"after"`  // mean brightness after correction
+}
+
+// Deflicker removes frame-to-frame brightness jumps caused by webcam
+// auto-exposure: each frame is gamma-corrected towards the moving average
+// of the brightness of its neighbours
+type Deflicker struct {
+	Window  int               `json:"window"`
+	Samples []DeflickerSample `json:"samples"`
+	levels  []*levels         // of each sample, kept by PreviewDeflicker
+}
+
+// NewDeflicker measures the brightness of each frame, after applying the
+// pipeline, and computes the corrections using a moving average over
+// window frames, centered on each frame so window must be odd
+func NewDeflicker(frames []Frame, window int, p Pipeline) (*Deflicker, error) {
+	return newDeflicker(frames, window, p, false)
+}
+
+// PreviewDeflicker computes the corrections like NewDeflicker, without a
+// pipeline, and the brightness each frame would have after Correct,
+// decoding each frame once
+func PreviewDeflicker(frames []Frame, window int) (*Deflicker, error) {
+	d, err := newDeflicker(frames, window, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	for i := range d.Samples {
+		lut := d.lut(i)
+		d.Samples[i].After = d.levels[i].brightness(&lut)
+	}
+	return d, nil
+}
+
+// newDeflicker implements NewDeflicker, keeping the levels of each sample
+// when keep is set
+func newDeflicker(frames []Frame, window int, p Pipeline, keep bool) (*Deflicker, error) {
+	sn := "NewDeflicker"
+
+	if window < 1 {
+		window = defaultDeflickerWindow
+	}
+	if window%2 == 0 {
+		return nil, fmt.Errorf("%s, window of %d frames, want an odd number", sn, window)
+	}
+
+	d := &Deflicker{Window: window, Samples: []DeflickerSample{}}
+	for _, frame := range frames {
+		img, _, err := LoadFrame(frame.Path)
+		if err != nil {
+			log.Printf("%s, skipping frame: %v\n", sn, err)
+			continue
+		}
+		if img, _, err = p.Apply(img); err != nil {
+			return nil, err
+		}
+		d.Samples = append(d.Samples, DeflickerSample{Frame: frame, Before: Brightness(img)})
+		if keep {
+			d.levels = append(d.levels, sampleLevels(img))
+		}
+	}
+
+	half := window / 2
+	for i := range d.Samples {
+		lo, hi := i-half, i+half
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(d.Samples)-1 {
+			hi = len(d.Samples) - 1
+		}
+		var sum float64
+		for j := lo; j <= hi; j++ {
+			sum += d.Samples[j].Before
+		}
+		s := &d.Samples[i]
+		s.Target = sum / float64(hi-lo+1)
+		s.Gamma = deflickerGamma(s.Before, s.Target)
+	}
+
+	return d, nil
+}
+
+// deflickerGamma returns the gamma that maps brightness before to target:
+// (before/255)^gamma = target/255
+func deflickerGamma(before, target float64) float64 {
+	if before <= 0 || before >= 255 || target <= 0 || target >= 255 {
+		return 1
+	}
+	g := math.Log(target/255) / math.Log(before/255)
+	return math.Max(minDeflickerGamma, math.Min(maxDeflickerGamma, g))
+}
+
+// lut returns the lookup table applying the correction of sample i to
+// each color channel
+func (d *Deflicker) lut(i int) [256]uint8 {
+	var lut [256]uint8
+	for v := range lut {
+		lut[v] = uint8(math.Round(255 * math.Pow(float64(v)/255, d.Samples[i].Gamma)))
+	}
+	return lut
+}
+
+// Correct applies the correction computed for sample i to img, recording
+// the resulting brightness
+func (d *Deflicker) Correct(i int, img image.Image) image.Image {
+	s := &d.Samples[i]
+	lut := d.lut(i)
+
+	src := toRGBA(img)
+	dst := image.NewRGBA(src.Rect)
+	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
+			si, di := src.PixOffset(x, y), dst.PixOffset(x, y)
+			dst.Pix[di] = lut[src.Pix[si]]
+			dst.Pix[di+1] = lut[src.Pix[si+1]]
+			dst.Pix[di+2] = lut[src.Pix[si+2]]
+			dst.Pix[di+3] = src.Pix[si+3]
+		}
+	}
+
+	s.After = Brightness(dst)
+	return dst
+}
+
+// levels counts the 8-bit values of each color channel at the pixels
+// Brightness samples
+type levels [3][256]int
+
+// sampleLevels returns the levels of img
+func sampleLevels(img image.Image) *levels {
+	var l levels
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += brightnessStride {
+		for x := b.Min.X; x < b.Max.X; x += brightnessStride {
+			r, g, b, _ := img.At(x, y).RGBA()
+			l[0][r>>8]++
+			l[1][g>>8]++
+			l[2][b>>8]++
+		}
+	}
+	return &l
+}
+
+// brightness returns what Brightness would return for the image once lut
+// is applied to each color channel
+func (l *levels) brightness(lut *[256]uint8) float64 {
+	var sum float64
+	var n int
+	for v := 0; v < 256; v++ {
+		n += l[0][v]
+		sum += 0.299*float64(l[0][v])*float64(lut[v]) +
+			0.587*float64(l[1][v])*float64(lut[v]) +
+			0.114*float64(l[2][v])*float64(lut[v])
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// Image plots brightness before (blue) and after (green) correction
+// against frame number
+func (d *Deflicker) Image(w, h int) image.Image {
+	p := newPlot(w, h, 0, float64(len(d.Samples)-1), 0, 255)
+
+	xs := make([]float64, len(d.Samples))
+	before := make([]float64, len(d.Samples))
+	after := make([]float64, len(d.Samples))
+	for i, s := range d.Samples {
+		xs[i] = float64(i)
+		before[i] = s.Before
+		after[i] = s.After
+	}
+	p.series(xs, before, plotSeries)
+	p.series(xs, after, plotSeriesAlt)
+
+	return p.img
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleDeflicker is the handler for "/webcams/:name/deflicker.png" and
+// "/webcams/:name/deflicker.json", previewing the deflicker corrections
+// for a date range without rendering
+func (s *server) handleDeflicker(format string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleDeflicker"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkRenderDays(from, to); err != nil { // every frame in the range is decoded
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		window := defaultDeflickerWindow
+		if v := r.URL.Query().Get("window"); v != "" {
+			var err error
+			if window, err = strconv.Atoi(v); err != nil || window < 1 || window%2 == 0 {
+				http.Error(w, fmt.Sprintf("invalid window %q, want an odd number of frames", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		frames, err := tld.Frames(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d, err := PreviewDeflicker(frames, window)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if format == "json" {
+			writeJSON(w, http.StatusOK, d)
+			return
+		}
+		writePNG(w, d.Image(800, 300))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDeflickerGamma(t *testing.T) {
+	tests := []struct {
+		name   string
+		before float64
+		target float64
+		want   float64
+	}{
+		{name: "unchanged", before: 128, target: 128, want: 1},
+		{name: "brighten", before: 96, target: 128, want: math.Log(128.0/255) / math.Log(96.0/255)},
+		{name: "black", before: 0, target: 128, want: 1},
+		{name: "limited", before: 250, target: 5, want: maxDeflickerGamma},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deflickerGamma(tt.before, tt.target); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("deflickerGamma() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDeflicker(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	// auto-exposure flicker: alternating dark and bright frames
+	for i := 0; i < 8; i++ {
+		gray := color.Gray{100}
+		if i%2 == 1 {
+			gray = color.Gray{160}
+		}
+		writeTestFrame(t, tld, sunrise.Add(time.Duration(i)*time.Minute), 8, 8, gray)
+	}
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	frames, err := tld.Frames(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDeflicker(frames, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Samples) != 8 {
+		t.Fatalf("NewDeflicker() got %d samples, want 8", len(d.Samples))
+	}
+
+	var spreadBefore, spreadAfter float64
+	for i, s := range d.Samples {
+		img, _, err := LoadFrame(s.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Correct(i, img)
+		if i > 0 {
+			spreadBefore = math.Max(spreadBefore, math.Abs(s.Before-d.Samples[i-1].Before))
+			spreadAfter = math.Max(spreadAfter, math.Abs(d.Samples[i].After-d.Samples[i-1].After))
+		}
+	}
+	if spreadAfter >= spreadBefore/2 {
+		t.Errorf("NewDeflicker() frame-to-frame change got %.1f, want less than half of %.1f", spreadAfter, spreadBefore)
+	}
+
+	if _, err := NewDeflicker(frames, 4, nil); err == nil {
+		t.Errorf("NewDeflicker() with an even window, want error")
+	}
+}
+
+func TestPreviewDeflicker(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	colors := []color.Color{color.Gray{100}, color.RGBA{200, 120, 40, 255}, color.Gray{90}, color.RGBA{30, 60, 220, 255}, color.Gray{110}}
+	for i, c := range colors {
+		writeTestFrame(t, tld, sunrise.Add(time.Duration(i)*time.Minute), 9, 7, c)
+	}
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	frames, err := tld.Frames(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := PreviewDeflicker(frames, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDeflicker(frames, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range want.Samples {
+		img, _, err := LoadFrame(s.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Correct(i, img)
+		if math.Abs(got.Samples[i].After-want.Samples[i].After) > 1e-9 {
+			t.Errorf("PreviewDeflicker() sample %d after got %v, want %v", i, got.Samples[i].After, want.Samples[i].After)
+		}
+	}
+}
+
+func Test_server_handleDeflicker(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "too many days", query: "?from=2020-01-01&to=2020-12-31", wantStatus: http.StatusBadRequest},
+		{name: "even window", query: "?date=2020-05-27&window=4", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/webcams/"+url.PathEscape("Manzanita Lake")+"/deflicker.json"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handleDeflicker() %s got %d, want %d: %s", tt.query, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
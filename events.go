@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxRecentEvents = 256              // events kept for clients resuming with Last-Event-ID
+	subscriberQueue = 64               // events buffered per client before it is dropped
+	eventsKeepAlive = 30 * time.Second // interval of comments keeping idle streams open
+)
+
+// capture activity reported by the events stream
+const (
+	eventScheduled   = "scheduled"   // next capture time set
+	eventStarted     = "started"     // capture attempt started
+	eventSucceeded   = "succeeded"   // frame stored
+	eventFailed      = "failed"      // capture attempt failed
+	eventRescheduled = "rescheduled" // day's captures done, CaptureTimes set for the next day
+)
+
+// Event is capture activity of a webcam, sent as a Server-Sent Event
+type Event struct {
+	ID      uint64     `json:"id"`
+	Type    string     `json:"type"`
+	Webcam  string     `json:"webcam"`
+	Time    time.Time  `json:"time"`
+	Next    *time.Time `json:"nextCapture,omitempty"`
+	File    string     `json:"file,omitempty"`
+	Size    int64      `json:"size,omitempty"`
+	Error   string     `json:"error,omitempty"`
+	Backoff int64      `json:"backoffSeconds,omitempty"`
+}
+
+// eventHub assigns event IDs, keeps recent events and fans them out to
+// subscribed clients
+type eventHub struct {
+	mu     sync.Mutex
+	lastID uint64
+	recent []Event // most recent events, oldest first
+	subs   map[*subscriber]struct{}
+}
+
+// subscriber is a client of the events stream
+type subscriber struct {
+	ch      chan Event      // closed when the client falls behind or unsubscribes
+	webcams map[string]bool // webcams of interest, empty for all
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*subscriber]struct{})}
+}
+
+// wants reports whether the subscriber is interested in e
+func (sub *subscriber) wants(e Event) bool {
+	return len(sub.webcams) == 0 || sub.webcams[e.Webcam]
+}
+
+// publish assigns e the next ID, records it and sends it to subscribers.
+// A subscriber whose queue is full is dropped rather than blocking the
+// capture go routines; its client reconnects and resumes with Last-Event-ID.
+func (h *eventHub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	e.ID = h.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.recent = append(h.recent, e)
+	if len(h.recent) > maxRecentEvents {
+		h.recent = h.recent[len(h.recent)-maxRecentEvents:]
+	}
+
+	for sub := range h.subs {
+		if !sub.wants(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe registers a subscriber for the webcams listed, all if none,
+// returning the recorded events after lastID it missed
+func (h *eventHub) subscribe(webcams []string, lastID uint64) (*subscriber, []Event) {
+	sub := &subscriber{ch: make(chan Event, subscriberQueue), webcams: make(map[string]bool)}
+	for _, name := range webcams {
+		sub.webcams[name] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	for _, e := range h.recent {
+		if e.ID > lastID && sub.wants(e) {
+			missed = append(missed, e)
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub, missed
+}
+
+// unsubscribe removes a subscriber, if not already dropped
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// writeEvent writes e in the text/event-stream format
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleEvents is the handler for "/events" and "/webcams/:name/events",
+// streaming capture activity as Server-Sent Events. "/events" streams all
+// webcams, or those listed in query parameters "webcam". Clients resume
+// after the event in header Last-Event-ID, or query parameter "lastEventId".
+func (s *server) handleEvents() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleEvents"
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		// the server's WriteTimeout would end the stream
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("%s, SetWriteDeadline: %v\n", sn, err)
+		}
+
+		webcams := r.URL.Query()["webcam"]
+		if name := p.ByName("name"); name != "" {
+			if s.findWebcam(name) == nil {
+				http.NotFound(w, r)
+				return
+			}
+			webcams = []string{name}
+		}
+
+		var lastID uint64
+		last := r.Header.Get("Last-Event-ID")
+		if last == "" {
+			last = r.URL.Query().Get("lastEventId")
+		}
+		if last != "" {
+			var err error
+			if lastID, err = strconv.ParseUint(last, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", last), http.StatusBadRequest)
+				return
+			}
+		}
+
+		sub, missed := s.events.subscribe(webcams, lastID)
+		defer s.events.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		for _, e := range missed {
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.ctx.Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case e, ok := <-sub.ch:
+				if !ok {
+					log.Printf("%s, subscriber fell behind, dropped\n", sn)
+					return
+				}
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
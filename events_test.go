@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_eventHub(t *testing.T) {
+	h := newEventHub()
+	h.publish(Event{Type: eventStarted, Webcam: "a"})
+	h.publish(Event{Type: eventStarted, Webcam: "b"})
+	h.publish(Event{Type: eventFailed, Webcam: "a"})
+
+	tests := []struct {
+		name    string
+		webcams []string
+		lastID  uint64
+		wantIDs []uint64
+	}{
+		{name: "all", wantIDs: []uint64{1, 2, 3}},
+		{name: "resume", lastID: 2, wantIDs: []uint64{3}},
+		{name: "filtered", webcams: []string{"a"}, wantIDs: []uint64{1, 3}},
+		{name: "filtered resume", webcams: []string{"b"}, lastID: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := h.subscribe(tt.webcams, tt.lastID)
+			defer h.unsubscribe(sub)
+
+			if len(missed) != len(tt.wantIDs) {
+				t.Fatalf("eventHub.subscribe() got %d events, want %d", len(missed), len(tt.wantIDs))
+			}
+			for i, e := range missed {
+				if e.ID != tt.wantIDs[i] {
+					t.Errorf("eventHub.subscribe() event %d got ID %d, want %d", i, e.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+
+	// a subscriber that falls behind is dropped, not waited for
+	slow, _ := h.subscribe(nil, h.lastID)
+	for i := 0; i < subscriberQueue+1; i++ {
+		h.publish(Event{Type: eventStarted, Webcam: "a"})
+	}
+	n := 0
+	for range slow.ch {
+		n++
+	}
+	if n != subscriberQueue {
+		t.Errorf("eventHub.publish() queued %d events for a slow subscriber, want %d", n, subscriberQueue)
+	}
+	h.unsubscribe(slow) // already dropped, must not panic
+}
+
+func Test_server_handleEvents(t *testing.T) {
+	ts := httptest.NewServer(srv.router)
+	defer ts.Close()
+
+	tld := &TLDef{Name: "testEvents"}
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	srv.events.publish(Event{Type: eventStarted, Webcam: "other"})
+	srv.events.mu.Lock()
+	resumeAfter := srv.events.lastID - 1 // before the event for the other webcam
+	srv.events.mu.Unlock()
+	srv.captureStarted(tld)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/webcams/testEvents/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(resumeAfter, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("handleEvents got Content-Type %q, want text/event-stream", ct)
+	}
+
+	// the missed "started" event, not the other webcam's, then events
+	// published while connected
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.captureFailed(tld, errors.New("webcam offline"))
+		srv.captureSucceeded(tld, "/tmp/testEvents 20200527060000", 1234)
+	}()
+
+	var got []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			got = append(got, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"type":"succeeded"`) {
+			if !strings.Contains(line, `"file":"testEvents 20200527060000"`) || !strings.Contains(line, `"size":1234`) {
+				t.Errorf("handleEvents succeeded event %s, want file and size", line)
+			}
+			break
+		}
+	}
+	want := []string{eventStarted, eventFailed, eventSucceeded}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("handleEvents got events %v, want %v", got, want)
+	}
+
+	req, _ = http.NewRequest("GET", ts.URL+"/events?lastEventId=x", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("handleEvents invalid Last-Event-ID got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func Test_server_handler_events(t *testing.T) {
+	ts := httptest.NewUnstartedServer(srv.handler()) // logged, as in main
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("server.handler() /events got %d %q, want a stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// the stream outlives the server's WriteTimeout
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		srv.events.publish(Event{Type: eventStarted, Webcam: "testWriteTimeout"})
+	}()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") && strings.Contains(scanner.Text(), "testWriteTimeout") {
+			return
+		}
+	}
+	t.Errorf("server.handler() /events ended before the event: %v", scanner.Err())
+}
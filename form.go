@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator"
+)
+
+// formErrors maps form field names to error messages shown with the field;
+// the "" key holds errors not tied to a field
+type formErrors map[string]string
+
+// formLabels are the labels of the form fields, for error messages
+var formLabels = map[string]string{
+	"name":       "Name",
+	"webcamUrl":  "Webcam URL",
+	"latitude":   "Latitude",
+	"longitude":  "Longitude",
+	"first":      "First capture",
+	"last":       "Last capture",
+	"additional": "Additional captures",
+	"folder":     "Folder path",
+	"from":       "Preview from",
+	"to":         "Preview to",
+}
+
+// firstChoices and lastChoices are the values of the "first" and "last"
+// radio buttons, and the TLDef fields they set
+var (
+	firstChoices = map[string]func(tld *TLDef) *bool{
+		"sunrise":   func(tld *TLDef) *bool { return &tld.FirstSunrise },
+		"sunrise30": func(tld *TLDef) *bool { return &tld.FirstSunrise30 },
+		"sunrise60": func(tld *TLDef) *bool { return &tld.FirstSunrise60 },
+		"time":      func(tld *TLDef) *bool { return &tld.FirstTime },
+	}
+	lastChoices = map[string]func(tld *TLDef) *bool{
+		"sunset":   func(tld *TLDef) *bool { return &tld.LastSunset },
+		"sunset30": func(tld *TLDef) *bool { return &tld.LastSunset30 },
+		"sunset60": func(tld *TLDef) *bool { return &tld.LastSunset60 },
+		"time":     func(tld *TLDef) *bool { return &tld.LastTime },
+	}
+)
+
+// add records msg for field, keeping the first error reported for a field
+func (fe formErrors) add(field, msg string) {
+	if _, ok := fe[field]; !ok {
+		fe[field] = msg
+	}
+}
+
+// label returns the label of a form field, or the field name itself
+func label(field string) string {
+	if l, ok := formLabels[field]; ok {
+		return l
+	}
+	return field
+}
+
+// addValidation records the errors reported by validator.Struct against
+// the form fields they apply to
+func (fe formErrors) addValidation(err error) {
+	verrs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		fe.add("", err.Error())
+		return
+	}
+
+	tldType := reflect.TypeOf(TLDef{})
+	for _, v := range verrs {
+		field := ""
+		if strings.Count(v.StructNamespace(), ".") == 1 { // a TLDef field, not a nested one
+			if sf, ok := tldType.FieldByName(v.StructField()); ok {
+				field = sf.Tag.Get("formam")
+			}
+		}
+		if field == "" || field == "-" {
+			fe.add("", fmt.Sprintf("%s is not valid", v.Namespace()))
+			continue
+		}
+
+		switch v.Tag() {
+		case "required":
+			fe.add(field, fmt.Sprintf("%s is required", label(field)))
+		case "url":
+			fe.add(field, fmt.Sprintf("%s must be a URL, e.g., https://example.com/webcam.jpg", label(field)))
+		case "latitude":
+			fe.add(field, fmt.Sprintf("%s must be between -90 and 90", label(field)))
+		case "longitude":
+			fe.add(field, fmt.Sprintf("%s must be between -180 and 180", label(field)))
+		default:
+			fe.add(field, fmt.Sprintf("%s is not valid", label(field)))
+		}
+	}
+}
+
+// addChoices records an error unless exactly one first and one last
+// capture is chosen
+func (fe formErrors) addChoices(tld *TLDef) {
+	count := func(choices map[string]func(tld *TLDef) *bool) int {
+		n := 0
+		for _, field := range choices {
+			if *field(tld) {
+				n++
+			}
+		}
+		return n
+	}
+	if count(firstChoices) != 1 {
+		fe.add("first", "Choose one of Sunrise, Sunrise +30 or Sunrise +60")
+	}
+	if count(lastChoices) != 1 {
+		fe.add("last", "Choose one of Sunset, Sunset -30 or Sunset -60")
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// Value returns the text of a form field: as submitted when re-rendering a
+// submission with errors, otherwise from Form
+func (p page) Value(field string) string {
+	if p.Submitted != nil {
+		return p.Submitted.Get(field)
+	}
+	switch field {
+	case "from":
+		return p.From
+	case "to":
+		return p.To
+	}
+	if p.Form == nil {
+		return ""
+	}
+
+	switch field {
+	case "name":
+		return p.Form.Name
+	case "webcamUrl":
+		return p.Form.URL
+	case "latitude":
+		return strconv.FormatFloat(p.Form.Latitude, 'f', -1, 64)
+	case "longitude":
+		return strconv.FormatFloat(p.Form.Longitude, 'f', -1, 64)
+	case "additional":
+		return strconv.Itoa(p.Form.Additional)
+	case "folder":
+		return p.Form.FolderPath
+	}
+	return ""
+}
+
+// Choice returns the value of the checked "first" or "last" radio button
+func (p page) Choice(group string) string {
+	if p.Form == nil {
+		return ""
+	}
+
+	choices := firstChoices
+	if group == "last" {
+		choices = lastChoices
+	}
+	for _, value := range []string{"sunrise", "sunrise30", "sunrise60", "sunset", "sunset30", "sunset60", "time"} {
+		if field, ok := choices[value]; ok && *field(p.Form) {
+			return value
+		}
+	}
+	return ""
+}
+
+// formInvalid re-renders the submitted form with the errors found in it.
+// name is the current name of the definition being edited, "" for a new one.
+func (s *server) formInvalid(w http.ResponseWriter, r *http.Request, tld *TLDef, name string, submitted url.Values, errs formErrors) {
+	data := formPage(tld, name)
+	data.Submitted = submitted
+	data.Errors = errs
+	s.render(w, r, http.StatusBadRequest, data)
+}
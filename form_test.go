@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_server_formErrors(t *testing.T) {
+	valid := func(change func(v url.Values)) url.Values {
+		v := url.Values{
+			"name":       {"testForm"},
+			"webcamUrl":  {"https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg"},
+			"latitude":   {"40.437787"},
+			"longitude":  {"-121.5360307"},
+			"first":      {"sunrise30"},
+			"last":       {"sunset"},
+			"additional": {"3"},
+			"folder":     {"/tmp/testForm"},
+		}
+		change(v)
+		return v
+	}
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		want      []string // substrings of the re-rendered form
+		wantField []string // fields marked invalid
+	}{
+		{name: "missing name",
+			form:      valid(func(v url.Values) { v.Del("name") }),
+			want:      []string{"Name is required", "kyvc_webcam1.jpg", `value="sunrise30" checked`},
+			wantField: []string{"name"},
+		},
+		{name: "bad url and latitude",
+			form: valid(func(v url.Values) {
+				v.Set("webcamUrl", "kyvc_webcam1.jpg")
+				v.Set("latitude", "forty")
+			}),
+			want:      []string{"Webcam URL must be a URL", `Latitude &#34;forty&#34; is not valid`, ">forty</textarea>"},
+			wantField: []string{"webcamUrl", "latitude"},
+		},
+		{name: "latitude out of range",
+			form:      valid(func(v url.Values) { v.Set("latitude", "91") }),
+			want:      []string{"Latitude must be between -90 and 90"},
+			wantField: []string{"latitude"},
+		},
+		{name: "no first capture",
+			form:      valid(func(v url.Values) { v.Del("first") }),
+			want:      []string{"Choose one of Sunrise"},
+			wantField: []string{"first"},
+		},
+		{name: "two first captures",
+			form: valid(func(v url.Values) {
+				v.Set("firstSunrise", "") // checkbox from earlier forms
+			}),
+			want:      []string{"Choose one of Sunrise"},
+			wantField: []string{"first"},
+		},
+		{name: "unknown last capture",
+			form:      valid(func(v url.Values) { v.Set("last", "moonset") }),
+			want:      []string{`Last capture &#34;moonset&#34; is not a choice`},
+			wantField: []string{"last"},
+		},
+		{name: "additional too big",
+			form:      valid(func(v url.Values) { v.Set("additional", "17") }),
+			want:      []string{"Additional must be 0-16", `value="17"`},
+			wantField: []string{"additional"},
+		},
+		{name: "unknown field",
+			form: valid(func(v url.Values) { v.Set("color", "red") }),
+			want: []string{`unknown field &#34;color&#34;`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/new", strings.NewReader(tt.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("%s, got %d, want %d", tt.name, rr.Code, http.StatusBadRequest)
+			}
+			body := rr.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("%s want substring %q, not found", tt.name, want)
+				}
+			}
+			for _, field := range tt.wantField {
+				if !regexp.MustCompile(`name="` + field + `"[^>]*is-invalid`).MatchString(body) {
+					t.Errorf("%s want field %q marked invalid", tt.name, field)
+				}
+			}
+		})
+	}
+}
+
+func Test_page_Choice(t *testing.T) {
+	tld := newBaseTLD()
+	tld.FirstSunrise, tld.FirstSunrise60 = false, true
+	tld.LastSunset, tld.LastSunset30 = false, true
+
+	p := page{Form: &tld}
+	if got := p.Choice("first"); got != "sunrise60" {
+		t.Errorf("page.Choice(first) got %q, want sunrise60", got)
+	}
+	if got := p.Choice("last"); got != "sunset30" {
+		t.Errorf("page.Choice(last) got %q, want sunset30", got)
+	}
+	if got := (page{}).Choice("first"); got != "" {
+		t.Errorf("page.Choice(first) of empty form got %q, want none", got)
+	}
+}
+
+func Test_page_Value(t *testing.T) {
+	tld := newBaseTLD()
+
+	tests := []struct {
+		name  string
+		p     page
+		field string
+		want  string
+	}{
+		{name: "from definition", p: page{Form: &tld}, field: "latitude", want: "40.437787"},
+		{name: "additional", p: page{Form: &tld}, field: "additional", want: "1"},
+		{name: "as submitted", p: page{Form: &tld, Submitted: url.Values{"latitude": {"forty"}}}, field: "latitude", want: "forty"},
+		{name: "empty form", p: page{}, field: "name", want: ""},
+		{name: "preview date", p: page{From: "2020-05-27"}, field: "from", want: "2020-05-27"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Value(tt.field); got != tt.want {
+				t.Errorf("page.Value(%s) got %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	fileTimeLayout = "20060102150405" // capture date and time appended to stored file names, see fileStamp
+	dateLayout     = "2006-01-02"     // date query parameters, e.g., "?date=2020-05-27"
+)
+
+// Frame describes a captured image stored in a webcam's folder
+type Frame struct {
+	Path string    `json:"file"` // full path of the stored image
+	Time time.Time `json:"time"` // capture time, parsed from the file name
+}
+
+// Frames returns the frames stored in FolderPath with capture times in
+// [from, to), sorted by capture time. Files that don't follow the
+// TargetFileName naming scheme are ignored.
+func (tld *TLDef) Frames(from, to time.Time) ([]Frame, error) {
+	sn := "TLDef.Frames"
+
+	files, err := ioutil.ReadDir(tld.FolderPath)
+	if err != nil {
+		log.Printf("%s, %s ioutil.ReadDir: %v\n", sn, tld.Name, err)
+		return nil, err
+	}
+
+	var frames []Frame
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		t, ok := tld.FrameTime(file.Name())
+		if !ok || t.Before(from) || !t.Before(to) {
+			continue
+		}
+		frames = append(frames, Frame{Path: filepath.Join(tld.FolderPath, file.Name()), Time: t})
+	}
+
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Time.Before(frames[j].Time) })
+	return frames, nil
+}
+
+// FrameTime extracts the capture time from a file name created by
+// TargetFileName, reporting whether the name matched
+func (tld *TLDef) FrameTime(fileName string) (time.Time, bool) {
+	prefix := tld.Name + " "
+	if !strings.HasPrefix(fileName, prefix) {
+		return time.Time{}, false
+	}
+	t, err := parseFileStamp(strings.TrimPrefix(fileName, prefix), tld.env().Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// LoadFrame opens and decodes a stored frame, returning the image and its
+// format ("jpeg" or "png")
+func LoadFrame(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return img, format, nil
+}
+
+// SaveFrame encodes img in the specified format ("png", otherwise JPEG with
+// the specified quality) and stores it at path. The image is written to a
+// temporary file which is renamed into place, so path never holds a
+// partially written image.
+func SaveFrame(path string, img image.Image, format string, quality int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".frame-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err = tmp.Chmod(0644); err == nil { // ioutil.TempFile creates files -rw-------
+		if format == "png" {
+			err = png.Encode(tmp, img)
+		} else {
+			err = jpeg.Encode(tmp, img, &jpeg.Options{Quality: quality})
+		}
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// parseDateRange returns the [from, to) range selected by the "date", or
+// "from" and "to" query parameters or form fields, see dateRange
+func parseDateRange(r *http.Request, local *time.Location) (time.Time, time.Time, error) {
+	if err := r.ParseForm(); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return dateRange(r.Form, local)
+}
+
+// dateRange returns the [from, to) range selected by the "date", or "from"
+// and "to" values (whole days, inclusive, in local, the time zone where
+// this code is running). Without them, today is selected.
+func dateRange(q url.Values, local *time.Location) (time.Time, time.Time, error) {
+	now := time.Now().In(local)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, local)
+
+	if date := q.Get("date"); date != "" {
+		day, err := time.ParseInLocation(dateLayout, date, local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, want YYYY-MM-DD", date)
+		}
+		return day, day.AddDate(0, 0, 1), nil
+	}
+
+	from, to := today, today
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = time.ParseInLocation(dateLayout, v, local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from %q, want YYYY-MM-DD", v)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = time.ParseInLocation(dateLayout, v, local); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to %q, want YYYY-MM-DD", v)
+		}
+	} else if q.Get("from") != "" {
+		to = from
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to %s is before from %s", to.Format(dateLayout), from.Format(dateLayout))
+	}
+
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// Find returns the timelapse definition with the specified name, or nil
+func (mtld masterTLDefs) Find(name string) *TLDef {
+	for _, tld := range mtld {
+		if tld.Name == name {
+			return tld
+		}
+	}
+	return nil
+}
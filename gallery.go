@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const galleryThumbWidth = 240
+
+// Gallery lists the frames captured by a webcam on one day
+type Gallery struct {
+	Name       string
+	Days       []string // days with frames, YYYY-MM-DD, newest first
+	Date       string   // day shown
+	Prev, Next string   // adjacent days with frames, "" if none
+	Frames     []GalleryFrame
+}
+
+// GalleryFrame is a frame listed in the gallery or shown in the viewer
+type GalleryFrame struct {
+	File string // file name in FolderPath
+	Time time.Time
+	Slot string // e.g., "sunrise +30", "" if it cannot be determined
+}
+
+// Viewer shows one frame full size, with links to its neighbours
+type Viewer struct {
+	Name       string
+	Frame      GalleryFrame
+	Date       string
+	Prev, Next string   // file names of the adjacent frames, "" at either end
+	Variants   []string // sub-folders holding other versions of the frame, e.g., "original"
+}
+
+// allFrames returns every frame stored for the webcam
+func (tld *TLDef) allFrames() ([]Frame, error) {
+	return tld.Frames(time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// Gallery returns the frames captured on date, "" for the latest day with
+// frames
+func (tld *TLDef) Gallery(date string) (*Gallery, error) {
+	frames, err := tld.allFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gallery{Name: tld.Name, Date: date, Frames: []GalleryFrame{}}
+	byDay := map[string][]Frame{}
+	for _, f := range frames {
+		day := f.Time.Format(dateLayout)
+		if _, ok := byDay[day]; !ok {
+			g.Days = append([]string{day}, g.Days...) // frames are sorted, so newest day first
+		}
+		byDay[day] = append(byDay[day], f)
+	}
+	if g.Date == "" && len(g.Days) > 0 {
+		g.Date = g.Days[0]
+	}
+
+	for i, day := range g.Days {
+		if day != g.Date {
+			continue
+		}
+		if i > 0 {
+			g.Next = g.Days[i-1]
+		}
+		if i < len(g.Days)-1 {
+			g.Prev = g.Days[i+1]
+		}
+	}
+
+	slots := tld.slotLabels(byDay[g.Date])
+	for i, f := range byDay[g.Date] {
+		g.Frames = append(g.Frames, GalleryFrame{File: filepath.Base(f.Path), Time: f.Time, Slot: slots[i]})
+	}
+	return g, nil
+}
+
+// slotLabels returns the slot label of each frame of a day. Frames don't
+// record their slot, so labels are only given when the day holds exactly
+// the captures the definition schedules.
+func (tld *TLDef) slotLabels(frames []Frame) []string {
+	labels := make([]string, len(frames))
+	if len(frames) != tld.Additional+2 {
+		return labels
+	}
+
+	probe := TLDef{FirstFlags: tld.FirstFlags, LastFlags: tld.LastFlags}
+	for _, f := range frames {
+		probe.CaptureTimes = append(probe.CaptureTimes, f.Time)
+	}
+	if tld.Additional%2 == 1 { // SetAdditional puts solar noon in the middle
+		probe.SolarNoonUTC = probe.CaptureTimes[(tld.Additional+1)/2]
+	}
+	for i := range frames {
+		labels[i] = probe.SlotLabel(i)
+	}
+	return labels
+}
+
+// Viewer returns the frame stored in file, with its neighbours
+func (tld *TLDef) Viewer(file string) (*Viewer, error) {
+	frames, err := tld.allFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range frames {
+		if filepath.Base(f.Path) != file {
+			continue
+		}
+
+		v := &Viewer{Name: tld.Name, Date: f.Time.Format(dateLayout)}
+		day, err := tld.Gallery(v.Date)
+		if err != nil {
+			return nil, err
+		}
+		for _, gf := range day.Frames {
+			if gf.File == file {
+				v.Frame = gf
+			}
+		}
+		if i > 0 {
+			v.Prev = filepath.Base(frames[i-1].Path)
+		}
+		if i < len(frames)-1 {
+			v.Next = filepath.Base(frames[i+1].Path)
+		}
+		for _, sub := range []string{captionFolder, originalFolder} {
+			if _, err := os.Stat(filepath.Join(tld.FolderPath, sub, file)); err == nil {
+				v.Variants = append(v.Variants, sub)
+			}
+		}
+		return v, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// FramePath returns the path of a frame file, or of its version in the
+// variant sub-folder, confined to FolderPath: file must be a frame of this
+// webcam, and the path must not resolve outside FolderPath
+func (tld *TLDef) FramePath(file, variant string) (string, error) {
+	if file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+		return "", fmt.Errorf("invalid frame %q", file)
+	}
+	if _, ok := tld.FrameTime(file); !ok {
+		return "", fmt.Errorf("%q is not a frame of %s", file, tld.Name)
+	}
+
+	path := filepath.Join(tld.FolderPath, file)
+	switch variant {
+	case "":
+	case captionFolder, originalFolder:
+		path = filepath.Join(tld.FolderPath, variant, file)
+	default:
+		return "", fmt.Errorf("invalid variant %q", variant)
+	}
+
+	// a symlink could lead outside the folder
+	root, err := filepath.EvalSymlinks(tld.FolderPath)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside %s", file, tld.FolderPath)
+	}
+	return resolved, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleGallery is the handler for "/webcams/:name/gallery", showing the
+// frames of the day selected by query parameter "date", by default the
+// latest day with frames
+func (s *server) handleGallery() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleGallery"
+
+		tld := s.findWebcam(p.ByName("name"))
+		if tld == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		date := r.URL.Query().Get("date")
+		if date != "" {
+			if _, err := time.Parse(dateLayout, date); err != nil {
+				http.Error(w, fmt.Sprintf("invalid date %q, want YYYY-MM-DD", date), http.StatusBadRequest)
+				return
+			}
+		}
+
+		g, err := tld.Gallery(date)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.render(w, r, http.StatusOK, page{Company: "Timelapse", Gallery: g})
+	}
+}
+
+// handleViewer is the handler for "/webcams/:name/view/:file", showing one
+// frame full size
+func (s *server) handleViewer() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		tld := s.findWebcam(p.ByName("name"))
+		if tld == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		v, err := tld.Viewer(p.ByName("file"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.render(w, r, http.StatusOK, page{Company: "Timelapse", Viewer: v})
+	}
+}
+
+// handleFrame is the handler for "/webcams/:name/frames/:file", serving a
+// captured image from the webcam's folder. Query parameter "variant"
+// selects the "captioned" or "original" version, and "thumb" a thumbnail.
+func (s *server) handleFrame() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleFrame"
+
+		tld := s.findWebcam(p.ByName("name"))
+		if tld == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		q := r.URL.Query()
+		path, err := tld.FramePath(p.ByName("file"), q.Get("variant"))
+		if err != nil {
+			log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=86400") // frames don't change once captured
+		if q.Get("thumb") == "" {
+			http.ServeFile(w, r, path)
+			return
+		}
+
+		img, _, err := LoadFrame(path)
+		if err != nil {
+			log.Printf("%s, %s LoadFrame: %v\n", sn, tld.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		b := img.Bounds()
+		if b.Dx() > galleryThumbWidth {
+			img = scale(img, galleryThumbWidth, maxInt(1, b.Dy()*galleryThumbWidth/b.Dx()))
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		if err := jpeg.Encode(w, img, &jpeg.Options{Quality: 75}); err != nil {
+			log.Printf("%s, %s jpeg.Encode: %v\n", sn, tld.Name, err)
+		}
+	}
+}
+
+// findWebcam returns the named webcam's definition, or nil
+func (s *server) findWebcam(name string) *TLDef {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mtld.Find(name)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeGalleryFrames stores three frames on 2020-05-27 and one on 2020-05-28
+func writeGalleryFrames(t *testing.T, tld *TLDef) []time.Time {
+	t.Helper()
+
+	times := []time.Time{
+		time.Date(2020, 5, 27, 6, 0, 0, 0, srv.providers.Local),
+		time.Date(2020, 5, 27, 13, 0, 0, 0, srv.providers.Local),
+		time.Date(2020, 5, 27, 20, 0, 0, 0, srv.providers.Local),
+		time.Date(2020, 5, 28, 6, 0, 0, 0, srv.providers.Local),
+	}
+	for _, at := range times {
+		writeTestFrame(t, tld, at, 640, 480, color.Gray{Y: 128})
+	}
+	return times
+}
+
+func TestTLDef_Gallery(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	writeGalleryFrames(t, tld)
+
+	tests := []struct {
+		name      string
+		date      string
+		wantDate  string
+		wantSlots []string
+		wantPrev  string
+		wantNext  string
+	}{
+		{name: "latest day", date: "", wantDate: "2020-05-28", wantSlots: []string{""}, wantPrev: "2020-05-27"},
+		{name: "full day", date: "2020-05-27", wantDate: "2020-05-27", wantSlots: []string{"sunrise", "solar noon", "sunset"}, wantNext: "2020-05-28"},
+		{name: "no frames", date: "2020-05-01", wantDate: "2020-05-01", wantSlots: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := tld.Gallery(tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.Date != tt.wantDate || g.Prev != tt.wantPrev || g.Next != tt.wantNext {
+				t.Errorf("TLDef.Gallery(%q) got date %s prev %q next %q, want %s %q %q", tt.date, g.Date, g.Prev, g.Next, tt.wantDate, tt.wantPrev, tt.wantNext)
+			}
+			if len(g.Frames) != len(tt.wantSlots) {
+				t.Fatalf("TLDef.Gallery(%q) got %d frames, want %d", tt.date, len(g.Frames), len(tt.wantSlots))
+			}
+			for i, f := range g.Frames {
+				if f.Slot != tt.wantSlots[i] {
+					t.Errorf("TLDef.Gallery(%q) frame %d slot %q, want %q", tt.date, i, f.Slot, tt.wantSlots[i])
+				}
+			}
+			if len(g.Days) != 2 || g.Days[0] != "2020-05-28" {
+				t.Errorf("TLDef.Gallery(%q) got days %v, want newest first", tt.date, g.Days)
+			}
+		})
+	}
+}
+
+func TestTLDef_FramePath(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	times := writeGalleryFrames(t, tld)
+	file := tld.Name + " " + times[0].Format(fileTimeLayout)
+
+	outside, err := os.Create(filepath.Join(os.TempDir(), "gallery-outside"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside.Close()
+	defer os.Remove(outside.Name())
+	link := tld.Name + " " + time.Date(2020, 5, 29, 6, 0, 0, 0, srv.providers.Local).Format(fileTimeLayout)
+	if err := os.Symlink(outside.Name(), filepath.Join(tld.FolderPath, link)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		variant string
+		wantErr bool
+	}{
+		{name: "frame", file: file},
+		{name: "missing variant", file: file, variant: originalFolder, wantErr: true},
+		{name: "unknown variant", file: file, variant: "..", wantErr: true},
+		{name: "traversal", file: "../" + file, wantErr: true},
+		{name: "not a frame", file: "timelapse.json", wantErr: true},
+		{name: "symlink outside folder", file: link, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tld.FramePath(tt.file, tt.variant)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TLDef.FramePath(%q, %q) error %v, wantErr %t", tt.file, tt.variant, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_server_handleGallery(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	times := writeGalleryFrames(t, tld)
+	file := url.PathEscape(tld.Name + " " + times[1].Format(fileTimeLayout))
+	name := url.PathEscape(tld.Name)
+
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	tests := []struct {
+		name        string
+		path        string
+		wantStatus  int
+		substring   string
+		contentType string
+	}{
+		{name: "gallery", path: "/webcams/" + name + "/gallery?date=2020-05-27", wantStatus: http.StatusOK, substring: "solar noon"},
+		{name: "gallery bad date", path: "/webcams/" + name + "/gallery?date=May", wantStatus: http.StatusBadRequest},
+		{name: "gallery unknown webcam", path: "/webcams/nosuch/gallery", wantStatus: http.StatusNotFound},
+		{name: "viewer", path: "/webcams/" + name + "/view/" + file, wantStatus: http.StatusOK, substring: "Next &raquo;"},
+		{name: "viewer not a frame", path: "/webcams/" + name + "/view/timelapse.json", wantStatus: http.StatusNotFound},
+		{name: "frame", path: "/webcams/" + name + "/frames/" + file, wantStatus: http.StatusOK, contentType: "image/png"},
+		{name: "thumbnail", path: "/webcams/" + name + "/frames/" + file + "?thumb=1", wantStatus: http.StatusOK, contentType: "image/jpeg"},
+		{name: "frame traversal", path: "/webcams/" + name + "/frames/..%2Ftimelapse.json", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Fatalf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.substring != "" && !strings.Contains(rr.Body.String(), tt.substring) {
+				t.Errorf("%s want substring %q, not found", tt.name, tt.substring)
+			}
+			if got := rr.Header().Get("Content-Type"); tt.contentType != "" && got != tt.contentType {
+				t.Errorf("%s got content type %q, want %q", tt.name, got, tt.contentType)
+			}
+		})
+	}
+}
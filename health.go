@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// tickGrace is added to the poll interval and backoff before a capture go
+// routine that hasn't ticked is reported as stalled, covering a retrieval
+// and the daily solar and time zone lookups
+const tickGrace = 60 * time.Second
+
+// healthCheck is the result of one check of "/healthz" or "/readyz"
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the JSON body of "/healthz" and "/readyz"
+type healthResponse struct {
+	Status string        `json:"status"` // "ok", or "fail" if any check failed
+	Checks []healthCheck `json:"checks"`
+}
+
+// lookupState is the outcome of the latest lookup from a provider
+type lookupState struct {
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string // without the request URL, see lookupError
+	LastReason  string // failureReason of LastError, e.g., "timeout"
+}
+
+// lookupTracker records the latest lookup from each provider, for "/readyz"
+type lookupTracker struct {
+	mu     sync.Mutex
+	states map[string]*lookupState
+}
+
+// done records a lookup from provider, reporting it to the metrics too
+func (lt *lookupTracker) done(m *metrics, provider string, start time.Time, err error) {
+	m.lookupDone(provider, start, err)
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if lt.states == nil {
+		lt.states = make(map[string]*lookupState)
+	}
+	st, ok := lt.states[provider]
+	if !ok {
+		st = &lookupState{}
+		lt.states[provider] = st
+	}
+	if err != nil {
+		st.LastFailure, st.LastError, st.LastReason = time.Now(), lookupError(err), failureReason(err)
+		return
+	}
+	st.LastSuccess = time.Now()
+}
+
+// lookupError describes a failed lookup without the request URL, whose
+// query may carry an API key
+func lookupError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Sprintf("%s: %v", urlErr.Op, urlErr.Err)
+	}
+	return err.Error()
+}
+
+// state returns a copy of the latest lookup from provider, and whether
+// there was one
+func (lt *lookupTracker) state(provider string) (lookupState, bool) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if st, ok := lt.states[provider]; ok {
+		return *st, true
+	}
+	return lookupState{}, false
+}
+
+// lookupDone records a solar or time zone lookup started at start
+func (s *server) lookupDone(provider string, start time.Time, err error) {
+	s.lookups.done(s.metrics, provider, start, err)
+}
+
+// definitionsPath returns the path of the definitions file, in the
+// configured folder
+func (s *server) definitionsPath() string {
+	return filepath.Join(s.config.path, masterFile)
+}
+
+// loadDefinitions reads the definitions file into s.mtld, and records
+// that they are loaded
+func (s *server) loadDefinitions() error {
+	if err := s.mtld.Read(s.definitionsPath(), s.validate); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.loaded = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// schedulerTick records that the capture go routine of a webcam is looping
+func (s *server) schedulerTick(tld *TLDef) {
+	now := tld.env().Clock.Now()
+	s.updateStatus(tld, func(st *webcamStatus) {
+		st.LastTick = now
+	})
+}
+
+// stalled reports whether a capture go routine polling every poll seconds
+// has missed its ticks
+func (st webcamStatus) stalled(poll int, now time.Time) bool {
+	allowed := 2*time.Duration(poll)*time.Second + time.Duration(st.Backoff)*time.Second + tickGrace
+	return st.Running && now.Sub(st.LastTick) > allowed
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// liveness checks that every capture go routine is ticking
+func (s *server) liveness() []healthCheck {
+	now := s.providers.Clock.Now()
+
+	s.mu.Lock()
+	running := 0
+	var stalled []string
+	for tld, st := range s.status {
+		if !st.Running {
+			continue
+		}
+		running++
+		if st.stalled(s.config.pollSecs, now) {
+			stalled = append(stalled, fmt.Sprintf("%s (last tick %s ago)", tld.Name, now.Sub(st.LastTick).Truncate(time.Second)))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(stalled)
+	sched := healthCheck{Name: "scheduler", OK: len(stalled) == 0, Detail: fmt.Sprintf("%d capture loops ticking", running)}
+	if !sched.OK {
+		sched.Detail = "stalled: " + strings.Join(stalled, ", ")
+	}
+	return []healthCheck{sched}
+}
+
+// readiness checks that the definitions are loaded, the templates parsed,
+// the webcam folders writable, and the solar and time zone lookups working
+// or their results cached in the running webcams' schedules
+func (s *server) readiness() []healthCheck {
+	sn := "readiness"
+
+	s.mu.Lock()
+	loaded := s.loaded
+	tlds := append(masterTLDefs{}, *s.mtld...)
+	scheduled, unscheduled := 0, 0
+	for _, st := range s.status {
+		if !st.Running {
+			continue
+		}
+		if !st.NextCapture.IsZero() {
+			scheduled++
+		} else {
+			unscheduled++
+		}
+	}
+	s.mu.Unlock()
+
+	defs := healthCheck{Name: "definitions", OK: !loaded.IsZero(), Detail: "not loaded"}
+	if defs.OK {
+		defs.Detail = fmt.Sprintf("%d webcams, loaded %s", len(tlds), loaded.Format(time.RFC3339))
+	}
+
+	templates := healthCheck{Name: "templates", OK: s.tmpl != nil, Detail: "not parsed"}
+	if templates.OK {
+		templates.Detail = fmt.Sprintf("%d parsed", len(s.tmpl.Templates()))
+	}
+
+	storage := healthCheck{Name: "storage", OK: true}
+	var unwritable []string
+	writable := 0
+	for _, tld := range tlds {
+		if tld.Paused {
+			continue
+		}
+		if err := checkWritable(tld.FolderPath); err != nil {
+			log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+			unwritable = append(unwritable, tld.Name)
+			continue
+		}
+		writable++
+	}
+	storage.Detail = fmt.Sprintf("%d webcam folders writable", writable)
+	if len(unwritable) > 0 {
+		storage.OK = false
+		storage.Detail = "not writable: " + strings.Join(unwritable, ", ")
+	}
+
+	checks := []healthCheck{defs, templates, storage}
+	for _, provider := range []string{lookupSolar, lookupTimeZone} {
+		checks = append(checks, s.providerCheck(provider, scheduled, unscheduled))
+	}
+	return checks
+}
+
+// providerCheck reports a lookup provider as working if its latest lookup
+// succeeded, or if all running webcams have a schedule (its results are
+// cached until the next day). "/readyz" is public, so only the reason of a
+// failure is reported, the error is logged.
+func (s *server) providerCheck(provider string, scheduled, unscheduled int) healthCheck {
+	sn := "providerCheck"
+
+	c := healthCheck{Name: provider, OK: true}
+	st, ok := s.lookups.state(provider)
+	switch {
+	case !ok:
+		c.Detail = "no lookups yet"
+	case !st.LastSuccess.Before(st.LastFailure):
+		c.Detail = "reachable, last lookup " + st.LastSuccess.Format(time.RFC3339)
+	case unscheduled == 0:
+		c.Detail = fmt.Sprintf("unreachable (%s), %d schedules cached", st.LastReason, scheduled)
+	default:
+		log.Printf("%s, %s: %s\n", sn, provider, st.LastError)
+		c.OK = false
+		c.Detail = fmt.Sprintf("unreachable (%s), %d running webcams without a schedule", st.LastReason, unscheduled)
+	}
+	return c
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".readyz")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// writeHealth writes the checks as the JSON response, with status 503 if
+// any failed
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	resp := healthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
+
+// handleHealthz is the handler for GET "/healthz": the process is alive
+// and its capture go routines are ticking
+func (s *server) handleHealthz() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		writeHealth(w, s.liveness())
+	}
+}
+
+// handleReadyz is the handler for GET "/readyz": the server is ready to
+// capture and serve, see readiness
+func (s *server) handleReadyz() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		writeHealth(w, s.readiness())
+	}
+}
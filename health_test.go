@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_webcamStatus_stalled(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		st   webcamStatus
+		want bool
+	}{
+		{name: "ticking", st: webcamStatus{Running: true, LastTick: now.Add(-time.Minute)}, want: false},
+		{name: "stalled", st: webcamStatus{Running: true, LastTick: now.Add(-5 * time.Minute)}, want: true},
+		{name: "backing off", st: webcamStatus{Running: true, LastTick: now.Add(-5 * time.Minute), Backoff: 600}, want: false},
+		{name: "not running", st: webcamStatus{LastTick: now.Add(-time.Hour)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.st.stalled(60, now); got != tt.want {
+				t.Errorf("webcamStatus.stalled() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// getHealth requests path from s, returning the status and decoded body
+func getHealth(t *testing.T, s *server, path string) (int, healthResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	var resp healthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s got %q: %v", path, rr.Body.String(), err)
+	}
+	return rr.Code, resp
+}
+
+func Test_server_handleHealthz(t *testing.T) {
+	s := newServerFor(&Config{path: srv.config.path, pollSecs: 60})
+	s.routes()
+
+	tld := &TLDef{Name: "testHealth"}
+	s.status[tld] = &webcamStatus{Running: true, LastTick: time.Now()}
+	if code, resp := getHealth(t, s, "/healthz"); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("handleHealthz ticking got %d %+v", code, resp)
+	}
+
+	s.status[tld].LastTick = time.Now().Add(-time.Hour)
+	code, resp := getHealth(t, s, "/healthz")
+	if code != http.StatusServiceUnavailable || resp.Status != "fail" || !strings.Contains(resp.Checks[0].Detail, "testHealth") {
+		t.Errorf("handleHealthz stalled got %d %+v", code, resp)
+	}
+}
+
+func Test_server_handleReadyz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timelapse-readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newServerFor(&Config{path: srv.config.path, pollSecs: 60})
+	s.routes()
+	tld := &TLDef{Name: "testReady", FolderPath: dir}
+	s.mtld.Append(tld)
+	s.status[tld] = &webcamStatus{Running: true, LastTick: time.Now()}
+
+	checks := func() map[string]healthCheck {
+		_, resp := getHealth(t, s, "/readyz")
+		m := make(map[string]healthCheck)
+		for _, c := range resp.Checks {
+			m[c.Name] = c
+		}
+		return m
+	}
+
+	got := checks()
+	if got["definitions"].OK || got["templates"].OK || !got["storage"].OK || !got[lookupSolar].OK {
+		t.Errorf("handleReadyz before loading got %+v", got)
+	}
+
+	s.loaded = time.Now()
+	s.initTemplates("./templates", ".html")
+	s.lookupDone(lookupTimeZone, time.Now(), &url.Error{Op: "Get", URL: "http://api.timezonedb.com/v2.1/get-time-zone?key=SECRET", Err: errors.New("dial tcp: i/o timeout")})
+	got = checks()
+	if !got["definitions"].OK || !got["templates"].OK {
+		t.Errorf("handleReadyz loaded got %+v", got)
+	}
+	if got[lookupTimeZone].OK || !strings.Contains(got[lookupTimeZone].Detail, "1 running webcams without a schedule") {
+		t.Errorf("handleReadyz failed lookup without schedule got %+v", got[lookupTimeZone])
+	}
+	if st, _ := s.lookups.state(lookupTimeZone); strings.Contains(got[lookupTimeZone].Detail, "SECRET") || strings.Contains(st.LastError, "SECRET") {
+		t.Errorf("handleReadyz failed lookup got %q, error %q, want them without the key", got[lookupTimeZone].Detail, st.LastError)
+	}
+
+	s.status[tld].NextCapture = time.Now() // schedule cached
+	if c := checks()[lookupTimeZone]; !c.OK {
+		t.Errorf("handleReadyz failed lookup with schedule got %+v", c)
+	}
+	s.status[tld].NextCapture = time.Time{}
+	s.lookupDone(lookupTimeZone, time.Now(), nil)
+	if c := checks()[lookupTimeZone]; !c.OK || !strings.HasPrefix(c.Detail, "reachable") {
+		t.Errorf("handleReadyz lookup recovered got %+v", c)
+	}
+
+	if code, _ := getHealth(t, s, "/readyz"); code != http.StatusOK {
+		t.Errorf("handleReadyz got %d, want %d", code, http.StatusOK)
+	}
+	tld.FolderPath = dir + "/missing"
+	code, resp := getHealth(t, s, "/readyz")
+	if code != http.StatusServiceUnavailable || resp.Status != "fail" {
+		t.Errorf("handleReadyz folder missing got %d %+v", code, resp)
+	}
+}
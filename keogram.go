@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultSliceWidth = 8  // pixels taken from the center of each frame for a keogram
+	maxSliceWidth     = 64 // bounds the keogram image, with maxRenderDays
+)
+
+// KeogramColumn describes the slice contributed by one frame to a keogram
+type KeogramColumn struct {
+	Frame
+	Pixels []string `json:"pixels"` // slice colors, top to bottom, as "#rrggbb" (center column of the slice)
+}
+
+// Keogram holds a keogram: a vertical slice from the center of every
+// frame, stacked horizontally in capture order
+type Keogram struct {
+	Name    string          `json:"name"`
+	From    time.Time       `json:"from"`
+	To      time.Time       `json:"to"`
+	Height  int             `json:"height"`
+	Slice   int             `json:"slice"`
+	Columns []KeogramColumn `json:"columns"`
+	img     *image.RGBA
+}
+
+// Keogram builds a keogram from the frames captured in [from, to), taking a
+// slice of the specified width, at most maxSliceWidth, from the center of
+// each frame. Frames are scaled vertically to the height of the first frame.
+func (tld *TLDef) Keogram(from, to time.Time, slice int) (*Keogram, error) {
+	sn := "TLDef.Keogram"
+
+	if slice < 1 {
+		slice = defaultSliceWidth
+	}
+	if slice > maxSliceWidth {
+		slice = maxSliceWidth
+	}
+
+	frames, err := tld.Frames(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s, %s no frames between %s and %s", sn, tld.Name, from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	k := &Keogram{Name: tld.Name, From: from, To: to, Slice: slice}
+	for _, frame := range frames {
+		img, _, err := LoadFrame(frame.Path)
+		if err != nil {
+			log.Printf("%s, %s skipping frame: %v\n", sn, tld.Name, err)
+			continue
+		}
+		b := img.Bounds()
+		if k.img == nil {
+			k.Height = b.Dy()
+			k.img = image.NewRGBA(image.Rect(0, 0, slice*len(frames), k.Height))
+		}
+
+		x0 := b.Min.X + (b.Dx()-slice)/2
+		x := len(k.Columns) * slice // unreadable frames leave no gap
+		col := KeogramColumn{Frame: frame, Pixels: make([]string, k.Height)}
+		for y := 0; y < k.Height; y++ {
+			srcY := b.Min.Y + y*b.Dy()/k.Height
+			for dx := 0; dx < slice; dx++ {
+				srcX := x0 + dx
+				if srcX < b.Min.X || srcX >= b.Max.X {
+					continue
+				}
+				k.img.Set(x+dx, y, img.At(srcX, srcY))
+			}
+			r, g, b, _ := color.RGBAModel.Convert(img.At(x0+slice/2, srcY)).RGBA()
+			col.Pixels[y] = fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+		}
+		k.Columns = append(k.Columns, col)
+	}
+	if k.img == nil {
+		return nil, fmt.Errorf("%s, %s no readable frames between %s and %s", sn, tld.Name, from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	// drop the space reserved for unreadable frames
+	k.img = k.img.SubImage(image.Rect(0, 0, slice*len(k.Columns), k.Height)).(*image.RGBA)
+	return k, nil
+}
+
+// Image returns the keogram as an image
+func (k *Keogram) Image() image.Image {
+	return k.img
+}
+
+// ********** ********** ********** ********** ********** **********
+
+const brightnessStride = 4 // Brightness samples every fourth pixel in each direction
+
+// Brightness returns the mean luma (0-255) of an image, sampling every
+// fourth pixel in each direction
+func Brightness(img image.Image) float64 {
+	b := img.Bounds()
+	var sum float64
+	var n int
+	for y := b.Min.Y; y < b.Max.Y; y += brightnessStride {
+		for x := b.Min.X; x < b.Max.X; x += brightnessStride {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// LightSample is the mean brightness of one frame
+type LightSample struct {
+	Frame
+	Brightness float64 `json:"brightness"`
+}
+
+// SolarDay holds the solar times of one day, as used to schedule captures
+type SolarDay struct {
+	Date      string    `json:"date"`
+	Sunrise   time.Time `json:"sunrise"`
+	SolarNoon time.Time `json:"solarNoon"`
+	Sunset    time.Time `json:"sunset"`
+}
+
+// LightCurve holds the per-frame brightness of a webcam over a date range,
+// with the solar times of each day
+type LightCurve struct {
+	Name    string        `json:"name"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Samples []LightSample `json:"samples"`
+	Solar   []SolarDay    `json:"solar"`
+}
+
+// LightCurve measures the brightness of each frame captured in [from, to)
+// and retrieves sunrise, solar noon and sunset for each day in the range
+func (tld *TLDef) LightCurve(from, to time.Time) (*LightCurve, error) {
+	sn := "TLDef.LightCurve"
+
+	frames, err := tld.Frames(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := &LightCurve{Name: tld.Name, From: from, To: to, Samples: []LightSample{}, Solar: []SolarDay{}}
+	for _, frame := range frames {
+		img, _, err := LoadFrame(frame.Path)
+		if err != nil {
+			log.Printf("%s, %s skipping frame: %v\n", sn, tld.Name, err)
+			continue
+		}
+		lc.Samples = append(lc.Samples, LightSample{Frame: frame, Brightness: Brightness(img)})
+	}
+
+	// use a copy, so the solar times of tld are not disturbed
+	probe := *tld
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if err := probe.GetSolarTimes(day); err != nil {
+			log.Printf("%s, %s solar times for %s unavailable: %v\n", sn, tld.Name, day.Format(dateLayout), err)
+			continue
+		}
+		lc.Solar = append(lc.Solar, SolarDay{
+			Date:      day.Format(dateLayout),
+			Sunrise:   probe.SunriseUTC.In(tld.env().Local),
+			SolarNoon: probe.SolarNoonUTC.In(tld.env().Local),
+			Sunset:    probe.SunsetUTC.In(tld.env().Local),
+		})
+	}
+
+	return lc, nil
+}
+
+// Image plots the light curve: brightness against time, with sunrise,
+// solar noon and sunset marked by vertical lines
+func (lc *LightCurve) Image(w, h int) image.Image {
+	p := newPlot(w, h, float64(lc.From.Unix()), float64(lc.To.Unix()), 0, 255)
+
+	for _, sd := range lc.Solar {
+		p.vline(float64(sd.Sunrise.Unix()), plotSunrise)
+		p.vline(float64(sd.SolarNoon.Unix()), plotSolarNoon)
+		p.vline(float64(sd.Sunset.Unix()), plotSunset)
+	}
+
+	xs := make([]float64, len(lc.Samples))
+	ys := make([]float64, len(lc.Samples))
+	for i, s := range lc.Samples {
+		xs[i] = float64(s.Time.Unix())
+		ys[i] = s.Brightness
+	}
+	p.series(xs, ys, plotSeries)
+
+	return p.img
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleKeogram is the handler for "/webcams/:name/keogram.png" and
+// "/webcams/:name/keogram.json"
+func (s *server) handleKeogram(format string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleKeogram"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkRenderDays(from, to); err != nil { // every frame in the range is decoded
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		slice := defaultSliceWidth
+		if v := r.URL.Query().Get("slice"); v != "" {
+			var err error
+			if slice, err = strconv.Atoi(v); err != nil || slice < 1 || slice > maxSliceWidth {
+				http.Error(w, fmt.Sprintf("invalid slice %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		k, err := tld.Keogram(from, to, slice)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if format == "json" {
+			writeJSON(w, http.StatusOK, k)
+			return
+		}
+		writePNG(w, k.Image())
+	}
+}
+
+// handleLightCurve is the handler for "/webcams/:name/lightcurve.png" and
+// "/webcams/:name/lightcurve.json"
+func (s *server) handleLightCurve(format string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleLightCurve"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkPreviewDays(from, to); err != nil { // sunrise-sunset.org is queried once per day
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lc, err := tld.LightCurve(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if format == "json" {
+			writeJSON(w, http.StatusOK, lc)
+			return
+		}
+		writePNG(w, lc.Image(800, 300))
+	}
+}
+
+// webcamAndRange looks up the webcam named in the route and the date range
+// in the query, writing an error response when either is invalid. The
+// webcam's definition is copied, see TLDef.definition, as its capture go
+// routine may be running.
+func (s *server) webcamAndRange(w http.ResponseWriter, r *http.Request, p httprouter.Params) (*TLDef, time.Time, time.Time, bool) {
+	s.mu.Lock()
+	tld := s.mtld.Find(p.ByName("name"))
+	if tld != nil {
+		tld = tld.definition()
+	}
+	s.mu.Unlock()
+	if tld == nil {
+		http.Error(w, fmt.Sprintf("webcam %q not found", p.ByName("name")), http.StatusNotFound)
+		return nil, time.Time{}, time.Time{}, false
+	}
+
+	from, to, err := parseDateRange(r, s.providers.Local)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, time.Time{}, time.Time{}, false
+	}
+
+	return tld, from, to, true
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON, json.Encode: %v\n", err)
+	}
+}
+
+// writePNG writes img as the PNG response body
+func writePNG(w http.ResponseWriter, img image.Image) {
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, img); err != nil {
+		log.Printf("writePNG, png.Encode: %v\n", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestFrame stores a uniformly colored w x h PNG frame for tld,
+// named as TargetFileName would name a capture at t
+func writeTestFrame(t *testing.T, tld *TLDef, at time.Time, w, h int, c color.Color) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+
+	path := filepath.Join(tld.FolderPath, tld.Name+" "+at.Format(fileTimeLayout))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newFramesTLD(t *testing.T) (*TLDef, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "timelapse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tld := newBaseTLD()
+	tld.Name = "test frames"
+	tld.FolderPath = dir
+	return &tld, func() { os.RemoveAll(dir) }
+}
+
+func TestTLDef_Frames(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	writeTestFrame(t, tld, solarNoon, 4, 4, color.White)
+	writeTestFrame(t, tld, sunrise, 4, 4, color.White)
+	writeTestFrame(t, tld, sunset.AddDate(0, 0, 1), 4, 4, color.White) // next day
+	ioutil.WriteFile(filepath.Join(tld.FolderPath, "other file"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(tld.FolderPath, tld.Name+" 2020"), []byte("x"), 0644)
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want []time.Time
+	}{
+		{name: "one day",
+			from: day,
+			to:   day.AddDate(0, 0, 1),
+			want: []time.Time{sunrise, solarNoon},
+		},
+		{name: "two days",
+			from: day,
+			to:   day.AddDate(0, 0, 2),
+			want: []time.Time{sunrise, solarNoon, sunset.AddDate(0, 0, 1)},
+		},
+		{name: "no frames",
+			from: day.AddDate(0, 0, -1),
+			to:   day,
+			want: []time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tld.Frames(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("TLDef.Frames() got %d frames, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].Time.Equal(tt.want[i]) {
+					t.Errorf("TLDef.Frames() frame %d got %v, want %v", i, got[i].Time, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTLDef_Keogram(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	red := color.RGBA{255, 0, 0, 255}
+	writeTestFrame(t, tld, sunrise, 20, 10, color.Black)
+	writeTestFrame(t, tld, solarNoon, 40, 20, red) // scaled to the first frame's height
+	writeTestFrame(t, tld, sunset, 20, 10, color.White)
+
+	k, err := tld.Keogram(day, day.AddDate(0, 0, 1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := k.Image().Bounds(); got != image.Rect(0, 0, 6, 10) {
+		t.Errorf("TLDef.Keogram() bounds got %v, want %v", got, image.Rect(0, 0, 6, 10))
+	}
+	if len(k.Columns) != 3 {
+		t.Fatalf("TLDef.Keogram() got %d columns, want 3", len(k.Columns))
+	}
+	wantPixels := []string{"#000000", "#ff0000", "#ffffff"}
+	for i, col := range k.Columns {
+		if col.Pixels[5] != wantPixels[i] {
+			t.Errorf("TLDef.Keogram() column %d got %s, want %s", i, col.Pixels[5], wantPixels[i])
+		}
+	}
+
+	if _, err := tld.Keogram(day.AddDate(0, 0, 1), day.AddDate(0, 0, 2), 2); err == nil {
+		t.Errorf("TLDef.Keogram() without frames, want error")
+	}
+}
+
+func TestTLDef_Keogram_unreadable(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	writeTestFrame(t, tld, sunrise, 20, 10, color.Black)
+	bad := filepath.Join(tld.FolderPath, tld.Name+" "+solarNoon.Format(fileTimeLayout))
+	if err := ioutil.WriteFile(bad, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFrame(t, tld, sunset, 20, 10, color.White)
+
+	k, err := tld.Keogram(day, day.AddDate(0, 0, 1), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := k.Image().Bounds(); got != image.Rect(0, 0, 4, 10) {
+		t.Errorf("TLDef.Keogram() bounds got %v, want %v", got, image.Rect(0, 0, 4, 10))
+	}
+	wantPixels := []color.Color{color.Black, color.Black, color.White, color.White} // no gap for the unreadable frame
+	for x, want := range wantPixels {
+		r, g, b, _ := k.Image().At(x, 5).RGBA()
+		wr, wg, wb, _ := want.RGBA()
+		if r != wr || g != wg || b != wb {
+			t.Errorf("TLDef.Keogram() pixel %d got %v, want %v", x, k.Image().At(x, 5), want)
+		}
+	}
+}
+
+func TestBrightness(t *testing.T) {
+	tests := []struct {
+		name  string
+		color color.Color
+		want  float64
+	}{
+		{name: "black", color: color.Black, want: 0},
+		{name: "white", color: color.White, want: 255},
+		{name: "gray", color: color.Gray{128}, want: 128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+			draw.Draw(img, img.Bounds(), &image.Uniform{tt.color}, image.Point{}, draw.Src)
+			if got := Brightness(img); got < tt.want-0.01 || got > tt.want+0.01 {
+				t.Errorf("Brightness() got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_handleKeogram(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "no frames", query: "?date=2020-05-27", wantStatus: http.StatusNotFound},
+		{name: "widest slice", query: "?date=2020-05-27&slice=64", wantStatus: http.StatusNotFound},
+		{name: "slice too wide", query: "?date=2020-05-27&slice=65", wantStatus: http.StatusBadRequest},
+		{name: "slice zero", query: "?date=2020-05-27&slice=0", wantStatus: http.StatusBadRequest},
+		{name: "too many days", query: "?from=2020-01-01&to=2020-12-31", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/webcams/"+url.PathEscape("Manzanita Lake")+"/keogram.json"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handleKeogram() %s got %d, want %d: %s", tt.query, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
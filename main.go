@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -17,7 +17,6 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -35,7 +34,6 @@ import (
 var srv *server
 
 const (
-	masterPath = "/Users/peterplamondon/Downloads/timelapse/"
 	masterFile = "timelapse.json"
 	timeLayout = "2006-01-02T15:04:05Z" // ISO 8601; see https://sunrise-sunset.org/api, https://godoc.org/time#Time.Format and https://ednsquare.com/story/date-and-time-manipulation-golang-with-examples------cU1FjK
 )
@@ -54,18 +52,36 @@ const (
 	lastTime
 )
 
+const manualSlot = -1 // CaptureTimes index of a capture outside the schedule, see "capture-now"
+
 func main() {
 	var err error
 
 	defer catch() // implements recover so panics reported
 	sn := "main"
 
+	if len(os.Args) > 1 {
+		if cmd := findCommand(os.Args[1]); cmd != nil {
+			os.Exit(runCommand(cmd, os.Args[2:]))
+		}
+	}
+
 	srv = newServer()
 
-	if err = srv.mtld.Read(filepath.Join(masterPath, masterFile)); err != nil {
-		msg := fmt.Sprintf("%s, srv.mtld.Read: %v", sn, err)
+	if srv.auth, err = loadAuth(srv.config.users, srv.config.tokens); err != nil {
+		log.Fatalf("%s, loadAuth: %v\n", sn, err)
+	}
+	if srv.auth == nil {
+		log.Printf("%s, no users or tokens configured, anyone reaching port %s may change webcams\n", sn, srv.config.port)
+	}
+
+	if err = srv.loadDefinitions(); err != nil {
+		msg := fmt.Sprintf("%s, srv.loadDefinitions: %v", sn, err)
 		panic(msg)
 	}
+	if err = srv.loadState(); err != nil {
+		log.Printf("%s, srv.loadState: %v, starting afresh\n", sn, err)
+	}
 
 	runtime.GOMAXPROCS(2)
 
@@ -76,35 +92,37 @@ func main() {
 	for _, tld := range *(srv.mtld) {
 		// log.Printf("%s, launching goroutine #%d (%s), FirstFlags %b, LastFlags %b",
 		// 	sn, i, tld.Name, tld.FirstFlags, tld.LastFlags)
-		srv.wg.Add(1)
-		go capture(srv.ctx, tld, srv.config.pollSecs)
+		if tld.Paused {
+			log.Printf("%s, %s paused, not capturing\n", sn, tld.Name)
+			continue
+		}
+		if err := srv.config.resolveFolder(tld); err != nil {
+			log.Printf("%s, %s not capturing: %v\n", sn, tld.Name, err)
+			continue
+		}
+		srv.startCapture(tld)
 		time.Sleep(1 * time.Second) // respect TimeZoneDB.com limit 1 request/second
 	}
 
 	srv.initTemplates("./templates", ".html")
-	srv.router.ServeFiles("/static/*filepath", http.Dir("static"))
-	srv.router.POST("/new", srv.handleNew())
-	srv.router.GET("/", srv.handleHome())
+	srv.routes()
 
 	hs := http.Server{
 		Addr:         ":" + srv.config.port,
-		Handler:      middleware.LogReqResp(srv.router),
+		Handler:      srv.handler(),
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: 10 * time.Second, // lifted by handleEvents for its streams
 	}
 	log.Printf("Starting service %s listening on port %s", sn, hs.Addr)
 	go startListening(&hs, "main") // call ListenAndServe from a separate go routine so main can listen for signals
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	defer func() { // on handled signals, cancel goroutines, wait and exit
-		signal.Stop(c)
-		srv.cancel()
-		srv.wg.Wait()
-	}()
 
 	s := <-c
+	signal.Stop(c) // a second signal terminates immediately
 	log.Printf("\n%s received signal %s, terminating", sn, s.String())
+	srv.shutdown(&hs, time.Duration(srv.config.shutdownSecs)*time.Second)
 }
 
 // startListening invokes ListenAndServe
@@ -124,171 +142,401 @@ func catch() {
 	}()
 }
 
+// handler returns the http.Handler serving the routes, authorizing and
+// logging requests. Event streams bypass the logging, whose ResponseWriter
+// can't be flushed.
+func (s *server) handler() http.Handler {
+	authorized := s.authorize(s.router)
+	logged := middleware.LogReqResp(authorized)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" || strings.HasSuffix(r.URL.Path, "/events") {
+			log.Printf("%s %s %s stream\n", r.RemoteAddr, r.Method, r.URL)
+			authorized.ServeHTTP(w, r)
+			return
+		}
+		logged.ServeHTTP(w, r)
+	})
+}
+
 // ********** ********** ********** ********** ********** **********
 
-func capture(ctx context.Context, tld *TLDef, pollInterval int) {
+// capture is the capture go routine of a webcam: it captures an image at
+// each of the webcam's capture times, checking every pollInterval seconds
+// of its clock
+func (s *server) capture(ctx context.Context, tld *TLDef, pollInterval int) {
 	sn := fmt.Sprintf("capture.%s", tld.Name)
+	clock := tld.env().Clock
 
-	tld.SetCaptureTimes(time.Now()) // calculate all capture times for today
-	tld.UpdateNextCapture(time.Now())
+	var retry scheduleRetry                 // while there are no capture times
+	err := tld.SetCaptureTimes(clock.Now()) // calculate all capture times for today
+	// resume today's schedule and pending retry after a restart, or catch up
+	if !s.restoreState(tld) && err == nil {
+		err = s.catchUp(tld, clock.Now())
+	}
+	if err != nil {
+		log.Printf("%s, no capture times: %v\n", sn, err)
+		retry.failed(err, clock.Now())
+	}
+	s.publishNextCapture(tld)
 
-	log.Printf("%s, timezone %s, NextCapture %s, CaptureTimes (len %d): %v, FirstFlags %b, LastFlags %b\n",
-		sn, tld.WebcamTZ, tld.CaptureTimes[tld.NextCapture], len(tld.CaptureTimes), tld.CaptureTimes, tld.FirstFlags, tld.LastFlags)
+	if tld.scheduled() {
+		log.Printf("%s, timezone %s, NextCapture %s, CaptureTimes (len %d): %v, FirstFlags %b, LastFlags %b\n",
+			sn, tld.WebcamTZ, tld.NextCaptureTime(), len(tld.CaptureTimes), tld.CaptureTimes, tld.FirstFlags, tld.LastFlags)
+	}
 
+	lastTick := clock.Now() // to detect clock jumps, see clockJump
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("%s exiting after ctx.Done\n", sn)
-			srv.wg.Done()
+			s.wg.Done()
 			return
 		default:
-			if tld.IsTimeForCapture() {
+			s.schedulerTick(tld)
+			now := clock.Now()
+			if jump := clockJump(lastTick, now); (jump > clockJumpThreshold || jump < -clockJumpThreshold) && tld.scheduled() {
+				if err := s.clockJumped(tld, jump, now); err != nil {
+					log.Printf("%s, no capture times: %v\n", sn, err)
+					retry.failed(err, now)
+				}
+			}
+			lastTick = now
+			if !tld.scheduled() {
+				if !now.Before(retry.at) {
+					s.reschedule(tld, &retry, now)
+				}
+			} else if tld.IsTimeForCapture() {
 				if tld.Backoff > 0 {
 					log.Printf("%s, backing off %d seconds\n", sn, tld.Backoff)
-					time.Sleep(time.Second * time.Duration(tld.Backoff))
+					select {
+					case <-ctx.Done():
+						continue // handled at the top of the loop
+					case <-clock.After(time.Second * time.Duration(tld.Backoff)):
+					}
 				}
 
-				createdName, createdSize, err := tld.CaptureImage()
+				s.captureStarted(tld)
+				start := time.Now()
+				createdName, createdSize, err := tld.CaptureImage(s.drain)
+				s.metrics.captureDone(tld.Name, time.Since(start), createdSize, err)
 				if err != nil {
 					log.Printf("%s, CaptureImage: %v\n", sn, err)
 					tld.AdjustBackoff()
+					s.captureFailed(tld, err)
 					break
 				}
 				tld.Backoff = 0 // after successful capture, no backoff
+				s.captureSucceeded(tld, createdName, createdSize)
 				log.Printf("%s, %s created, size %s", sn, createdName, datasize.ByteSize(createdSize).HumanReadable())
 
-				tld.UpdateNextCapture(time.Now())
+				if err := tld.PostProcess(createdName, tld.NextCaptureTime(), tld.NextCapture); err != nil {
+					log.Printf("%s, PostProcess: %v\n", sn, err)
+				}
+
+				if err := tld.UpdateNextCapture(clock.Now()); err != nil {
+					log.Printf("%s, no capture times: %v\n", sn, err)
+					retry.failed(err, clock.Now())
+				}
+				s.publishNextCapture(tld)
 			}
 		}
 		// log.Printf("%s sleeping for %d seconds...\n", sn, pollInterval)
-		time.Sleep(time.Duration(pollInterval) * time.Second)
+		select {
+		case <-ctx.Done(): // handled at the top of the loop
+		case <-clock.After(time.Duration(pollInterval) * time.Second):
+		}
 	}
 }
 
-// AdjustBackoff implements our backoff policy when cannot retrieve a webcam image
+// AdjustBackoff implements our backoff policy when cannot retrieve a webcam
+// image: Backoff, in seconds, starts at 1 and doubles up to 10 minutes
 func (tld *TLDef) AdjustBackoff() {
 	const maxBackoff = time.Minute * 10
 
 	tld.Backoff = tld.Backoff * 2 // keep increasing the backoff time until no error
-	if time.Duration(tld.Backoff) > maxBackoff {
-		tld.Backoff = int64(maxBackoff)
+	if tld.Backoff == 0 {
+		tld.Backoff = 1
+	}
+	if time.Duration(tld.Backoff)*time.Second > maxBackoff {
+		tld.Backoff = int64(maxBackoff / time.Second)
+	}
+}
+
+// scheduleRetry is when the capture go routine of a webcam without capture
+// times, as they couldn't be set, sets them again
+type scheduleRetry struct {
+	at   time.Time     // when to retry
+	wait time.Duration // after a failed lookup, doubling up to maxScheduleWait
+}
+
+// maxScheduleWait is the longest wait before setting capture times again
+// after a failed lookup
+const maxScheduleWait = 10 * time.Minute
+
+// failed plans the retry after setting the capture times failed at now
+// with err: the first day with captures, if the fallback policy skips the
+// days looked at, or a backoff from a minute to maxScheduleWait otherwise
+func (sr *scheduleRetry) failed(err error, now time.Time) {
+	var skipped *noCapturesError
+	if errors.As(err, &skipped) {
+		sr.at, sr.wait = skipped.Until, 0
+		return
 	}
+
+	sr.wait *= 2
+	if sr.wait == 0 {
+		sr.wait = time.Minute
+	}
+	if sr.wait > maxScheduleWait {
+		sr.wait = maxScheduleWait
+	}
+	sr.at = now.Add(sr.wait)
+}
+
+// reschedule sets the capture times of a webcam without, see scheduleRetry,
+// catching up or skipping the slots already passed at now
+func (s *server) reschedule(tld *TLDef, retry *scheduleRetry, now time.Time) {
+	sn := fmt.Sprintf("reschedule.%s", tld.Name)
+
+	err := tld.SetCaptureTimes(now)
+	if err == nil {
+		err = s.catchUp(tld, now)
+	}
+	if err != nil {
+		retry.failed(err, now)
+		log.Printf("%s, no capture times: %v, retrying at %s\n", sn, err, retry.at.Format(time.RFC3339))
+		return
+	}
+
+	*retry = scheduleRetry{}
+	log.Printf("%s, NextCapture %s, CaptureTimes (len %d): %v\n", sn, tld.NextCaptureTime(), len(tld.CaptureTimes), tld.CaptureTimes)
+	s.publishNextCapture(tld)
 }
 
 // CaptureImage retrieves the webcam image and saves it in the specified
-// folder
-func (tld *TLDef) CaptureImage() (string, int64, error) {
+// folder. The image is downloaded to a temporary file which is renamed into
+// place, so an interrupted download never leaves a partial frame behind.
+// Cancelling ctx aborts the download.
+func (tld *TLDef) CaptureImage(ctx context.Context) (string, int64, error) {
 	// sn := fmt.Sprintf("CaptureImage.%q", tld.Name)
 
-	newFile, err := os.Create(tld.TargetFileName())
+	target := tld.TargetFileName()
+	newFile, err := ioutil.TempFile(tld.FolderPath, "."+filepath.Base(target)+".partial-*")
 	if err != nil {
-		// log.Printf("%s os.Create: %v\n", sn, err)
+		// log.Printf("%s ioutil.TempFile: %v\n", sn, err)
 		return "", 0, err
 	}
+	defer os.Remove(newFile.Name()) // no-op after a successful rename
 
-	respBody, err := tld.RetrieveImage()
+	respBody, err := tld.RetrieveImage(ctx)
 	if err != nil {
 		// log.Printf("%s RetrieveImage: %v\n", sn, err)
+		newFile.Close()
 		return "", 0, err
 	}
 	defer respBody.Close()
 
 	written, err := io.Copy(newFile, respBody) // io.Copy buffers I/O to support huge files
-	defer newFile.Close()
+	if cerr := newFile.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		// log.Printf("%s io.Copy: %v\n", sn, err)
 		return "", 0, err
 	}
+	if err := os.Chmod(newFile.Name(), 0644); err != nil { // ioutil.TempFile creates files -rw-------
+		return "", 0, err
+	}
+	if err := os.Rename(newFile.Name(), target); err != nil {
+		return "", 0, err
+	}
 
-	return newFile.Name(), written, nil
+	return target, written, nil
 }
 
 // TargetFileName returns the full target path, appending the capture
 // date and time to the webcam name, e.g., "[folder]/Manzanita Lake YYYYMMddhhmmss"
 func (tld *TLDef) TargetFileName() string {
-	layout := "20060102150405"
-	captureDateTime := tld.CaptureTimes[tld.NextCapture]
-	// log.Print("TODO: format tld.CaptureTimes[NextCapture] into YYYYMMDDhhmmss")
-	fileName := tld.Name + " " + captureDateTime.Format(layout)
+	captureDateTime := tld.NextCaptureTime()
+	fileName := tld.Name + " " + tld.fileStamp(captureDateTime)
 	return filepath.Join(tld.FolderPath, fileName)
 }
 
 // RetrieveImage retrieves the webcam image and returns resp.Body, for
 // reading and closing by the caller
-func (tld *TLDef) RetrieveImage() (io.ReadCloser, error) {
-	// sn := fmt.Sprintf("RetrieveImage.%q", tld.Name)
-
-	webcamReq, err := http.NewRequest("GET", tld.URL, nil)
+func (tld *TLDef) RetrieveImage(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := tld.retrieve(ctx)
 	if err != nil {
-		// log.Printf("%s http.NewRequest: %v\n", sn, err)
 		return nil, err
 	}
-
-	client := &http.Client{Timeout: time.Second * 10}
-
-	resp, err := client.Do(webcamReq)
-	if err != nil {
-		// log.Printf("%s client.Do: %v\n", sn, err)
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &statusError{URL: tld.URL, Status: resp.Status}
 	}
 
 	return resp.Body, nil
 }
 
+// retrieve requests the webcam image from the image source, returning the
+// response
+func (tld *TLDef) retrieve(ctx context.Context) (*http.Response, error) {
+	return tld.env().Images.Retrieve(ctx, tld.URL)
+}
+
 // ********** ********** ********** ********** ********** **********
 
 type server struct {
-	router   *httprouter.Router
-	validate *validator.Validate // use a single instance of Validate, it caches struct info
-	config   *Config
-	tmpl     *template.Template
-	localLoc *time.Location  // timezone where this code is running
-	mtld     *masterTLDefs   // timelapse definitions, read from/written to timelapse.json
-	ctx      context.Context // context used to cancel go routines
-	cancel   context.CancelFunc
-	wg       sync.WaitGroup
+	router    *httprouter.Router
+	validate  *validator.Validate // use a single instance of Validate, it caches struct info
+	config    *Config
+	tmpl      *template.Template
+	providers *Providers      // clock, lookups, image source and time zone of the webcams, see TLDef.env
+	mtld      *masterTLDefs   // timelapse definitions, read from/written to timelapse.json
+	ctx       context.Context // context used to cancel go routines
+	cancel    context.CancelFunc
+	drain     context.Context    // context of capture downloads, outliving ctx while draining
+	abort     context.CancelFunc // aborts capture downloads still running when draining times out
+	wg        sync.WaitGroup
+	mu        sync.Mutex               // guards mtld, captures and status
+	captures  map[*TLDef]*captureRun   // capture go routine of each running webcam
+	status    map[*TLDef]*webcamStatus // runtime state of each running webcam
+	events    *eventHub                // capture activity streamed by handleEvents
+	auth      *authStore               // users, API tokens and sessions, nil if sign in is disabled
+	metrics   *metrics                 // Prometheus metrics served by handleMetrics
+	lookups   lookupTracker            // latest solar and time zone lookups, for handleReadyz
+	loaded    time.Time                // when the definitions were read, guarded by mu
+	restored  map[string]webcamStatus  // saved state of webcams not yet started, guarded by mu
+	stateMu   sync.Mutex               // serializes writes of the state file
 }
 
 // newServer creates a new instance of server with router and validation
 // initialized and application configuration loaded
 func newServer() *server {
-	var err error
-	sn := "newServer"
+	c := &Config{}
+	c.Load()
+
+	return newServerFor(c)
+}
 
+// newServerFor creates a new instance of server with router and validation
+// initialized, using configuration c
+func newServerFor(c *Config) *server {
 	s := &server{}
 	s.router = httprouter.New()
 	s.validate = validator.New()
 
-	s.localLoc, err = time.LoadLocation("Local")
-	if err != nil {
-		msg := fmt.Sprintf("%s, time.LoadLocation(\"Local\"): %v", sn, err)
-		panic(msg)
-	}
-
 	s.mtld = newMasterTLDefs()
+	s.captures = make(map[*TLDef]*captureRun)
+	s.status = make(map[*TLDef]*webcamStatus)
+	s.events = newEventHub()
+	s.drain, s.abort = context.WithCancel(context.Background())
+	s.metrics = newMetrics(s)
 
-	s.config = &Config{}
-	s.config.Load()
+	s.config = c
+	s.providers = newProviders(c.tzdbAPI)
+	s.providers.Lookups = s.lookupDone
 
 	return s
 }
 
+// routes registers the handlers for all routes
+func (s *server) routes() {
+	s.router.ServeFiles("/static/*filepath", http.Dir("static"))
+	s.router.GET("/login", s.handleLoginForm())
+	s.router.POST("/login", s.handleLogin())
+	s.router.POST("/logout", s.handleLogout())
+	s.router.POST("/new", s.handleNew())
+	s.router.GET("/", s.handleHome())
+	s.router.GET("/webcams/:name/edit", s.handleEdit())
+	s.router.POST("/webcams/:name/edit", s.handleUpdate())
+	s.router.POST("/webcams/:name/pause", s.handlePause(true))
+	s.router.POST("/webcams/:name/resume", s.handlePause(false))
+	s.router.POST("/webcams/:name/delete", s.handleDelete())
+	s.router.GET("/webcams/:name/gallery", s.handleGallery())
+	s.router.GET("/webcams/:name/view/:file", s.handleViewer())
+	s.router.GET("/webcams/:name/frames/:file", s.handleFrame())
+	s.router.GET("/webcams/:name/latest", s.handleLatest())
+	s.router.GET("/webcams/:name/status", s.handleStatus())
+	s.router.GET("/webcams/:name/events", s.handleEvents())
+	s.router.GET("/events", s.handleEvents())
+	s.router.GET("/metrics", s.handleMetrics())
+	s.router.GET("/healthz", s.handleHealthz())
+	s.router.GET("/readyz", s.handleReadyz())
+	s.router.POST("/preview", s.handlePreview())
+	s.router.POST("/webcams/:name/preview", s.handlePreview())
+	s.router.POST(apiPrefix+"/preview", s.handleAPIPreview())
+	s.router.POST("/probe", s.handleProbe())
+	s.router.POST("/webcams/:name/probe", s.handleProbe())
+	s.router.POST(apiPrefix+"/probe", s.handleAPIProbe())
+	s.router.GET(apiPrefix+"/webcams", s.handleAPIList())
+	s.router.POST(apiPrefix+"/webcams", s.handleAPICreate())
+	s.router.GET(apiPrefix+"/webcams/:name", s.handleAPIGet())
+	s.router.PUT(apiPrefix+"/webcams/:name", s.handleAPIUpdate())
+	s.router.DELETE(apiPrefix+"/webcams/:name", s.handleAPIDelete())
+	s.router.GET("/webcams/:name/keogram.png", s.handleKeogram("png"))
+	s.router.GET("/webcams/:name/keogram.json", s.handleKeogram("json"))
+	s.router.GET("/webcams/:name/lightcurve.png", s.handleLightCurve("png"))
+	s.router.GET("/webcams/:name/lightcurve.json", s.handleLightCurve("json"))
+	s.router.GET("/webcams/:name/deflicker.png", s.handleDeflicker("png"))
+	s.router.GET("/webcams/:name/deflicker.json", s.handleDeflicker("json"))
+	s.router.GET("/webcams/:name/simulate.json", s.handleSimulate("json"))
+	s.router.GET("/webcams/:name/simulate.csv", s.handleSimulate("csv"))
+	s.router.GET("/webcams/:name/registration.json", s.handleRegistration())
+	s.router.POST("/webcams/:name/render", s.handleRender())
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
 
+// page is the data rendered by the "layout" template
+type page struct {
+	Company       string
+	Webcams       []webcamRow // listed on the home page
+	Form          *TLDef      // definition filling the form, nil for an empty form
+	Editing       bool        // Form is an existing definition
+	Action        string      // URL the form is submitted to
+	PreviewAction string      // URL the form is submitted to for a schedule preview
+	ProbeAction   string      // URL the form is submitted to for a webcam URL probe
+	Preview       *Schedule   // schedule preview, shown above the form
+	From, To      string      // preview date range, YYYY-MM-DD
+	Probe         *ProbeResult
+	Submitted     url.Values // form values as submitted, when re-rendering with Errors
+	Errors        formErrors // errors found in the submitted form, by field
+	Gallery       *Gallery   // frames of one day, shown instead of the form
+	Viewer        *Viewer    // one frame full size, shown instead of the form
+	Login         *loginPage // sign in form, shown instead of the form
+	User          string     // signed in user, "" if none
+	Admin         bool       // User may change webcams
+	AuthEnabled   bool       // users must sign in
+	CSRF          string     // token forms must carry, "" without a session
+	DefaultRoot   string     // storage root holding folders derived from webcam names, "" if none
+}
+
+// webcamRow is a webcam listed on the home page
+type webcamRow struct {
+	*TLDef
+	Status webcamStatus
+}
+
 // handleHome is the handler for "/"
 func (s *server) handleHome() httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		// startTime := time.Now()
 
-		data := struct {
-			Company string
-		}{
-			Company: "Timelapse",
+		data := formPage(nil, "")
+
+		s.mu.Lock()
+		for _, tld := range *s.mtld {
+			row := webcamRow{TLDef: tld}
+			if st, ok := s.status[tld]; ok {
+				row.Status = *st
+			}
+			data.Webcams = append(data.Webcams, row)
 		}
+		s.mu.Unlock()
 
-		srv.tmpl.ExecuteTemplate(w, "layout", data)
+		s.render(w, r, http.StatusOK, data)
 
 		// log.Printf("%s.%s, duration %v\n", sn, mn, time.Now().Sub(startTime))
 		return
@@ -302,104 +550,31 @@ func (s *server) handleNew() httprouter.Handle {
 		sn := "handleNew"
 		// startTime := time.Now()
 
-		if err := r.ParseForm(); err != nil {
-			log.Printf("FromForm: r.ParseForm: %v *****ERROR*****\n", err)
-			return
-		}
-
-		// dump r.Form contents
-		// log.Println("After r.ParseForm(), r.Form values:")
-		// for key, value := range r.Form {
-		// 	log.Printf("%q: %q\n", key, value)
-		// }
-
-		tld := newTLDef()
-
-		decoder := formam.NewDecoder(nil)
-		if err := decoder.Decode(r.Form, tld); err != nil {
-			log.Printf("%s, decoder.Decode: %v\n", sn, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// validate the TLDef we just decoded
-		if err := srv.validate.Struct(tld); err != nil {
-			log.Printf("%s, handleNew: %v\n", sn, err)
+		tld, errs, err := s.formTLDef(r)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		// validator package doesn't allow required numbers to be zero, so validate manually
-		if _, ok := r.Form["additional"]; !ok { // additional not present
-			msg := "Additional is required"
-			log.Printf("%s, handleNew: %s\n", sn, msg)
-			http.Error(w, msg, http.StatusBadRequest)
+		if len(errs) > 0 {
+			s.formInvalid(w, r, tld, "", r.Form, errs)
 			return
 		}
-		if _, ok := r.Form["additional"]; ok {
-			formVal := r.Form["additional"]
-			value, err := strconv.Atoi(formVal[0])
-			if err != nil {
-				log.Printf("%s, handleNew: strconv.Atoi(%s) %s\n", sn, formVal, err)
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			if value < 0 || value > 16 {
-				msg := "Additional must be 0-16"
-				log.Printf("%s, handleNew: %s\n", sn, msg)
-				http.Error(w, msg, http.StatusBadRequest)
-				return
-			}
-		}
-
-		// process checkbox values
-		if _, ok := r.Form["firstTime"]; ok {
-			tld.FirstTime = true
-		}
-		if _, ok := r.Form["firstSunrise"]; ok {
-			tld.FirstSunrise = true
-		}
-		if _, ok := r.Form["firstSunrise30"]; ok {
-			tld.FirstSunrise30 = true
-		}
-		if _, ok := r.Form["firstSunrise60"]; ok {
-			tld.FirstSunrise60 = true
-		}
-		if _, ok := r.Form["lastTime"]; ok {
-			tld.LastTime = true
-		}
-		if _, ok := r.Form["lastSunset"]; ok {
-			tld.LastSunset = true
-		}
-		if _, ok := r.Form["lastSunset30"]; ok {
-			tld.LastSunset30 = true
-		}
-		if _, ok := r.Form["lastSunset60"]; ok {
-			tld.LastSunset60 = true
-		}
-
-		if err := tld.SetFirstLastFlags(); err != nil {
-			log.Printf("%s, SetFirstLastFlags: %v\n", sn, err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := s.requireProbe(r.Context(), tld); err != nil {
+			data := formPage(tld, "")
+			data.Probe = &ProbeResult{URL: tld.URL, Error: err.Error()}
+			s.render(w, r, http.StatusUnprocessableEntity, data)
 			return
 		}
 
-		// if the FolderPath directory doesn't exist, create it
-		if err := os.MkdirAll(tld.FolderPath, 0664); err != nil { // octal for -rw-rw-r--: owner read/write, group/other read-only
-			log.Printf("%s, os.MkdirAll: %v\n", sn, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := s.addWebcam(tld); errors.Is(err, errWebcamExists) {
+			s.formInvalid(w, r, tld, "", r.Form, formErrors{"name": fmt.Sprintf("%s %q already exists", label("name"), tld.Name)})
 			return
-		}
-		// log.Printf("handleNew, TLDef: %+v", tld)
-
-		srv.mtld.Append(tld)
-		if err := srv.mtld.Write(); err != nil {
-			log.Printf("%s, srv.mtld.Write: %v\n", sn, err)
+		} else if err != nil {
+			log.Printf("%s, s.addWebcam: %v\n", sn, err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		go capture(srv.ctx, tld, srv.config.pollSecs)
-
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 
 		// log.Printf("%s.%s, duration %v\n", sn, mn, time.Now().Sub(startTime))
@@ -407,6 +582,106 @@ func (s *server) handleNew() httprouter.Handle {
 	}
 }
 
+// formTLDef decodes and validates the TLDef in a webform submission. The
+// TLDef is returned, as far as it could be decoded, with the errors found
+// in each field; an error is returned only if the submission is unreadable.
+func (s *server) formTLDef(r *http.Request) (*TLDef, formErrors, error) {
+	sn := "formTLDef"
+
+	tld, errs, err := decodeForm(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// validate the TLDef we just decoded
+	if err := s.validate.Struct(tld); err != nil {
+		log.Printf("%s, %v\n", sn, err)
+		errs.addValidation(err)
+	}
+	// validator package doesn't allow required numbers to be zero, so validate manually
+	if _, ok := r.Form["additional"]; !ok { // additional not present
+		errs.add("additional", "Additional is required")
+	} else if tld.Additional < 0 || tld.Additional > 16 {
+		errs.add("additional", "Additional must be 0-16")
+	}
+	if err := s.config.resolveFolder(tld); err != nil {
+		errs.add("folder", err.Error())
+	}
+
+	errs.addChoices(tld)
+	if len(errs) > 0 {
+		log.Printf("%s, %s invalid: %v\n", sn, tld.Name, errs)
+		return tld, errs, nil
+	}
+
+	if err := tld.SetFirstLastFlags(); err != nil {
+		log.Printf("%s, SetFirstLastFlags: %v\n", sn, err)
+		errs.add("", err.Error())
+	}
+
+	return tld, errs, nil
+}
+
+// decodeForm decodes the TLDef in a webform submission, without validating
+// it. Fields that cannot be decoded are reported in the formErrors.
+func decodeForm(r *http.Request) (*TLDef, formErrors, error) {
+	if err := r.ParseForm(); err != nil {
+		log.Printf("FromForm: r.ParseForm: %v *****ERROR*****\n", err)
+		return nil, nil, err
+	}
+	// dump r.Form contents
+	// log.Println("After r.ParseForm(), r.Form values:")
+	// for key, value := range r.Form {
+	// 	log.Printf("%q: %q\n", key, value)
+	// }
+
+	tld := newTLDef()
+	errs := formErrors{}
+
+	// process radio button values, each setting one of the First or Last booleans
+	for group, choices := range map[string]map[string]func(tld *TLDef) *bool{"first": firstChoices, "last": lastChoices} {
+		if value := r.Form.Get(group); value != "" {
+			if field, ok := choices[value]; ok {
+				*field(tld) = true
+			} else {
+				errs.add(group, fmt.Sprintf("%s %q is not a choice", label(group), value))
+			}
+		}
+	}
+
+	// process checkbox values
+	checkboxes := map[string]*bool{
+		"firstTime":      &tld.FirstTime,
+		"firstSunrise":   &tld.FirstSunrise,
+		"firstSunrise30": &tld.FirstSunrise30,
+		"firstSunrise60": &tld.FirstSunrise60,
+		"lastTime":       &tld.LastTime,
+		"lastSunset":     &tld.LastSunset,
+		"lastSunset30":   &tld.LastSunset30,
+		"lastSunset60":   &tld.LastSunset60,
+	}
+	decoder := formam.NewDecoder(nil)
+	for key, values := range r.Form {
+		if checked, ok := checkboxes[key]; ok {
+			*checked = true
+			continue
+		}
+		if key == "first" || key == "last" || key == csrfField || isPreviewField(key) { // not TLDef fields
+			continue
+		}
+		// decode field by field, so one bad value doesn't hide the others
+		if err := decoder.Decode(url.Values{key: values}, tld); err != nil {
+			if _, ok := formLabels[key]; ok {
+				errs.add(key, fmt.Sprintf("%s %q is not valid", label(key), r.Form.Get(key)))
+			} else {
+				errs.add("", fmt.Sprintf("unknown field %q", key))
+			}
+		}
+	}
+
+	return tld, errs, nil
+}
+
 // initTemplates reads and parses template files, and saves the template
 // in the server receiver
 func (s *server) initTemplates(dir string, ext string) {
@@ -433,32 +708,61 @@ func (s *server) initTemplates(dir string, ext string) {
 
 // Config holds application-wide configuration info
 type Config struct {
-	path     string // path to timelapse.json
-	pollSecs int    // polling interval = delay to handle Ctrl-C
-	port     string // TCP port to listen on
-	tzdbAPI  string // API key for TimeZoneDB.com
+	path         string   // path to timelapse.json
+	pollSecs     int      // polling interval = delay to handle Ctrl-C
+	port         string   // TCP port to listen on
+	tzdbAPI      string   // API key for TimeZoneDB.com
+	requireProbe bool     // require a successful probe of the webcam URL before saving a new definition
+	users        string   // path to the users file, htpasswd format with bcrypt hashes
+	tokens       string   // path to the API tokens file
+	roots        []string // storage roots webcam folders must be inside, none for anywhere
+	shutdownSecs int      // seconds to wait for requests and captures in flight when stopping
 }
 
 // Load populates Config with flag and environment variable values
 func (c *Config) Load() {
 
-	pflag.StringVar(&c.path, "path", "./", "path to folder containing timelapse.json")
-	pflag.IntVar(&c.pollSecs, "poll", 60, "seconds between time checks")
-	pflag.StringVar(&c.port, "port", "8099", "HTTP port to listen on")
-	pflag.StringVar(&c.tzdbAPI, "tzdb", "", "API key for TimeZoneDB.com")
+	c.addFlags(pflag.CommandLine)
 	var help bool
 	pflag.BoolVarP(&help, "help", "h", false, "show usage information")
 	pflag.Parse()
 
 	if help {
+		printUsage(os.Stdout)
 		pflag.PrintDefaults()
 		os.Exit(0)
 	}
 
-	viper.BindPFlag("path", pflag.Lookup("path"))
-	viper.BindPFlag("poll", pflag.Lookup("poll"))
-	viper.BindPFlag("port", pflag.Lookup("port"))
-	viper.BindPFlag("tzdb", pflag.Lookup("tzdb"))
+	c.bind(pflag.CommandLine)
+}
+
+// addFlags defines the configuration flags in fs, shared by the server and
+// the subcommands
+func (c *Config) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.path, "path", "./", "path to folder containing timelapse.json")
+	fs.IntVar(&c.pollSecs, "poll", 60, "seconds between time checks")
+	fs.StringVar(&c.port, "port", "8099", "HTTP port to listen on")
+	fs.StringVar(&c.tzdbAPI, "tzdb", "", "API key for TimeZoneDB.com")
+	fs.BoolVar(&c.requireProbe, "probe", false, "require a successful probe of the webcam URL before saving a new definition")
+	fs.StringVar(&c.users, "users", "", "path to users file (htpasswd format, bcrypt), enables sign in")
+	fs.StringVar(&c.tokens, "tokens", "", "path to API tokens file (name:sha256:role), enables sign in")
+	fs.String("roots", "", "storage roots webcam folders must be inside, separated like $PATH; the first holds folders derived from webcam names")
+	fs.IntVar(&c.shutdownSecs, "shutdown", 8, "seconds to wait for requests and captures in flight when stopping, within Docker's 10 second stop timeout")
+}
+
+// bind populates Config with the values of the flags parsed in fs, or of
+// the corresponding environment variables
+func (c *Config) bind(fs *pflag.FlagSet) {
+
+	viper.BindPFlag("path", fs.Lookup("path"))
+	viper.BindPFlag("poll", fs.Lookup("poll"))
+	viper.BindPFlag("port", fs.Lookup("port"))
+	viper.BindPFlag("tzdb", fs.Lookup("tzdb"))
+	viper.BindPFlag("probe", fs.Lookup("probe"))
+	viper.BindPFlag("users", fs.Lookup("users"))
+	viper.BindPFlag("tokens", fs.Lookup("tokens"))
+	viper.BindPFlag("roots", fs.Lookup("roots"))
+	viper.BindPFlag("shutdown", fs.Lookup("shutdown"))
 
 	viper.SetEnvPrefix("timelapse")
 	viper.AutomaticEnv()
@@ -466,11 +770,25 @@ func (c *Config) Load() {
 	viper.BindEnv("poll")
 	viper.BindEnv("port")
 	viper.BindEnv("tzdb")
+	viper.BindEnv("probe")
+	viper.BindEnv("users")
+	viper.BindEnv("tokens")
+	viper.BindEnv("roots")
+	viper.BindEnv("shutdown")
 
 	c.path = viper.GetString("path")
 	c.pollSecs = viper.GetInt("poll")
 	c.port = viper.GetString("port")
 	c.tzdbAPI = viper.GetString("tzdb_API")
+	c.requireProbe = viper.GetBool("probe")
+	c.users = viper.GetString("users")
+	c.tokens = viper.GetString("tokens")
+	c.shutdownSecs = viper.GetInt("shutdown")
+	roots, err := parseRoots(viper.GetString("roots"))
+	if err != nil {
+		log.Fatalf("Config.Load, roots: %v\n", err)
+	}
+	c.roots = roots
 
 	// log.Printf("Config: %+v\n", c)
 }
@@ -482,30 +800,40 @@ type CaptureTimes []time.Time
 
 // TLDef represents a Timelapse capture definition
 type TLDef struct {
-	Name           string         `json:"name" formam:"name" validate:"required"`                     // Friendly name of this timelapse definition
-	URL            string         `json:"webcamUrl" formam:"webcamUrl" validate:"url,required"`       // URL of webcam image
-	Latitude       float64        `json:"latitude" formam:"latitude" validate:"latitude,required"`    // Latitude of webcam
-	Longitude      float64        `json:"longitude" formam:"longitude" validate:"longitude,required"` // Longitude of webcam
-	FirstTime      bool           `json:"firstTime" formam:"firstTime"`                               // First capture at specific time
-	FirstSunrise   bool           `json:"firstSunrise" formam:"firstSunrise"`                         // First capture at Sunrise
-	FirstSunrise30 bool           `json:"firstSunrise30" formam:"firstSunrise30"`                     // ................ Sunrise +30 minutes
-	FirstSunrise60 bool           `json:"firstSunrise60" formam:"firstSunrise60"`                     // ................ Sunrise +60 minutes
-	LastTime       bool           `json:"lastTime" formam:"lastTime"`                                 // Last capture at specific time
-	LastSunset     bool           `json:"lastSunset" formam:"lastSunset"`                             // Last capture at Sunset
-	LastSunset30   bool           `json:"lastSunset30" formam:"lastSunset30"`                         // ................ Sunset -30 minutes
-	LastSunset60   bool           `json:"lastSunset60" formam:"lastSunset60"`                         // ................ Sunset -60 minutes
-	Additional     int            `json:"additional" formam:"additional"`                             // Additional captures per day (in addition to First and Last)
-	FolderPath     string         `json:"folder" formam:"folder" validate:"required"`                 // Folder path to store captures
-	FirstFlags     uint           `json:"-"`                                                          // bit set for First booleans
-	LastFlags      uint           `json:"-"`                                                          // bit set for Last booleans
-	WebcamTZ       string         `json:"-"`                                                          // timezone of the webcam (e.g., "America/Los_Angeles")
-	WebcamLoc      *time.Location `json:"-"`                                                          // time.Locaion of the webcam
-	SunriseUTC     time.Time      `json:"-"`                                                          // sunrise at webcam lat/long (UTC)
-	SolarNoonUTC   time.Time      `json:"-"`                                                          // solar noon at webcam lat/long (UTC)
-	SunsetUTC      time.Time      `json:"-"`                                                          // sunset at webcam lat/long (UTC)
-	CaptureTimes   CaptureTimes   `json:"-"`                                                          // Times (in time zone where the code is running) to capture images
-	NextCapture    int            `json:"-"`                                                          // index in CaptureTimes[] of next (future) capture time
-	Backoff        int64          `json:"-"`                                                          // delay image retrieval attempts when errors encountered
+	Name           string         `json:"name" formam:"name" validate:"required"`                                     // Friendly name of this timelapse definition
+	URL            string         `json:"webcamUrl" formam:"webcamUrl" validate:"url,required"`                       // URL of webcam image
+	Latitude       float64        `json:"latitude" formam:"latitude" validate:"latitude,required"`                    // Latitude of webcam
+	Longitude      float64        `json:"longitude" formam:"longitude" validate:"longitude,required"`                 // Longitude of webcam
+	FirstTime      bool           `json:"firstTime" formam:"firstTime"`                                               // First capture at specific time
+	FirstSunrise   bool           `json:"firstSunrise" formam:"firstSunrise"`                                         // First capture at Sunrise
+	FirstSunrise30 bool           `json:"firstSunrise30" formam:"firstSunrise30"`                                     // ................ Sunrise +30 minutes
+	FirstSunrise60 bool           `json:"firstSunrise60" formam:"firstSunrise60"`                                     // ................ Sunrise +60 minutes
+	LastTime       bool           `json:"lastTime" formam:"lastTime"`                                                 // Last capture at specific time
+	LastSunset     bool           `json:"lastSunset" formam:"lastSunset"`                                             // Last capture at Sunset
+	LastSunset30   bool           `json:"lastSunset30" formam:"lastSunset30"`                                         // ................ Sunset -30 minutes
+	LastSunset60   bool           `json:"lastSunset60" formam:"lastSunset60"`                                         // ................ Sunset -60 minutes
+	Additional     int            `json:"additional" formam:"additional"`                                             // Additional captures per day (in addition to First and Last)
+	FolderPath     string         `json:"folder" formam:"folder"`                                                     // Folder path to store captures, see Config.resolveFolder
+	Transforms     Pipeline       `json:"transforms,omitempty" formam:"-" validate:"dive"`                            // Transforms applied to captured images (optional)
+	KeepOriginal   bool           `json:"keepOriginal,omitempty" formam:"-"`                                          // Keep unprocessed captures in the "original" sub-folder
+	Caption        *CaptionDef    `json:"caption,omitempty" formam:"-"`                                               // Caption burned into captured images (optional)
+	Paused         bool           `json:"paused,omitempty" formam:"-"`                                                // Capturing paused, definition kept
+	CatchUp        *CatchUpDef    `json:"catchUp,omitempty" formam:"-"`                                               // Capture of slots missed while down or asleep (optional)
+	Fallback       *FallbackDef   `json:"fallback,omitempty" formam:"-"`                                              // Captures of polar and short days (optional)
+	FileTime       string         `json:"fileTime,omitempty" formam:"-" validate:"omitempty,oneof=server webcam utc"` // Time zone of file name time stamps, default "server"
+	FirstFlags     uint           `json:"-"`                                                                          // bit set for First booleans
+	LastFlags      uint           `json:"-"`                                                                          // bit set for Last booleans
+	WebcamTZ       string         `json:"-"`                                                                          // timezone of the webcam (e.g., "America/Los_Angeles")
+	WebcamLoc      *time.Location `json:"-"`                                                                          // time.Locaion of the webcam
+	SunriseUTC     time.Time      `json:"-"`                                                                          // sunrise at webcam lat/long (UTC)
+	SolarNoonUTC   time.Time      `json:"-"`                                                                          // solar noon at webcam lat/long (UTC)
+	SunsetUTC      time.Time      `json:"-"`                                                                          // sunset at webcam lat/long (UTC)
+	CaptureTimes   CaptureTimes   `json:"-"`                                                                          // Times (in the webcam's time zone) to capture images
+	NextCapture    int            `json:"-"`                                                                          // index in CaptureTimes[] of next (future) capture time
+	Backoff        int64          `json:"-"`                                                                          // delay image retrieval attempts when errors encountered
+	providers      *Providers     // clock, lookups and image source, nil for the server's, see env
+	fallback       string         // fallback policy setting CaptureTimes, "" if sunrise and sunset did
+	warning        string         // why CaptureTimes fall back, "" if they don't
 }
 
 // newTLDef initializes a TLDef structure
@@ -516,8 +844,28 @@ func newTLDef() *TLDef {
 	return &tld
 }
 
+// definition returns a copy of the saved settings of tld, without the
+// runtime state its capture go routine owns, for use while it's running.
+// The settings are guarded by server.mu.
+func (tld *TLDef) definition() *TLDef {
+	def := newTLDef()
+	def.Name, def.URL = tld.Name, tld.URL
+	def.Latitude, def.Longitude = tld.Latitude, tld.Longitude
+	def.FirstTime, def.FirstSunrise, def.FirstSunrise30, def.FirstSunrise60 = tld.FirstTime, tld.FirstSunrise, tld.FirstSunrise30, tld.FirstSunrise60
+	def.LastTime, def.LastSunset, def.LastSunset30, def.LastSunset60 = tld.LastTime, tld.LastSunset, tld.LastSunset30, tld.LastSunset60
+	def.FirstFlags, def.LastFlags = tld.FirstFlags, tld.LastFlags
+	def.Additional = tld.Additional
+	def.FolderPath = tld.FolderPath
+	def.Transforms, def.KeepOriginal, def.Caption = tld.Transforms, tld.KeepOriginal, tld.Caption
+	def.Paused, def.CatchUp, def.Fallback, def.FileTime = tld.Paused, tld.CatchUp, tld.Fallback, tld.FileTime
+	def.providers = tld.providers
+	return def
+}
+
 // SetCaptureTimes calculate all capture times for the specified date
-// and initializes NextCapture
+// and initializes NextCapture. Days the fallback policy skips are looked
+// past, with a noCapturesError once maxSkippedDays or maxSkipLookups is
+// reached. On error, CaptureTimes is left empty.
 func (tld *TLDef) SetCaptureTimes(date time.Time) error {
 	sn := "main.TLDef.SetCaptureTimes"
 	var err error
@@ -526,23 +874,68 @@ func (tld *TLDef) SetCaptureTimes(date time.Time) error {
 
 	lenCT := len(tld.CaptureTimes)
 	if lenCT > 0 {
-		if time.Now().Before(tld.CaptureTimes[lenCT-1]) {
+		if tld.env().Clock.Now().Before(tld.CaptureTimes[lenCT-1]) {
 			msg := fmt.Sprintf("%s %s not all CaptureTimes have passed, tld.CaptureTimes: %v", sn, tld.Name, tld.CaptureTimes)
 			panic(msg)
 		}
 		tld.CaptureTimes = []time.Time{} // all existing TLDef times have passed, start with an empty slice (preferred so json.Marshal() will emit "[]")
 	}
 
+	tld.NextCapture = 0
+
 	if err = tld.SetWebcamTZ(); err != nil { // establish timezone of webcam
 		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
 		return err
 	}
+	// the webcam's day at date, e.g., still yesterday in Hawaii after midnight in California
+	date = tld.webcamDate(date.In(tld.WebcamLoc))
 
-	if err = tld.GetSolarTimes(date); err != nil { // set sunrise, solar noon, and sunset for specified date
+	// a day skipped by the fallback policy has no captures, look ahead; as
+	// only the skip policy leaves days without captures, the days estimated
+	// polar are skipped without a lookup
+	for days, lookups := 1, 0; ; days++ {
+		if days == 1 || !estimatedPolar(tld.Latitude, date) {
+			if err = tld.SetDayCaptureTimes(date); err != nil {
+				break
+			}
+			if len(tld.CaptureTimes) > 0 {
+				return nil
+			}
+			lookups++
+		}
+		date = date.AddDate(0, 0, 1)
+		if days == maxSkippedDays || lookups == maxSkipLookups {
+			err = &noCapturesError{Name: tld.Name, Until: date}
+			tld.warning = err.Error()
+			log.Printf("%s, %v\n", sn, err)
+			break
+		}
+	}
+	tld.CaptureTimes = CaptureTimes{} // partial captures of a failed day are no schedule
+	return err
+}
+
+// SetDayCaptureTimes sets the (empty) CaptureTimes for the webcam's day
+// with the year, month and day of date, using the webcam timezone already
+// established. Days whose sunrise and
+// sunset don't give a first capture before the last are set by the Fallback
+// policy, possibly without captures.
+func (tld *TLDef) SetDayCaptureTimes(date time.Time) error {
+	sn := "main.TLDef.SetDayCaptureTimes"
+	date = tld.webcamDate(date)
+
+	if err := tld.GetSolarTimes(date); err != nil { // set sunrise, solar noon, and sunset for specified date
 		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
 		return err
 	}
 
+	tld.fallback, tld.warning = "", ""
+	if why := tld.irregularDay(); why != "" {
+		tld.setFallbackCaptureTimes(date, why)
+		sort.Sort(tld.CaptureTimes)
+		return nil
+	}
+
 	if err := tld.SetFirstCapture(); err != nil {
 		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
 		return err
@@ -574,12 +967,12 @@ func (tld *TLDef) SetFirstCapture() error {
 	mins60, _ = time.ParseDuration("60m")
 
 	switch {
-	case (firstSunrise & tld.FirstFlags) != 0: // add local time of sunrise (where this code is running)
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(srv.localLoc))
+	case (firstSunrise & tld.FirstFlags) != 0: // add local time of sunrise (at the webcam)
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(tld.webcamLoc()))
 	case (firstSunrise30 & tld.FirstFlags) != 0: // add local time of sunrise + 30 minutes
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(srv.localLoc).Add(mins30))
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(tld.webcamLoc()).Add(mins30))
 	case (firstSunrise60 & tld.FirstFlags) != 0: // add local time of sunrise + 60 minutes
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(srv.localLoc).Add(mins60))
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunriseUTC.In(tld.webcamLoc()).Add(mins60))
 	}
 
 	// log.Printf("%s, %s CaptureTimes (len %d): %+v\n",
@@ -650,9 +1043,19 @@ func (tld *TLDef) SetAdditional() error {
 	}
 
 	// both First and Last captures now in CaptureTimes
-	first := tld.CaptureTimes[0]
-	last := tld.CaptureTimes[1]
+	if len(tld.CaptureTimes) != 2 {
+		return fmt.Errorf("%s, first and last capture times required, got %d", sn, len(tld.CaptureTimes))
+	}
+	tld.addCaptureTimes(tld.CaptureTimes[0], tld.CaptureTimes[1])
+
+	// log.Printf("%s, %s SetAdditional %d, CaptureTimes (len %d): %+v\n",
+	// 	sn, tld.Name, tld.Additional, len(tld.CaptureTimes), tld.CaptureTimes)
+	return nil
+}
 
+// addCaptureTimes sets CaptureTimes to first, the Additional capture times
+// and last
+func (tld *TLDef) addCaptureTimes(first, last time.Time) {
 	tld.CaptureTimes = *new([]time.Time) // create a new slice with just the first capture time
 	tld.CaptureTimes = append(tld.CaptureTimes, first)
 
@@ -663,25 +1066,19 @@ func (tld *TLDef) SetAdditional() error {
 	case tld.Additional == 1:
 		// TODO: handle when LastTime capture occurs before solar noon
 		// add local time corresponding to solar noon as the additional capture time
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SolarNoonUTC.In(srv.localLoc))
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SolarNoonUTC.In(tld.webcamLoc()))
 
 	case tld.Additional%2 == 0:
 		tld.SplitTime(first, last, tld.Additional)
 
 	case tld.Additional%2 == 1:
-		n := (tld.Additional - 1) / 2                                                  // one of the added capture times will be solar noon
-		tld.SplitTime(first, tld.SolarNoonUTC.In(srv.localLoc), n)                     // add the first half the additional capture times
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SolarNoonUTC.In(srv.localLoc)) // add solar noon
-		tld.SplitTime(tld.SolarNoonUTC.In(srv.localLoc), last, n)                      // add the second half
+		n := (tld.Additional - 1) / 2                                                     // one of the added capture times will be solar noon
+		tld.SplitTime(first, tld.SolarNoonUTC.In(tld.webcamLoc()), n)                     // add the first half the additional capture times
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SolarNoonUTC.In(tld.webcamLoc())) // add solar noon
+		tld.SplitTime(tld.SolarNoonUTC.In(tld.webcamLoc()), last, n)                      // add the second half
 	}
 
 	tld.CaptureTimes = append(tld.CaptureTimes, last) // add the last capture time to the new slice
-
-	// log.Printf("%s, %s IsSorted %t\n", sn, tld.Name, sort.IsSorted(tld.CaptureTimes))
-
-	// log.Printf("%s, %s SetAdditional %d, CaptureTimes (len %d): %+v\n",
-	// 	sn, tld.Name, tld.Additional, len(tld.CaptureTimes), tld.CaptureTimes)
-	return nil
 }
 
 // implement sort.Interface on CaptureTime
@@ -727,12 +1124,12 @@ func (tld *TLDef) SetLastCapture() error {
 	mins60, _ = time.ParseDuration("60m")
 
 	switch {
-	case (lastSunset & tld.LastFlags) != 0: // add local time of sunset (where this code is running)
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(srv.localLoc))
+	case (lastSunset & tld.LastFlags) != 0: // add local time of sunset (at the webcam)
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(tld.webcamLoc()))
 	case (lastSunset30 & tld.LastFlags) != 0: // "add" -30 minutes to local time of sunset
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(srv.localLoc).Add(-mins30))
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(tld.webcamLoc()).Add(-mins30))
 	case (lastSunset60 & tld.LastFlags) != 0: // "add" -60 minutes to local time of sunset
-		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(srv.localLoc).Add(-mins60))
+		tld.CaptureTimes = append(tld.CaptureTimes, tld.SunsetUTC.In(tld.webcamLoc()).Add(-mins60))
 	}
 
 	// log.Printf("%s, %s CaptureTimes (len %d): %+v\n",
@@ -742,9 +1139,12 @@ func (tld *TLDef) SetLastCapture() error {
 
 // UpdateNextCapture adjusts NextCapture to reference the element with the
 // next CaptureTime (first element with time > baseTime), or if none are left
-// (today's captures have all been performed), updates CaptureTimes with
-// tomorrow's capture times
-func (tld *TLDef) UpdateNextCapture(baseTime time.Time) {
+// (the day's captures have all been performed), updates CaptureTimes with
+// those of the webcam's next day: the day after the schedule's, or the
+// webcam's day at baseTime if that is later, e.g., after a clock jump. If
+// those can't be set, CaptureTimes is left empty and the error of
+// SetCaptureTimes returned.
+func (tld *TLDef) UpdateNextCapture(baseTime time.Time) error {
 	sn := "UpdateNextCapture"
 
 	// log.Printf("%s, %s NextCapture: baseTime %v, IsSorted %t, NextCapture %d, CaptureTimes (len %d): %v\n",
@@ -756,21 +1156,25 @@ func (tld *TLDef) UpdateNextCapture(baseTime time.Time) {
 		// 	sn, tld.Name, sort.IsSorted(tld.CaptureTimes), len(tld.CaptureTimes), tld.CaptureTimes)
 	}
 
-	tld.NextCapture = 0
-	now := baseTime.In(srv.localLoc)
-	for _, t := range tld.CaptureTimes {
-		if t.After(now) {
-			break
-		}
-		tld.NextCapture++
+	now := baseTime.In(tld.webcamLoc())
+	next := func() int { // index of the first capture time after now
+		return sort.Search(len(tld.CaptureTimes), func(i int) bool { return tld.CaptureTimes[i].After(now) })
 	}
+	tld.NextCapture = next()
 
 	msg := ""
-	if tld.NextCapture >= len(tld.CaptureTimes) {
-		tomorrow := baseTime.AddDate(0, 0, 1)
-		tld.SetCaptureTimes(tomorrow) // setup tomorrow's capture times
-		tld.NextCapture = 0           // tomorrow's first time is next
-		msg = "CaptureTimes set for tomorrow;"
+	for tld.NextCapture >= len(tld.CaptureTimes) {
+		day := tld.webcamDate(now) // the webcam's, not where this code is running
+		if n := len(tld.CaptureTimes); n > 0 {
+			if after := tld.webcamDate(tld.CaptureTimes[n-1].In(tld.webcamLoc())).AddDate(0, 0, 1); after.After(day) {
+				day = after
+			}
+		}
+		if err := tld.SetCaptureTimes(day); err != nil { // setup the next day's capture times
+			return err
+		}
+		tld.NextCapture = next() // those of the webcam's today may have passed
+		msg = fmt.Sprintf("CaptureTimes set for %s;", day.Format(dateLayout))
 	}
 
 	// log.Printf("%s, %s %s NextCapture: %d, CaptureTimes (len %d): %v\n",
@@ -779,19 +1183,77 @@ func (tld *TLDef) UpdateNextCapture(baseTime time.Time) {
 		log.Printf("%s, %s %s NextCapture: %d, CaptureTimes (len %d): %v\n",
 			sn, tld.Name, msg, tld.NextCapture, len(tld.CaptureTimes), tld.CaptureTimes)
 	}
+	return nil
 }
 
-// NextCaptureTime returns the time of the next capture
+// scheduled reports whether there is a next capture, i.e., CaptureTimes
+// could be set
+func (tld TLDef) scheduled() bool {
+	return tld.NextCapture < len(tld.CaptureTimes)
+}
+
+// NextCaptureTime returns the time of the next capture, the zero time
+// if there is none
 func (tld TLDef) NextCaptureTime() time.Time {
+	if !tld.scheduled() {
+		return time.Time{}
+	}
 	next := tld.CaptureTimes[tld.NextCapture]
 	return next
 }
 
+// SlotLabel describes the CaptureTimes element at index i, e.g.,
+// "sunrise +30", "solar noon" or "additional 2", or "manual" for
+// manualSlot
+func (tld TLDef) SlotLabel(i int) string {
+	switch {
+	case i == manualSlot:
+		return "manual"
+	case tld.fallback == fallbackNoon:
+		return "solar noon"
+	case i == 0:
+		return tld.firstLabel()
+	case i == len(tld.CaptureTimes)-1:
+		return tld.lastLabel()
+	case i > 0 && i < len(tld.CaptureTimes) && tld.CaptureTimes[i].Equal(tld.SolarNoonUTC):
+		return "solar noon"
+	}
+	return fmt.Sprintf("additional %d", i)
+}
+
+// firstLabel describes the first capture, "first" for a fixed time
+func (tld TLDef) firstLabel() string {
+	switch {
+	case tld.fallback == fallbackFixed:
+	case (firstSunrise & tld.FirstFlags) != 0:
+		return "sunrise"
+	case (firstSunrise30 & tld.FirstFlags) != 0:
+		return "sunrise +30"
+	case (firstSunrise60 & tld.FirstFlags) != 0:
+		return "sunrise +60"
+	}
+	return "first"
+}
+
+// lastLabel describes the last capture, "last" for a fixed time
+func (tld TLDef) lastLabel() string {
+	switch {
+	case tld.fallback == fallbackFixed:
+	case (lastSunset & tld.LastFlags) != 0:
+		return "sunset"
+	case (lastSunset30 & tld.LastFlags) != 0:
+		return "sunset -30"
+	case (lastSunset60 & tld.LastFlags) != 0:
+		return "sunset -60"
+	}
+	return "last"
+}
+
 // const Margin = 100 * time.Millisecond
 
 // IsTimeForCapture determines if it's time to capture an image
 func (tld TLDef) IsTimeForCapture() bool {
-	b := time.Now().After(tld.NextCaptureTime())
+	b := tld.scheduled() && tld.env().Clock.Now().After(tld.NextCaptureTime())
 	return b
 }
 
@@ -817,9 +1279,9 @@ func newMasterTLDefs() *masterTLDefs {
 	return mtld
 }
 
-// Read reads the master timelapse definitions file into the masterTLDefs
-// slice of its receiver
-func (mtld masterTLDefs) Read(path string) error {
+// Read reads the master timelapse definitions file at path into the
+// masterTLDefs slice of its receiver, checking each with validate
+func (mtld *masterTLDefs) Read(path string, validate *validator.Validate) error {
 	sn := "mtld.Read"
 
 	data, err := ioutil.ReadFile(path)
@@ -832,16 +1294,16 @@ func (mtld masterTLDefs) Read(path string) error {
 	}
 	// log.Printf("mtld.Read, contents of %s: %q\n", masterFile, data)
 
-	err = json.Unmarshal(data, srv.mtld)
+	err = json.Unmarshal(data, mtld)
 	if err != nil {
 		log.Printf("%s, json.Unmarshal: %v\n", sn, err)
 		return err
 	}
 
 	// validate the TLDefs we just read
-	mtldSlice := *srv.mtld
+	mtldSlice := *mtld
 	for i, tld := range mtldSlice { // validate the TLDef structs within mtld
-		if err := srv.validate.Struct(tld); err != nil {
+		if err := validate.Struct(tld); err != nil {
 			log.Printf("%s, validate.Struct, element %d (%s): %v\n", sn, i, tld.Name, err)
 			return err
 		}
@@ -851,14 +1313,24 @@ func (mtld masterTLDefs) Read(path string) error {
 			log.Printf("%s, %s: SetFirstLastFlags: %v\n", sn, tld.Name, err)
 			return err
 		}
+
+		if err := tld.Transforms.Check(); err != nil {
+			log.Printf("%s, %s: Transforms.Check: %v\n", sn, tld.Name, err)
+			return err
+		}
+		if err := tld.Fallback.Check(); err != nil {
+			log.Printf("%s, %s: Fallback.Check: %v\n", sn, tld.Name, err)
+			return err
+		}
 		// log.Printf("%s, after SetFirstLastFlags, mtld element %d: (%p) %+v\n", sn, i, &tld, tld)
 	}
 
 	return nil
 }
 
-// Write writes the masterTLDefs to the master timelapse definitions file
-func (mtld masterTLDefs) Write() error {
+// Write writes the masterTLDefs to the master timelapse definitions file at
+// path, checking each with validate first
+func (mtld masterTLDefs) Write(path string, validate *validator.Validate) error {
 	sn := "mtld.Write"
 
 	var buf []byte
@@ -867,7 +1339,7 @@ func (mtld masterTLDefs) Write() error {
 	// validate the TLDefs in mtld before writing them
 	mtldSlice := mtld
 	for i, tld := range mtldSlice { // validate the TLDef structs within mtld
-		if err := srv.validate.Struct(tld); err != nil {
+		if err := validate.Struct(tld); err != nil {
 			log.Printf("%s, validate.Struct, element %d (%s): %v\n", sn, i, tld.Name, err)
 			return err
 		}
@@ -878,13 +1350,12 @@ func (mtld masterTLDefs) Write() error {
 		return err
 	}
 
-	filename := filepath.Join(masterPath, masterFile)
-	if err = ioutil.WriteFile(filename, buf, 0644); err != nil { // -rw-r--r--
+	if err = ioutil.WriteFile(path, buf, 0644); err != nil { // -rw-r--r--
 		log.Printf("%s, ioutil.WriteFile: %v\n", sn, err)
 		return err
 	}
 
-	log.Printf("mtld.Write, %s", filename)
+	log.Printf("mtld.Write, %s", path)
 	return nil
 }
 
@@ -896,6 +1367,30 @@ func (mtld *masterTLDefs) Append(newTLD *TLDef) error {
 	return nil
 }
 
+// Remove removes the timelapse definition with the specified name from
+// the masterTLDefs slice, returning it, or nil if not found
+func (mtld *masterTLDefs) Remove(name string) *TLDef {
+	for i, ptld := range *mtld {
+		if ptld.Name == name {
+			*mtld = append((*mtld)[:i:i], (*mtld)[i+1:]...)
+			return ptld
+		}
+	}
+	return nil
+}
+
+// Replace replaces the timelapse definition with the specified name,
+// returning the definition replaced, or nil if not found
+func (mtld masterTLDefs) Replace(name string, newTLD *TLDef) *TLDef {
+	for i, ptld := range mtld {
+		if ptld.Name == name {
+			mtld[i] = newTLD
+			return ptld
+		}
+	}
+	return nil
+}
+
 // Delete timelapse definition(s) with Name matching prefix from
 // the masterTLDefs slice. Primarily used to cleanup after testing.
 func (mtld *masterTLDefs) Delete(prefix string) *masterTLDefs {
@@ -934,69 +1429,18 @@ type SSDayInfo struct { // all times are UTC
 // GetSolarTimes uses the specified date and the TLDef's latitude/longitude
 // to establish sunrise, solar noon, and sunset times (UTC) and store
 // them in the TLDef
-func (tld *TLDef) GetSolarTimes(date time.Time) error {
+func (tld *TLDef) GetSolarTimes(date time.Time) (err error) {
 	sn := "main.tld.GetSolarTimes"
+	start := time.Now()
+	defer func() { tld.env().lookupDone(lookupSolar, start, err) }()
 	// log.Printf("%s, %s date: %v\n", sn, tld.Name, date)
 
-	ssdi := NewSSDayInfo(tld)
-	ssdi.Date = date
-
-	query := ssdi.buildQuery()
-	method := "GET"
-	req, err := http.NewRequest(method, query, nil)
-	if err != nil {
-		log.Printf("%s, %s http.NewRequest: %v\n", sn, tld.Name, err)
-		return err
-	}
-
-	// log.Printf("%s, %s %s %s\n", sn, tld.Name, method, query)
-	client := &http.Client{Timeout: time.Second * 2}
-	resp, err := client.Do(req)
+	st, err := tld.env().Solar.SolarTimes(tld.Latitude, tld.Longitude, date)
 	if err != nil {
-		log.Printf("%s, %s http.Client.Do: %v", sn, tld.Name, err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("%s, %s ioutil.ReadAll: %v", sn, tld.Name, err)
-		return err
-	}
-	// log.Printf("%s, %s resp.Body: %s", sn, tld.Name, body)
-
-	// strip off outer structure
-	wrapperStart := []byte(`{"results":`)
-	wrapperEnd := []byte(`,"status":"OK"}`)
-	if bytes.Index(body, wrapperStart) >= 0 {
-		tmpBody := bytes.TrimPrefix(body, wrapperStart)
-		body = bytes.TrimSuffix(tmpBody, wrapperEnd)
-	}
-	// log.Printf("%s, %s trimmed body: %s\n", sn, tld.Name, body)
-
-	// change each time's "+00:00" suffix to "Z" to clean up time.Parse result
-	body = bytes.ReplaceAll(body, []byte(`+00:00"`), []byte(`Z"`))
-	// log.Printf("%s, %s trimmed and Z-adjusted body: %s\n", sn, tld.Name, body)
-
-	if err := json.Unmarshal(body, &ssdi); err != nil { // unmarshall all provided fields
-		log.Printf("%s, %s json.Unmarshal: %v", sn, tld.Name, err)
-		return err
-	}
-
-	if tld.SunriseUTC, err = time.Parse(timeLayout, ssdi.SSDISunrise); err != nil {
-		log.Printf("%s, %s time.Parse(%s): %v", sn, tld.Name, ssdi.SSDISunrise, err)
-		return err
-	}
-
-	if tld.SolarNoonUTC, err = time.Parse(timeLayout, ssdi.SSDISolarNoon); err != nil {
-		log.Printf("%s, %s time.Parse(%s): %v", sn, tld.Name, ssdi.SSDISolarNoon, err)
-		return err
-	}
-
-	if tld.SunsetUTC, err = time.Parse(timeLayout, ssdi.SSDISunset); err != nil {
-		log.Printf("%s, %s time.Parse(%s): %v", sn, tld.Name, ssdi.SSDISunset, err)
+		log.Printf("%s, %s: %v", sn, tld.Name, err)
 		return err
 	}
+	tld.SunriseUTC, tld.SolarNoonUTC, tld.SunsetUTC = st.Sunrise, st.SolarNoon, st.Sunset
 
 	// log.Printf("%s, %s SunriseUTC: %v, SolarNoonUTC: %v, SunsetUTC: %v\n", sn, tld.Name, tld.SunriseUTC, tld.SolarNoonUTC, tld.SunsetUTC)
 	return nil
@@ -1013,13 +1457,14 @@ func NewSSDayInfo(tld *TLDef) *SSDayInfo {
 }
 
 // buildQuery returns the query string for sunrise-sunset.org API requests
-func (ssdi SSDayInfo) buildQuery() string {
+// to baseURL
+func (ssdi SSDayInfo) buildQuery(baseURL string) string {
 	// sn := "main.SSDayInfo.buildQuery"
 
 	queryParams := url.Values{}
 
-	queryParams.Add("lat", fmt.Sprintf("%.7f", ssdi.linkTLDef.Latitude))
-	queryParams.Add("lng", fmt.Sprintf("%.7f", ssdi.linkTLDef.Longitude))
+	queryParams.Add("lat", fmt.Sprintf("%.7f", ssdi.Latitude))
+	queryParams.Add("lng", fmt.Sprintf("%.7f", ssdi.Longitude))
 
 	year, month, day := ssdi.Date.Date()
 	date := fmt.Sprintf("%4d-%02d-%02d", year, month, day)
@@ -1027,10 +1472,10 @@ func (ssdi SSDayInfo) buildQuery() string {
 
 	queryParams.Add("formatted", "0") // ISO 8601, e.g., "2015-05-21T05:05:35+00:00"
 
-	query := "https://api.sunrise-sunset.org/json?"
+	query := baseURL + "?"
 	query += queryParams.Encode()
 
-	// log.Printf("%s, %s query: %q\n", sn, ssdi.linkTLDef.Name, query)
+	// log.Printf("%s, query: %q\n", sn, query)
 	return query
 }
 
@@ -1064,55 +1509,18 @@ func NewTimeZoneDB(tld *TLDef) *TimeZoneDB {
 // SetWebcamTZ determines and stores the timezone of the webcam
 // based on TLDef's latitude/longitude. It is called daily when
 // capture times for the day are set, to accomodate DST changes.
-func (tld *TLDef) SetWebcamTZ() error {
+func (tld *TLDef) SetWebcamTZ() (err error) {
 	sn := "main.tld.SetWebcamTZ"
+	start := time.Now()
+	defer func() { tld.env().lookupDone(lookupTimeZone, start, err) }()
 
-	var err error
-	var req *http.Request
-
-	tzdb := NewTimeZoneDB(tld)
-
-	query := tzdb.buildQuery(tld)
-	method := "GET"
-	req, err = http.NewRequest(method, query, nil)
+	zone, err := tld.env().TimeZones.TimeZone(tld.Latitude, tld.Longitude)
 	if err != nil {
-		log.Printf("%s, %s http.NewRequest: %v\n", sn, tld.Name, err)
-		return err
-	}
-
-	// log.Printf("%s, %s %s %s\n", sn, tld.Name, method, query)
-	var resp *http.Response
-	for {
-		client := &http.Client{Timeout: time.Second * 2}
-		resp, err = client.Do(req)
-		if err == nil && resp.StatusCode == http.StatusOK {
-			break
-		}
-		if err != nil {
-			log.Printf("%s, %s http.Client.Do: %v", sn, tld.Name, err)
-			return err
-		}
-		if resp.StatusCode == http.StatusTooManyRequests { // rate limited to 1 request/second
-			log.Printf("%s, %s received http.StatusTooMany (429), sleeping 2 seconds...\n", sn, tld.Name)
-			time.Sleep(2 * time.Second)
-		}
-	}
-	defer resp.Body.Close()
-
-	var body []byte
-	body, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("%s, %s ioutil.ReadAll: %v", sn, tld.Name, err)
-		return err
-	}
-	// log.Printf("%s, %s resp.Body: %s", sn, tld.Name, body)
-
-	if err = json.Unmarshal(body, tzdb); err != nil { // unmarshall all provided fields
-		log.Printf("%s, %s json.Unmarshal: %v", sn, tld.Name, err)
+		log.Printf("%s, %s: %v", sn, tld.Name, err)
 		return err
 	}
 
-	tld.WebcamTZ = tzdb.ZoneName
+	tld.WebcamTZ = zone
 	if tld.WebcamLoc, err = time.LoadLocation(tld.WebcamTZ); err != nil {
 		log.Printf("%s, %s time.LoadLocation(%s): %v", sn, tld.Name, tld.WebcamTZ, err)
 		return err
@@ -1122,21 +1530,22 @@ func (tld *TLDef) SetWebcamTZ() error {
 	return nil
 }
 
-// buildQuery builds the query string for TimeZoneDB.com API requests
-func (tzdb *TimeZoneDB) buildQuery(tld *TLDef) string {
+// buildQuery builds the query string for TimeZoneDB.com API requests to
+// baseURL, with API key key
+func (tzdb *TimeZoneDB) buildQuery(baseURL, key string, lat, lng float64) string {
 	// sn := "main.webcamTZ.buildQuery"
 
 	queryParams := url.Values{}
 
-	queryParams.Add("key", srv.config.tzdbAPI)
+	queryParams.Add("key", key)
 	queryParams.Add("format", "json")
 	queryParams.Add("by", "position")
-	queryParams.Add("lat", fmt.Sprintf("%.7f", tld.Latitude))
-	queryParams.Add("lng", fmt.Sprintf("%.7f", tld.Longitude))
+	queryParams.Add("lat", fmt.Sprintf("%.7f", lat))
+	queryParams.Add("lng", fmt.Sprintf("%.7f", lng))
 
-	query := "http://api.timezonedb.com/v2.1/get-time-zone?"
+	query := baseURL + "?"
 	query += queryParams.Encode()
 
-	// log.Printf("%s, %s query: %q\n", sn, tzdb.linkTLDef.Name, query)
+	// log.Printf("%s, query: %q\n", sn, query)
 	return query
 }
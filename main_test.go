@@ -3,7 +3,9 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -29,7 +31,7 @@ func newBaseTLD() TLDef {
 		FirstFlags:   firstSunrise,
 		LastFlags:    lastSunset,
 		Additional:   1,
-		FolderPath:   "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+		FolderPath:   testFolder,
 		CaptureTimes: CaptureTimes{sunrise, solarNoon, sunset},
 		NextCapture:  0,
 	}
@@ -43,22 +45,51 @@ var sunset = time.Date(2020, 5, 27, 20, 27, 15, 0, loc)   // Sunset
 var mins30 time.Duration
 var mins60 time.Duration
 
+var testFolder string // temporary folder of the webcams of the tests
+
+// useTestMaster points the configured path at a temporary copy of the
+// definitions in the repository, so the tests never touch the real ones,
+// and creates testFolder. It returns a function removing both.
+func useTestMaster() func() {
+	sn := "useTestMaster"
+
+	dir, err := ioutil.TempDir("", "timelapse-master")
+	if err != nil {
+		log.Fatalf("%s, ioutil.TempDir: %v\n", sn, err)
+	}
+	data, err := ioutil.ReadFile(masterFile)
+	if err != nil {
+		log.Fatalf("%s, ioutil.ReadFile: %v\n", sn, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, masterFile), data, 0644); err != nil {
+		log.Fatalf("%s, ioutil.WriteFile: %v\n", sn, err)
+	}
+	os.Setenv("TIMELAPSE_PATH", dir) // read by newServer
+
+	testFolder = filepath.Join(dir, "zzTest")
+	if err := os.Mkdir(testFolder, 0755); err != nil {
+		log.Fatalf("%s, os.Mkdir: %v\n", sn, err)
+	}
+	return func() { os.RemoveAll(dir) }
+}
+
 func TestMain(m *testing.M) {
 	var err error
 	sn := "TestMain"
 
 	// funcframework.RegisterHTTPFunction("/", capture.WebcamImage)
 
+	cleanup := useTestMaster()
+
 	srv = newServer()
 	srv.ctx, srv.cancel = context.WithCancel(context.Background())
+	srv.providers = newFakeProviders(systemClock{}, &httpImages{}) // no solar or time zone lookups on the network
 
 	srv.initTemplates("./templates", ".html")
-	srv.router.ServeFiles("/static/*filepath", http.Dir("static"))
-	srv.router.POST("/new", srv.handleNew())
-	srv.router.GET("/", srv.handleHome())
+	srv.routes()
 
-	if err = srv.mtld.Read(filepath.Join(masterPath, masterFile)); err != nil {
-		msg := fmt.Sprintf("%s, srv.mtld.Read: %v", sn, err)
+	if err = srv.loadDefinitions(); err != nil {
+		msg := fmt.Sprintf("%s, srv.loadDefinitions: %v", sn, err)
 		panic(msg)
 	}
 
@@ -80,32 +111,39 @@ func TestMain(m *testing.M) {
 	// }
 
 	exitcode := m.Run()
-
-	newMTLD := srv.mtld.Delete("test")
-	srv.mtld = newMTLD
-
-	if err := srv.mtld.Write(); err != nil {
-		log.Printf("%s, %v", sn, err)
-	}
+	cleanup()
 	os.Exit(exitcode)
 }
 
 func TestTLDef_CaptureImage(t *testing.T) {
-	t.Skip()
-	// tests := []struct {
-	// 	name string
-	// 	tld  TLDef
-	// 	want time.Time
-	// }{
-	// 	// TODO: Add test cases.
-	// }
-	// for _, tt := range tests {
-	// 	t.Run(tt.name, func(t *testing.T) {
-	// 		if got := tt.tld.CaptureImage(); got != tt.want {
-	// 			t.Errorf("TLDef.CaptureImage() got %v, want %v", got, tt.want)
-	// 		}
-	// 	})
-	// }
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.CaptureTimes = CaptureTimes{sunrise}
+
+	ts := newWebcamServer()
+	defer ts.Close()
+	tld.URL = ts.URL + "/webcam.png"
+	path, size, err := tld.CaptureImage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() != size || fi.Mode().Perm() != 0644 || path != tld.TargetFileName() {
+		t.Errorf("CaptureImage() got %s %d, %v", path, size, err)
+	}
+	os.Remove(path)
+
+	stalling := newStallingServer()
+	defer stalling.Close()
+	tld.URL = stalling.URL
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := tld.CaptureImage(ctx); err == nil {
+		t.Errorf("CaptureImage() interrupted got no error")
+	}
+	if names := folderEntries(t, tld.FolderPath); len(names) != 0 {
+		t.Errorf("CaptureImage() interrupted left %v", names)
+	}
 }
 
 func TestTLDef_TargetFileName(t *testing.T) {
@@ -210,11 +248,25 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusSeeOther,
 			substring:  []byte(""),
 		},
+		{name: "duplicate name",
+			params: map[string]string{
+				"name":         "test1",
+				"webcamUrl":    "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg?1589316288166",
+				"latitude":     "40.437787",
+				"longitude":    "-121.5360307",
+				"firstSunrise": "",
+				"lastSunset":   "",
+				"additional":   "0",
+				"folder":       testFolder,
+			},
+			wantStatus: http.StatusBadRequest,
+			substring:  []byte("already exists"),
+		},
 		{name: "missing name",
 			params: map[string]string{
 				// "name":         "test1",
@@ -224,7 +276,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -238,7 +290,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -252,7 +304,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -266,7 +318,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -280,7 +332,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				// "additional":   "0",
-				"folder": "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder": testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -294,7 +346,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "-1",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -308,7 +360,7 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "17",
-				"folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":       testFolder,
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
@@ -322,35 +374,35 @@ func Test_server_handleNew(t *testing.T) {
 				"firstSunrise": "",
 				"lastSunset":   "",
 				"additional":   "0",
-				// "folder":       "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				// "folder":       "zzTest",
 			},
 			wantStatus: http.StatusBadRequest,
 			substring:  []byte(""),
 		},
 		{name: "sunrise30, sunset30",
 			params: map[string]string{
-				"name":           "test1",
+				"name":           "test2",
 				"webcamUrl":      "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg?1589316288166",
 				"latitude":       "40.437787",
 				"longitude":      "-121.5360307",
 				"firstSunrise30": "",
 				"lastSunset30":   "",
 				"additional":     "0",
-				"folder":         "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":         testFolder,
 			},
 			wantStatus: http.StatusSeeOther,
 			substring:  []byte(""),
 		},
 		{name: "sunrise60, sunset60",
 			params: map[string]string{
-				"name":           "test1",
+				"name":           "test3",
 				"webcamUrl":      "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg?1589316288166",
 				"latitude":       "40.437787",
 				"longitude":      "-121.5360307",
 				"firstSunrise60": "",
 				"lastSunset60":   "",
 				"additional":     "0",
-				"folder":         "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+				"folder":         testFolder,
 			},
 			wantStatus: http.StatusSeeOther,
 			substring:  []byte(""),
@@ -450,21 +502,38 @@ func TestNewTLDef(t *testing.T) {
 
 func TestTLDef_SetCaptureTimes(t *testing.T) {
 	// layout := "Jan 2 2006 15:04:05 -0700 MST"
-	loc := time.Local
+	loc, err := time.LoadLocation("America/Los_Angeles") // the webcam's, see newFakeProviders
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	// solar times of sunrise-sunset.org, UTC
 	day1 := time.Date(2020, 5, 27, 0, 0, 0, 0, loc)
-	day1Capture := CaptureTimes{
-		time.Date(2020, 5, 27, 5, 39, 41, 0, loc),  // Sunrise
-		time.Date(2020, 5, 27, 13, 3, 28, 0, loc),  // SolarNoon
-		time.Date(2020, 5, 27, 20, 27, 15, 0, loc), // Sunset
+	day1Solar := SolarTimes{
+		Sunrise:   time.Date(2020, 5, 27, 12, 39, 41, 0, time.UTC),
+		SolarNoon: time.Date(2020, 5, 27, 20, 3, 28, 0, time.UTC),
+		Sunset:    time.Date(2020, 5, 28, 3, 27, 15, 0, time.UTC),
 	}
+	day1Capture := CaptureTimes{day1Solar.Sunrise.In(loc), day1Solar.SolarNoon.In(loc), day1Solar.Sunset.In(loc)}
 
 	day2 := time.Date(2020, 5, 28, 0, 0, 0, 0, loc)
-	day2Capture := CaptureTimes{
-		time.Date(2020, 5, 28, 5, 39, 9, 0, loc),  // Sunrise
-		time.Date(2020, 5, 28, 13, 3, 36, 0, loc), // SolarNoon
-		time.Date(2020, 5, 28, 20, 28, 2, 0, loc), // Sunset
-	}
+	day2Solar := SolarTimes{
+		Sunrise:   time.Date(2020, 5, 28, 12, 39, 9, 0, time.UTC),
+		SolarNoon: time.Date(2020, 5, 28, 20, 3, 36, 0, time.UTC),
+		Sunset:    time.Date(2020, 5, 29, 3, 28, 2, 0, time.UTC),
+	}
+	day2Capture := CaptureTimes{day2Solar.Sunrise.In(loc), day2Solar.SolarNoon.In(loc), day2Solar.Sunset.In(loc)}
+
+	providers := newFakeProviders(newFakeClock(day2.AddDate(0, 0, 2)), &fakeImages{})
+	providers.Solar = fakeSolar(func(date time.Time) (SolarTimes, error) {
+		switch date.Format(dateLayout) {
+		case "2020-05-27":
+			return day1Solar, nil
+		case "2020-05-28":
+			return day2Solar, nil
+		}
+		return SolarTimes{}, errors.New("no solar times")
+	})
 
 	tests := []struct {
 		name    string
@@ -486,7 +555,7 @@ func TestTLDef_SetCaptureTimes(t *testing.T) {
 				FirstFlags:   firstSunrise,
 				LastFlags:    lastSunset,
 				Additional:   1,
-				FolderPath:   "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/Kohm-Yah-mah-nee",
+				FolderPath:   testFolder,
 			},
 			day:     day1,
 			wantErr: false,
@@ -505,7 +574,7 @@ func TestTLDef_SetCaptureTimes(t *testing.T) {
 				FirstFlags:   firstSunrise,
 				LastFlags:    lastSunset,
 				Additional:   1,
-				FolderPath:   "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/Kohm-Yah-mah-nee",
+				FolderPath:   testFolder,
 				CaptureTimes: day1Capture,
 			},
 			day:     day2,
@@ -515,6 +584,7 @@ func TestTLDef_SetCaptureTimes(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			tt.tld.providers = providers
 			if err := tt.tld.SetCaptureTimes(tt.day); (err != nil) != tt.wantErr {
 				t.Errorf("TLDef.SetCaptureTimes() error = %v, wantErr %v", err, tt.wantErr)
 			} else {
@@ -944,7 +1014,7 @@ func TestTLDef_UpdateNextCapture(t *testing.T) {
 		FirstFlags:   firstSunrise,
 		LastFlags:    lastSunset,
 		Additional:   3,
-		FolderPath:   "/Volumes/ExtFiles/OneDrive/Pictures/Timelapse/zzTest",
+		FolderPath:   testFolder,
 		CaptureTimes: CaptureTimes{sunset, sunset.Add(-mins30), sunrise.Add(mins60), sunrise, solarNoon},
 		NextCapture:  0,
 	}
@@ -1010,17 +1080,21 @@ func TestTLDef_NextCaptureTime(t *testing.T) {
 }
 
 func TestTLDef_IsTimeForCapture(t *testing.T) {
-	t.Skip()
 	tests := []struct {
 		name string
-		tld  TLDef
+		now  time.Time
 		want bool
 	}{
-		// TODO: Add test cases.
+		{name: "before", now: solarNoon.Add(-time.Minute), want: false},
+		{name: "at", now: solarNoon, want: false},
+		{name: "after", now: solarNoon.Add(time.Second), want: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := tt.tld.IsTimeForCapture(); got != tt.want {
+			tld := newBaseTLD()
+			tld.NextCapture = 1
+			tld.providers = newFakeProviders(newFakeClock(tt.now), &fakeImages{})
+			if got := tld.IsTimeForCapture(); got != tt.want {
 				t.Errorf("TLDef.IsTimeForCapture() = %v, want %v", got, tt.want)
 			}
 		})
@@ -1077,7 +1151,7 @@ func Test_masterTLDefs_Read(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.mtld.Read(tt.args.path); (err != nil) != tt.wantErr {
+			if err := tt.mtld.Read(tt.args.path, srv.validate); (err != nil) != tt.wantErr {
 				t.Errorf("masterTLDefs.Read() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -1095,7 +1169,7 @@ func Test_masterTLDefs_Write(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := tt.mtld.Write(); (err != nil) != tt.wantErr {
+			if err := tt.mtld.Write(srv.definitionsPath(), srv.validate); (err != nil) != tt.wantErr {
 				t.Errorf("masterTLDefs.Write() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -1249,6 +1323,14 @@ func TestTLDef_GetSolarTimes(t *testing.T) {
 		t.Fatalf("time.Parse: %v", err)
 	}
 
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":{"sunrise":"2020-05-27T12:39:41+00:00","sunset":"2020-05-28T03:27:15+00:00",` +
+			`"solar_noon":"2020-05-27T20:03:28+00:00"},"status":"OK"}`))
+	}))
+	defer ts.Close()
+	providers := newFakeProviders(newFakeClock(testDate), &fakeImages{})
+	providers.Solar = &sunriseSunset{baseURL: ts.URL} // as received from sunrise-sunset.org
+
 	tests := []struct {
 		name    string
 		tld     TLDef
@@ -1274,6 +1356,7 @@ func TestTLDef_GetSolarTimes(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			var err error
+			tt.tld.providers = providers
 			if err = tt.tld.GetSolarTimes(tt.date); (err != nil) != tt.wantErr {
 				t.Errorf("GetSolarTimes() error = %v, wantErr %v", err, tt.wantErr)
 			}
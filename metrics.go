@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// lookup providers, the "provider" label of the lookup metrics
+const (
+	lookupSolar    = "sunrise-sunset.org"
+	lookupTimeZone = "timezonedb.com"
+)
+
+// capture failure reasons, the "reason" label of captures failed
+const (
+	reasonStatus  = "status"  // the webcam answered, but not with 200 OK
+	reasonTimeout = "timeout" // the webcam didn't answer in time
+	reasonNetwork = "network" // connecting to or reading from the webcam failed
+	reasonStorage = "storage" // the capture couldn't be stored
+	reasonOther   = "other"
+)
+
+// statusError reports a webcam response other than 200 OK
+type statusError struct {
+	URL    string
+	Status string // e.g., "404 Not Found"
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// metrics are the Prometheus metrics served at "/metrics"
+type metrics struct {
+	registry       *prometheus.Registry
+	attempted      *prometheus.CounterVec   // by webcam
+	succeeded      *prometheus.CounterVec   // by webcam
+	failed         *prometheus.CounterVec   // by webcam and reason
+	bytes          *prometheus.CounterVec   // by webcam
+	retrieval      *prometheus.HistogramVec // by webcam
+	lag            *prometheus.HistogramVec // by webcam
+	lookups        *prometheus.HistogramVec // by provider
+	lookupFailures *prometheus.CounterVec   // by provider
+}
+
+// newMetrics creates the metrics of s, registered with their own registry
+// along with the Go runtime and process metrics
+func newMetrics(s *server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		attempted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "timelapse_captures_attempted_total",
+			Help: "Captures attempted.",
+		}, []string{"webcam"}),
+		succeeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "timelapse_captures_succeeded_total",
+			Help: "Captures stored.",
+		}, []string{"webcam"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "timelapse_captures_failed_total",
+			Help: "Captures failed, by reason: status, timeout, network, storage or other.",
+		}, []string{"webcam", "reason"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "timelapse_capture_bytes_total",
+			Help: "Bytes of captured images written.",
+		}, []string{"webcam"}),
+		retrieval: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "timelapse_retrieval_duration_seconds",
+			Help:    "Time to retrieve and store a webcam image.",
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"webcam"}),
+		lag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "timelapse_scheduler_lag_seconds",
+			Help:    "Actual minus scheduled capture time.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+		}, []string{"webcam"}),
+		lookups: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "timelapse_lookup_duration_seconds",
+			Help:    "Time of solar and time zone lookups.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"provider"}),
+		lookupFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "timelapse_lookup_failures_total",
+			Help: "Solar and time zone lookups failed.",
+		}, []string{"provider"}),
+	}
+
+	m.registry.MustRegister(m.attempted, m.succeeded, m.failed, m.bytes, m.retrieval, m.lag, m.lookups, m.lookupFailures)
+	m.registry.MustRegister(&statusCollector{s: s})
+	m.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// captureStarted counts a capture attempt scheduled at the specified time,
+// started now
+func (m *metrics) captureStarted(webcam string, scheduled, now time.Time) {
+	m.attempted.WithLabelValues(webcam).Inc()
+	if !scheduled.IsZero() {
+		m.lag.WithLabelValues(webcam).Observe(now.Sub(scheduled).Seconds())
+	}
+}
+
+// captureDone counts the outcome of a capture attempt that took d
+func (m *metrics) captureDone(webcam string, d time.Duration, size int64, err error) {
+	m.retrieval.WithLabelValues(webcam).Observe(d.Seconds())
+	if err != nil {
+		m.failed.WithLabelValues(webcam, failureReason(err)).Inc()
+		return
+	}
+	m.succeeded.WithLabelValues(webcam).Inc()
+	m.bytes.WithLabelValues(webcam).Add(float64(size))
+}
+
+// lookupDone records a lookup from provider started at start
+func (m *metrics) lookupDone(provider string, start time.Time, err error) {
+	m.lookups.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.lookupFailures.WithLabelValues(provider).Inc()
+	}
+}
+
+// failureReason classifies a capture error
+func failureReason(err error) string {
+	var statusErr *statusError
+	var netErr net.Error
+	var pathErr *os.PathError
+	switch {
+	case errors.As(err, &statusErr):
+		return reasonStatus
+	case errors.As(err, &pathErr): // before net.Error, which *os.PathError implements
+		return reasonStorage
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return reasonTimeout
+	case errors.As(err, &netErr):
+		return reasonNetwork
+	}
+	return reasonOther
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// statusCollector reports the backoff and next capture of each running
+// webcam from its status, when scraped, so removed webcams disappear
+type statusCollector struct {
+	s *server
+}
+
+var (
+	backoffDesc = prometheus.NewDesc("timelapse_backoff_seconds",
+		"Delay before the next retrieval attempt after failures.", []string{"webcam"}, nil)
+	nextCaptureDesc = prometheus.NewDesc("timelapse_next_capture_seconds",
+		"Seconds until the next scheduled capture, negative if overdue.", []string{"webcam"}, nil)
+)
+
+// Describe implements prometheus.Collector
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- backoffDesc
+	ch <- nextCaptureDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	now := c.s.providers.Clock.Now()
+	seen := make(map[string]bool) // report each name once, should a go routine outlive its definition
+	for tld, st := range c.s.status {
+		if seen[tld.Name] {
+			continue
+		}
+		seen[tld.Name] = true
+		ch <- prometheus.MustNewConstMetric(backoffDesc, prometheus.GaugeValue, float64(st.Backoff), tld.Name)
+		if !st.NextCapture.IsZero() {
+			ch <- prometheus.MustNewConstMetric(nextCaptureDesc, prometheus.GaugeValue, st.NextCapture.Sub(now).Seconds(), tld.Name)
+		}
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleMetrics is the handler for GET "/metrics", in the Prometheus
+// text format
+func (s *server) handleMetrics() httprouter.Handle {
+	h := promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		h.ServeHTTP(w, r)
+	}
+}
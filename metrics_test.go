@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_failureReason(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, timeoutErr := client.Get(ts.URL)
+	_, networkErr := http.Get("http://127.0.0.1:1/webcam.jpg")
+	_, pathErr := os.Create("/nonexistent/folder/frame")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "status", err: &statusError{URL: "https://example.com/webcam.jpg", Status: "404 Not Found"}, want: reasonStatus},
+		{name: "timeout", err: timeoutErr, want: reasonTimeout},
+		{name: "network", err: networkErr, want: reasonNetwork},
+		{name: "storage", err: pathErr, want: reasonStorage},
+		{name: "wrapped", err: fmt.Errorf("capture: %w", pathErr), want: reasonStorage},
+		{name: "other", err: errors.New("something else"), want: reasonOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureReason(tt.err); got != tt.want {
+				t.Errorf("failureReason(%v) got %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_handleMetrics(t *testing.T) {
+	tld := &TLDef{Name: "testMetrics"}
+	srv.mu.Lock()
+	srv.status[tld] = &webcamStatus{Running: true, NextCapture: time.Now().Add(time.Hour), Backoff: 8}
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.status, tld)
+		srv.mu.Unlock()
+	}()
+
+	srv.metrics.captureStarted(tld.Name, time.Now().Add(-90*time.Second), time.Now())
+	srv.metrics.captureDone(tld.Name, 2*time.Second, 0, &statusError{URL: "https://example.com/webcam.jpg", Status: "503 Service Unavailable"})
+	srv.metrics.captureStarted(tld.Name, time.Now(), time.Now())
+	srv.metrics.captureDone(tld.Name, time.Second, 1234, nil)
+	srv.metrics.lookupDone(lookupTimeZone, time.Now(), context.DeadlineExceeded)
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	srv.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handleMetrics got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`timelapse_captures_attempted_total{webcam="testMetrics"} 2`,
+		`timelapse_captures_succeeded_total{webcam="testMetrics"} 1`,
+		`timelapse_captures_failed_total{reason="status",webcam="testMetrics"} 1`,
+		`timelapse_capture_bytes_total{webcam="testMetrics"} 1234`,
+		`timelapse_retrieval_duration_seconds_count{webcam="testMetrics"} 2`,
+		`timelapse_scheduler_lag_seconds_bucket{webcam="testMetrics",le="60"} 1`,
+		`timelapse_lookup_failures_total{provider="timezonedb.com"} 1`,
+		`timelapse_backoff_seconds{webcam="testMetrics"} 8`,
+		`timelapse_next_capture_seconds{webcam="testMetrics"} 3`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("handleMetrics want %q", want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	originalFolder = "original" // sub-folder of FolderPath holding unprocessed captures
+	defaultQuality = 90         // JPEG quality used when re-encoding frames
+)
+
+// TransformDef describes one image transform:
+//
+//	crop:    keep the Width x Height rectangle with top-left corner X, Y
+//	resize:  scale to Width x Height; when one is 0, preserve the aspect ratio
+//	fit:     scale to fit within Width x Height, preserving the aspect ratio
+//	rotate:  rotate clockwise by Degrees (90, 180 or 270)
+//	quality: re-encode as JPEG with the specified Quality
+type TransformDef struct {
+	Op      string `json:"op" validate:"required,oneof=crop resize fit rotate quality"`
+	X       int    `json:"x,omitempty" validate:"min=0"`
+	Y       int    `json:"y,omitempty" validate:"min=0"`
+	Width   int    `json:"width,omitempty" validate:"min=0"`
+	Height  int    `json:"height,omitempty" validate:"min=0"`
+	Degrees int    `json:"degrees,omitempty" validate:"omitempty,oneof=90 180 270"`
+	Quality int    `json:"quality,omitempty" validate:"omitempty,min=1,max=100"`
+}
+
+// Pipeline is an ordered list of image transforms. It is applied to
+// each capture after it is stored, and to frames being rendered.
+type Pipeline []TransformDef
+
+// Check reports transforms missing the parameters their operation needs,
+// which the struct validation tags can't express
+func (p Pipeline) Check() error {
+	for i, t := range p {
+		switch t.Op {
+		case "crop", "fit":
+			if t.Width == 0 || t.Height == 0 {
+				return fmt.Errorf("transform %d (%s) requires width and height", i, t.Op)
+			}
+		case "resize":
+			if t.Width == 0 && t.Height == 0 {
+				return fmt.Errorf("transform %d (%s) requires width or height", i, t.Op)
+			}
+		case "rotate":
+			if t.Degrees == 0 {
+				return fmt.Errorf("transform %d (%s) requires degrees", i, t.Op)
+			}
+		case "quality":
+			if t.Quality == 0 {
+				return fmt.Errorf("transform %d (%s) requires quality", i, t.Op)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply applies the transforms in order, returning the transformed image
+// and the JPEG quality selected by the last "quality" transform (0 if none)
+func (p Pipeline) Apply(img image.Image) (image.Image, int, error) {
+	quality := 0
+	for i, t := range p {
+		switch t.Op {
+		case "crop":
+			r := image.Rect(t.X, t.Y, t.X+t.Width, t.Y+t.Height).Add(img.Bounds().Min)
+			if !r.In(img.Bounds()) {
+				return nil, 0, fmt.Errorf("transform %d (crop) %v outside image %v", i, r, img.Bounds())
+			}
+			img = toRGBA(img).SubImage(r)
+		case "resize":
+			w, h := t.Width, t.Height
+			b := img.Bounds()
+			if w == 0 {
+				w = b.Dx() * h / b.Dy()
+			}
+			if h == 0 {
+				h = b.Dy() * w / b.Dx()
+			}
+			img = scale(img, w, h)
+		case "fit":
+			b := img.Bounds()
+			w, h := t.Width, b.Dy()*t.Width/b.Dx()
+			if h > t.Height {
+				w, h = b.Dx()*t.Height/b.Dy(), t.Height
+			}
+			img = scale(img, w, h)
+		case "rotate":
+			img = rotate(img, t.Degrees)
+		case "quality":
+			quality = t.Quality
+		default:
+			return nil, 0, fmt.Errorf("transform %d: unknown op %q", i, t.Op)
+		}
+	}
+	return img, quality, nil
+}
+
+// Process loads the frame at src, applies the transforms and stores the
+// result at dst (which may be src). The original encoding is kept unless
+// a "quality" transform selects JPEG re-encoding.
+func (p Pipeline) Process(src, dst string) error {
+	img, format, err := LoadFrame(src)
+	if err != nil {
+		return err
+	}
+
+	img, quality, err := p.Apply(img)
+	if err != nil {
+		return err
+	}
+	if quality == 0 {
+		quality = defaultQuality
+	} else {
+		format = "jpeg"
+	}
+
+	return SaveFrame(dst, img, format, quality)
+}
+
+// scale resizes img to w x h
+func scale(img image.Image, w, h int) image.Image {
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
+
+// rotate rotates img clockwise by 90, 180 or 270 degrees
+func rotate(img image.Image, degrees int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var dst *image.RGBA
+	if degrees == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+	return dst
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// PostProcess runs the post-capture steps on a newly stored capture: the
+// original is optionally preserved, then Transforms are applied in place
+// and the Caption burned in
+func (tld *TLDef) PostProcess(path string, at time.Time, slot int) error {
+	sn := "TLDef.PostProcess"
+
+	if tld.KeepOriginal && (len(tld.Transforms) > 0 || (tld.Caption != nil && tld.Caption.Replace)) {
+		if err := keepOriginal(path); err != nil {
+			log.Printf("%s, %s keepOriginal: %v\n", sn, tld.Name, err)
+			return err
+		}
+	}
+
+	if len(tld.Transforms) > 0 {
+		if err := tld.Transforms.Process(path, path); err != nil {
+			log.Printf("%s, %s Transforms.Process: %v\n", sn, tld.Name, err)
+			return err
+		}
+	}
+
+	if tld.Caption != nil {
+		if _, err := tld.CaptionFrame(path, at, slot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepOriginal copies the capture at path to the "original" sub-folder
+func keepOriginal(path string) error {
+	dir := filepath.Join(filepath.Dir(path), originalFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, filepath.Base(path)), data, 0644)
+}
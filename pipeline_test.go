@@ -0,0 +1,150 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipeline_Apply(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+
+	tests := []struct {
+		name        string
+		p           Pipeline
+		wantBounds  image.Rectangle
+		wantQuality int
+		wantErr     bool
+	}{
+		{name: "empty",
+			p:          Pipeline{},
+			wantBounds: image.Rect(0, 0, 40, 20),
+		},
+		{name: "crop",
+			p:          Pipeline{{Op: "crop", X: 5, Y: 2, Width: 10, Height: 8}},
+			wantBounds: image.Rect(5, 2, 15, 10),
+		},
+		{name: "crop outside",
+			p:       Pipeline{{Op: "crop", X: 35, Y: 0, Width: 10, Height: 8}},
+			wantErr: true,
+		},
+		{name: "resize width",
+			p:          Pipeline{{Op: "resize", Width: 20}},
+			wantBounds: image.Rect(0, 0, 20, 10),
+		},
+		{name: "fit",
+			p:          Pipeline{{Op: "fit", Width: 100, Height: 10}},
+			wantBounds: image.Rect(0, 0, 20, 10),
+		},
+		{name: "rotate",
+			p:          Pipeline{{Op: "rotate", Degrees: 90}},
+			wantBounds: image.Rect(0, 0, 20, 40),
+		},
+		{name: "crop, resize, quality",
+			p: Pipeline{
+				{Op: "crop", Width: 20, Height: 20},
+				{Op: "resize", Width: 8, Height: 8},
+				{Op: "quality", Quality: 75},
+			},
+			wantBounds:  image.Rect(0, 0, 8, 8),
+			wantQuality: 75,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+			src.Set(0, 0, red)
+
+			got, quality, err := tt.p.Apply(src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pipeline.Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Bounds() != tt.wantBounds {
+				t.Errorf("Pipeline.Apply() bounds got %v, want %v", got.Bounds(), tt.wantBounds)
+			}
+			if quality != tt.wantQuality {
+				t.Errorf("Pipeline.Apply() quality got %d, want %d", quality, tt.wantQuality)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, red) // top-left
+
+	tests := []struct {
+		degrees int
+		want    image.Point // where the top-left pixel ends up
+	}{
+		{degrees: 90, want: image.Point{1, 0}},
+		{degrees: 180, want: image.Point{2, 1}},
+		{degrees: 270, want: image.Point{0, 2}},
+	}
+	for _, tt := range tests {
+		got := rotate(src, tt.degrees).(*image.RGBA)
+		if got.RGBAAt(tt.want.X, tt.want.Y) != red {
+			t.Errorf("rotate(%d) top-left pixel not at %v", tt.degrees, tt.want)
+		}
+	}
+}
+
+func TestPipeline_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Pipeline
+		wantErr bool
+	}{
+		{name: "valid", p: Pipeline{{Op: "crop", Width: 1, Height: 1}, {Op: "resize", Height: 10}, {Op: "rotate", Degrees: 180}}},
+		{name: "crop without size", p: Pipeline{{Op: "crop", Width: 1}}, wantErr: true},
+		{name: "resize without size", p: Pipeline{{Op: "resize"}}, wantErr: true},
+		{name: "rotate without degrees", p: Pipeline{{Op: "rotate"}}, wantErr: true},
+		{name: "quality without quality", p: Pipeline{{Op: "quality"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.Check(); (err != nil) != tt.wantErr {
+				t.Errorf("Pipeline.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLDef_PostProcess(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	tld.Transforms = Pipeline{{Op: "crop", Width: 40, Height: 30}, {Op: "quality", Quality: 80}}
+	tld.KeepOriginal = true
+
+	path := writeTestFrame(t, tld, sunrise, 60, 40, color.White)
+	if err := tld.PostProcess(path, sunrise, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	img, format, err := LoadFrame(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 || format != "jpeg" {
+		t.Errorf("TLDef.PostProcess() got %v %s, want 40x30 jpeg", img.Bounds(), format)
+	}
+
+	original, format, err := LoadFrame(filepath.Join(tld.FolderPath, originalFolder, filepath.Base(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if original.Bounds().Dx() != 60 || format != "png" {
+		t.Errorf("TLDef.PostProcess() original got %v %s, want 60x40 png", original.Bounds(), format)
+	}
+
+	if _, err := os.Stat(filepath.Join(tld.FolderPath, captionFolder)); !os.IsNotExist(err) {
+		t.Errorf("TLDef.PostProcess() without Caption created %q", captionFolder)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// plot is a minimal line chart drawn onto an RGBA image, used for the
+// brightness curves. Data coordinates are mapped onto the area inside
+// the margin; there are no text labels.
+type plot struct {
+	img        *image.RGBA
+	margin     int
+	minX, maxX float64
+	minY, maxY float64
+}
+
+var (
+	plotBackground = color.RGBA{255, 255, 255, 255}
+	plotAxis       = color.RGBA{128, 128, 128, 255}
+	plotSunrise    = color.RGBA{255, 165, 0, 255}
+	plotSolarNoon  = color.RGBA{218, 165, 32, 255}
+	plotSunset     = color.RGBA{178, 34, 34, 255}
+	plotSeries     = color.RGBA{31, 119, 180, 255}
+	plotSeriesAlt  = color.RGBA{44, 160, 44, 255}
+)
+
+// newPlot creates a w x h plot with a white background and axes, covering
+// the data range [minX, maxX] x [minY, maxY]
+func newPlot(w, h int, minX, maxX, minY, maxY float64) *plot {
+	p := &plot{
+		img:    image.NewRGBA(image.Rect(0, 0, w, h)),
+		margin: 20,
+		minX:   minX,
+		maxX:   maxX,
+		minY:   minY,
+		maxY:   maxY,
+	}
+	if p.maxX == p.minX {
+		p.maxX = p.minX + 1
+	}
+	if p.maxY == p.minY {
+		p.maxY = p.minY + 1
+	}
+
+	draw.Draw(p.img, p.img.Bounds(), &image.Uniform{plotBackground}, image.Point{}, draw.Src)
+
+	left, top, right, bottom := p.margin, p.margin, w-p.margin, h-p.margin
+	p.line(left, bottom, right, bottom, plotAxis)
+	p.line(left, top, left, bottom, plotAxis)
+	return p
+}
+
+// point maps data coordinates to image coordinates
+func (p *plot) point(x, y float64) (int, int) {
+	b := p.img.Bounds()
+	w := float64(b.Dx() - 2*p.margin)
+	h := float64(b.Dy() - 2*p.margin)
+
+	px := p.margin + int((x-p.minX)/(p.maxX-p.minX)*w+0.5)
+	py := b.Dy() - p.margin - int((y-p.minY)/(p.maxY-p.minY)*h+0.5)
+	return px, py
+}
+
+// vline draws a vertical marker at data coordinate x, if within range
+func (p *plot) vline(x float64, c color.Color) {
+	if x < p.minX || x > p.maxX {
+		return
+	}
+	px, _ := p.point(x, p.minY)
+	p.line(px, p.margin, px, p.img.Bounds().Dy()-p.margin, c)
+}
+
+// series draws the data points joined by lines, marking each point
+func (p *plot) series(xs, ys []float64, c color.Color) {
+	for i := range xs {
+		x, y := p.point(xs[i], ys[i])
+		p.dot(x, y, c)
+		if i > 0 {
+			x0, y0 := p.point(xs[i-1], ys[i-1])
+			p.line(x0, y0, x, y, c)
+		}
+	}
+}
+
+// dot draws a small square centered on (x, y)
+func (p *plot) dot(x, y int, c color.Color) {
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			p.img.Set(x+dx, y+dy, c)
+		}
+	}
+}
+
+// line draws a line from (x0, y0) to (x1, y1) using Bresenham's algorithm
+func (p *plot) line(x0, y0, x1, y1 int, c color.Color) {
+	dx := abs(x1 - x0)
+	dy := -abs(y1 - y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	e := dx + dy
+	for {
+		p.img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
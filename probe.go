@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	maxProbeBytes  = 32 << 20 // largest webcam image probed
+	thumbnailWidth = 320
+)
+
+// ProbeResult reports a one-off retrieval of a webcam image
+type ProbeResult struct {
+	URL         string       `json:"url"`
+	OK          bool         `json:"ok"` // retrieved with status 200 and decoded as an image
+	Status      int          `json:"status,omitempty"`
+	ContentType string       `json:"contentType,omitempty"`
+	Format      string       `json:"format,omitempty"` // decoded image format, e.g., "jpeg"
+	Width       int          `json:"width,omitempty"`
+	Height      int          `json:"height,omitempty"`
+	Bytes       int64        `json:"bytes"`
+	LatencyMs   int64        `json:"latencyMs"` // until the whole image was read
+	Error       string       `json:"error,omitempty"`
+	Thumbnail   template.URL `json:"thumbnail,omitempty"` // "data:" URL of a JPEG thumbnail
+}
+
+// Probe retrieves the webcam image once, the way capture does, and reports
+// what was received. Nothing is saved. Canceling ctx, e.g. the request's
+// when its client goes away, abandons the retrieval.
+func (tld *TLDef) Probe(ctx context.Context) *ProbeResult {
+	sn := "TLDef.Probe"
+
+	pr := &ProbeResult{URL: tld.URL}
+	start := time.Now()
+	defer func() {
+		pr.LatencyMs = time.Since(start).Milliseconds()
+		if pr.Error != "" {
+			log.Printf("%s, %s %s: %s\n", sn, tld.Name, tld.URL, pr.Error)
+		}
+	}()
+
+	resp, err := tld.retrieve(ctx)
+	if err != nil {
+		pr.Error = err.Error()
+		return pr
+	}
+	defer resp.Body.Close()
+
+	pr.Status = resp.StatusCode
+	pr.ContentType = resp.Header.Get("Content-Type")
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBytes))
+	pr.Bytes = int64(len(body))
+	if err != nil {
+		pr.Error = err.Error()
+		return pr
+	}
+	if resp.StatusCode != http.StatusOK {
+		pr.Error = fmt.Sprintf("status %s", resp.Status)
+		return pr
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		pr.Error = fmt.Sprintf("not an image: %v", err)
+		return pr
+	}
+	pr.Format = format
+	pr.Width, pr.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	pr.OK = true
+
+	w, h := pr.Width, pr.Height
+	if w > thumbnailWidth {
+		w, h = thumbnailWidth, maxInt(1, h*thumbnailWidth/w)
+	}
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, scale(img, w, h), &jpeg.Options{Quality: 75}); err == nil {
+		pr.Thumbnail = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumb.Bytes()))
+	}
+
+	return pr
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleProbe is the handler for POST "/probe" and "/webcams/:name/probe",
+// re-rendering the submitted form with the result of probing its webcam URL.
+// Nothing is saved.
+func (s *server) handleProbe() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		tld, _, err := decodeForm(r) // probing needs only the webcam URL
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data := formPage(tld, p.ByName("name"))
+		if err := s.validate.Var(tld.URL, "url,required"); err != nil {
+			data.Probe = &ProbeResult{URL: tld.URL, Error: "Webcam URL is not a URL"}
+		} else {
+			data.Probe = tld.Probe(r.Context())
+		}
+		s.render(w, r, http.StatusOK, data)
+	}
+}
+
+// handleAPIProbe is the handler for POST "/api/v1/probe", probing the
+// "webcamUrl" in the request body. Nothing is saved.
+func (s *server) handleAPIProbe() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleAPIProbe"
+
+		tld := newTLDef()
+		if err := json.NewDecoder(r.Body).Decode(tld); err != nil {
+			log.Printf("%s, json.Decode: %v\n", sn, err)
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.validate.Var(tld.URL, "url,required"); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("webcamUrl %q is not a URL", tld.URL))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, tld.Probe(r.Context()))
+	}
+}
+
+// requireProbe, when configured, probes the webcam URL of a definition
+// about to be saved, returning an error if the probe fails
+func (s *server) requireProbe(ctx context.Context, tld *TLDef) error {
+	if !s.config.requireProbe {
+		return nil
+	}
+	if pr := tld.Probe(ctx); !pr.OK {
+		return fmt.Errorf("probe of webcam URL failed: %s", pr.Error)
+	}
+	return nil
+}
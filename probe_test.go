@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newWebcamServer serves a 640x480 PNG at "/webcam.png" and 404 elsewhere
+func newWebcamServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/webcam.png":
+			w.Header().Set("Content-Type", "image/png")
+			png.Encode(w, image.NewGray(image.Rect(0, 0, 640, 480)))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestTLDef_Probe(t *testing.T) {
+	ts := newWebcamServer()
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantOK     bool
+		wantStatus int
+		wantError  string
+	}{
+		{name: "image", path: "/webcam.png", wantOK: true, wantStatus: http.StatusOK},
+		{name: "not found", path: "/missing.jpg", wantStatus: http.StatusNotFound, wantError: "404"},
+		{name: "not an image", path: "/page.html", wantStatus: http.StatusOK, wantError: "not an image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := &TLDef{Name: "testProbe", URL: ts.URL + tt.path}
+			pr := tld.Probe(context.Background())
+
+			if pr.OK != tt.wantOK || pr.Status != tt.wantStatus {
+				t.Fatalf("TLDef.Probe() got ok %t status %d, want %t %d: %s", pr.OK, pr.Status, tt.wantOK, tt.wantStatus, pr.Error)
+			}
+			if !strings.Contains(pr.Error, tt.wantError) {
+				t.Errorf("TLDef.Probe() error %q, want %q", pr.Error, tt.wantError)
+			}
+			if tt.wantOK {
+				if pr.Format != "png" || pr.Width != 640 || pr.Height != 480 || pr.ContentType != "image/png" {
+					t.Errorf("TLDef.Probe() got %s %dx%d %s, want png 640x480 image/png", pr.Format, pr.Width, pr.Height, pr.ContentType)
+				}
+				if !strings.HasPrefix(string(pr.Thumbnail), "data:image/jpeg;base64,") {
+					t.Errorf("TLDef.Probe() thumbnail missing")
+				}
+			}
+		})
+	}
+}
+
+func TestTLDef_Probe_canceled(t *testing.T) {
+	ts := newWebcamServer()
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // the client went away
+	tld := &TLDef{Name: "testProbe", URL: ts.URL + "/webcam.png"}
+	if pr := tld.Probe(ctx); pr.OK || !strings.Contains(pr.Error, context.Canceled.Error()) {
+		t.Errorf("TLDef.Probe() of a canceled context got ok %t error %q, want %q", pr.OK, pr.Error, context.Canceled)
+	}
+}
+
+func Test_server_handleProbe(t *testing.T) {
+	ts := newWebcamServer()
+	defer ts.Close()
+
+	form := func(webcamURL string) url.Values {
+		return url.Values{
+			"name":         {"testProbe"},
+			"webcamUrl":    {webcamURL},
+			"latitude":     {"40.437787"},
+			"longitude":    {"-121.5360307"},
+			"firstSunrise": {""},
+			"lastSunset":   {""},
+			"additional":   {"0"},
+			"folder":       {"/tmp/testProbe"},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		form         url.Values
+		requireProbe bool
+		wantStatus   int
+		substring    string
+	}{
+		{name: "probe form",
+			path:       "/probe",
+			form:       url.Values{"webcamUrl": {ts.URL + "/webcam.png"}},
+			wantStatus: http.StatusOK,
+			substring:  "640 x 480",
+		},
+		{name: "probe form failure",
+			path:       "/probe",
+			form:       url.Values{"webcamUrl": {ts.URL + "/missing.jpg"}},
+			wantStatus: http.StatusOK,
+			substring:  "404 Not Found",
+		},
+		{name: "probe form not a URL",
+			path:       "/probe",
+			form:       url.Values{"webcamUrl": {"webcam.png"}},
+			wantStatus: http.StatusOK,
+			substring:  "is not a URL",
+		},
+		{name: "new requires probe",
+			path:         "/new",
+			form:         form(ts.URL + "/missing.jpg"),
+			requireProbe: true,
+			wantStatus:   http.StatusUnprocessableEntity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.config.requireProbe = tt.requireProbe
+			defer func() { srv.config.requireProbe = false }()
+
+			req, err := http.NewRequest("POST", tt.path, strings.NewReader(tt.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			} else if tt.substring != "" && !strings.Contains(rr.Body.String(), tt.substring) {
+				t.Errorf("%s want substring %q, not found in %q", tt.name, tt.substring, rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_server_handleAPIProbe(t *testing.T) {
+	ts := newWebcamServer()
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		substring  string
+	}{
+		{name: "image", body: `{"webcamUrl": "` + ts.URL + `/webcam.png"}`, wantStatus: http.StatusOK, substring: `"ok":true`},
+		{name: "failure", body: `{"webcamUrl": "` + ts.URL + `/missing.jpg"}`, wantStatus: http.StatusOK, substring: `"status":404`},
+		{name: "not a URL", body: `{"webcamUrl": "webcam.png"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/v1/probe", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			} else if tt.substring != "" && !strings.Contains(rr.Body.String(), tt.substring) {
+				t.Errorf("%s want substring %q, not found in %q", tt.name, tt.substring, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SolarTimes are the sunrise, solar noon and sunset of a location on a
+// date, UTC
+type SolarTimes struct {
+	Sunrise   time.Time
+	SolarNoon time.Time
+	Sunset    time.Time
+}
+
+// SolarProvider looks up the solar times of a location on a date
+type SolarProvider interface {
+	SolarTimes(lat, lng float64, date time.Time) (SolarTimes, error)
+}
+
+// TimeZoneProvider looks up the time zone of a location, e.g.,
+// "America/Los_Angeles"
+type TimeZoneProvider interface {
+	TimeZone(lat, lng float64) (string, error)
+}
+
+// ImageSource requests webcam images. The caller closes the response body.
+type ImageSource interface {
+	Retrieve(ctx context.Context, url string) (*http.Response, error)
+}
+
+// Providers are what scheduling and capturing depend on outside the
+// process: the clock, the solar and time zone lookups and the webcam
+// images, along with the time zone where this code is running and where
+// lookups are recorded. Tests replace them with fakes.
+type Providers struct {
+	Clock     Clock
+	Solar     SolarProvider
+	TimeZones TimeZoneProvider
+	Images    ImageSource
+	Local     *time.Location                                    // time zone where this code is running
+	Lookups   func(provider string, start time.Time, err error) // records each solar and time zone lookup, may be nil
+}
+
+// newProviders returns the Providers of production: the system clock,
+// sunrise-sunset.org, timezonedb.com with API key tzdbKey, HTTP and the
+// local time zone
+func newProviders(tzdbKey string) *Providers {
+	return &Providers{
+		Clock:     systemClock{},
+		Solar:     &sunriseSunset{baseURL: "https://api.sunrise-sunset.org/json"},
+		TimeZones: &timeZoneDB{baseURL: "http://api.timezonedb.com/v2.1/get-time-zone", key: tzdbKey},
+		Images:    &httpImages{},
+		Local:     time.Local,
+	}
+}
+
+// lookupDone records a lookup from provider started at start, if lookups
+// are recorded
+func (p *Providers) lookupDone(provider string, start time.Time, err error) {
+	if p.Lookups != nil {
+		p.Lookups(provider, start, err)
+	}
+}
+
+// env returns the Providers of tld, or the server's if it has none
+func (tld *TLDef) env() *Providers {
+	if tld.providers != nil {
+		return tld.providers
+	}
+	return srv.providers
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// sunriseSunset is the SolarProvider of sunrise-sunset.org
+type sunriseSunset struct {
+	baseURL string
+}
+
+// SolarTimes implements SolarProvider
+func (ss *sunriseSunset) SolarTimes(lat, lng float64, date time.Time) (SolarTimes, error) {
+	sn := "sunriseSunset.SolarTimes"
+	var st SolarTimes
+
+	ssdi := &SSDayInfo{Latitude: lat, Longitude: lng, Date: date}
+	query := ssdi.buildQuery(ss.baseURL)
+	req, err := http.NewRequest("GET", query, nil)
+	if err != nil {
+		log.Printf("%s, http.NewRequest: %v\n", sn, err)
+		return st, err
+	}
+
+	client := &http.Client{Timeout: time.Second * 2}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("%s, http.Client.Do: %v", sn, err)
+		return st, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("%s, ioutil.ReadAll: %v", sn, err)
+		return st, err
+	}
+
+	// strip off outer structure
+	wrapperStart := []byte(`{"results":`)
+	wrapperEnd := []byte(`,"status":"OK"}`)
+	if bytes.Index(body, wrapperStart) >= 0 {
+		tmpBody := bytes.TrimPrefix(body, wrapperStart)
+		body = bytes.TrimSuffix(tmpBody, wrapperEnd)
+	}
+
+	// change each time's "+00:00" suffix to "Z" to clean up time.Parse result
+	body = bytes.ReplaceAll(body, []byte(`+00:00"`), []byte(`Z"`))
+
+	if err := json.Unmarshal(body, ssdi); err != nil { // unmarshall all provided fields
+		log.Printf("%s, json.Unmarshal: %v", sn, err)
+		return st, err
+	}
+
+	if st.Sunrise, err = time.Parse(timeLayout, ssdi.SSDISunrise); err != nil {
+		log.Printf("%s, time.Parse(%s): %v", sn, ssdi.SSDISunrise, err)
+		return st, err
+	}
+	if st.SolarNoon, err = time.Parse(timeLayout, ssdi.SSDISolarNoon); err != nil {
+		log.Printf("%s, time.Parse(%s): %v", sn, ssdi.SSDISolarNoon, err)
+		return st, err
+	}
+	if st.Sunset, err = time.Parse(timeLayout, ssdi.SSDISunset); err != nil {
+		log.Printf("%s, time.Parse(%s): %v", sn, ssdi.SSDISunset, err)
+		return st, err
+	}
+	return st, nil
+}
+
+// timeZoneDB is the TimeZoneProvider of timezonedb.com
+type timeZoneDB struct {
+	baseURL string
+	key     string // API key
+}
+
+// TimeZone implements TimeZoneProvider
+func (tz *timeZoneDB) TimeZone(lat, lng float64) (string, error) {
+	sn := "timeZoneDB.TimeZone"
+
+	tzdb := &TimeZoneDB{}
+	req, err := http.NewRequest("GET", tzdb.buildQuery(tz.baseURL, tz.key, lat, lng), nil)
+	if err != nil {
+		log.Printf("%s, http.NewRequest: %v\n", sn, err)
+		return "", err
+	}
+
+	var resp *http.Response
+	for {
+		client := &http.Client{Timeout: time.Second * 2}
+		resp, err = client.Do(req)
+		if err != nil {
+			if urlErr, ok := err.(*url.Error); ok { // its URL holds the key
+				err = fmt.Errorf("%s %s: %w", urlErr.Op, tz.baseURL, urlErr.Err)
+			}
+			log.Printf("%s, http.Client.Do: %v", sn, err)
+			return "", err
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return "", &statusError{URL: tz.baseURL, Status: resp.Status}
+		}
+		// rate limited to 1 request/second
+		log.Printf("%s, received http.StatusTooMany (429), sleeping 2 seconds...\n", sn)
+		time.Sleep(2 * time.Second)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("%s, ioutil.ReadAll: %v", sn, err)
+		return "", err
+	}
+	if err = json.Unmarshal(body, tzdb); err != nil { // unmarshall all provided fields
+		log.Printf("%s, json.Unmarshal: %v", sn, err)
+		return "", err
+	}
+	return tzdb.ZoneName, nil
+}
+
+// httpImages is the ImageSource of webcams serving their image over HTTP
+type httpImages struct{}
+
+// Retrieve implements ImageSource
+func (httpImages) Retrieve(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Second * 10}
+	return client.Do(req)
+}
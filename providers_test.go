@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSolar is a SolarProvider computing the solar times of a date
+type fakeSolar func(date time.Time) (SolarTimes, error)
+
+// SolarTimes implements SolarProvider
+func (f fakeSolar) SolarTimes(lat, lng float64, date time.Time) (SolarTimes, error) {
+	return f(date)
+}
+
+// fakeSolarTimes has the sun rise at 6:00, culminate at 12:00 and set at
+// 18:00 where the code is running, every day
+func fakeSolarTimes(date time.Time) (SolarTimes, error) {
+	at := func(hour int) time.Time {
+		return time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, srv.providers.Local).UTC()
+	}
+	return SolarTimes{Sunrise: at(6), SolarNoon: at(12), Sunset: at(18)}, nil
+}
+
+// fakeTimeZone is a TimeZoneProvider placing every location in its zone
+type fakeTimeZone string
+
+// TimeZone implements TimeZoneProvider
+func (f fakeTimeZone) TimeZone(lat, lng float64) (string, error) {
+	return string(f), nil
+}
+
+// fakeImages is an ImageSource serving a PNG image, after failing the
+// first fail requests
+type fakeImages struct {
+	mu       sync.Mutex
+	fail     int
+	requests int
+}
+
+// Retrieve implements ImageSource
+func (f *fakeImages) Retrieve(ctx context.Context, url string) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests++
+	if f.requests <= f.fail {
+		return nil, errors.New("webcam unreachable")
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48)))
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"image/png"}},
+		Body:       ioutil.NopCloser(&buf),
+	}, nil
+}
+
+// count returns the number of requests
+func (f *fakeImages) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// newFakeProviders returns Providers working offline, with clock
+func newFakeProviders(clock Clock, images ImageSource) *Providers {
+	return &Providers{
+		Clock:     clock,
+		Solar:     fakeSolar(fakeSolarTimes),
+		TimeZones: fakeTimeZone("America/Los_Angeles"),
+		Images:    images,
+		Local:     time.Local,
+	}
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func Test_sunriseSunset_SolarTimes(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"results":{"sunrise":"2020-05-27T12:39:41+00:00","sunset":"2020-05-28T03:27:15+00:00",` +
+			`"solar_noon":"2020-05-27T20:03:28+00:00","day_length":53254},"status":"OK"}`))
+	}))
+	defer ts.Close()
+
+	ss := &sunriseSunset{baseURL: ts.URL}
+	got, err := ss.SolarTimes(40.437787, -121.5360307, time.Date(2020, 5, 27, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SolarTimes{
+		Sunrise:   time.Date(2020, 5, 27, 12, 39, 41, 0, time.UTC),
+		SolarNoon: time.Date(2020, 5, 27, 20, 3, 28, 0, time.UTC),
+		Sunset:    time.Date(2020, 5, 28, 3, 27, 15, 0, time.UTC),
+	}
+	if got != want {
+		t.Errorf("SolarTimes() got %+v, want %+v", got, want)
+	}
+	if wantQuery := "date=2020-05-27&formatted=0&lat=40.4377870&lng=-121.5360307"; query != wantQuery {
+		t.Errorf("SolarTimes() queried %q, want %q", query, wantQuery)
+	}
+}
+
+func Test_timeZoneDB_TimeZone(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"valid", http.StatusOK, `{"status":"OK","zoneName":"America/Los_Angeles","abbreviation":"PDT"}`, "America/Los_Angeles", false},
+		{"invalid key", http.StatusBadRequest, `{"status":"FAILED","message":"Invalid API key."}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("key") != "secret" {
+					t.Errorf("TimeZone() queried %q", r.URL.RawQuery)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+
+			tz := &timeZoneDB{baseURL: ts.URL, key: "secret"}
+			got, err := tz.TimeZone(40.437787, -121.5360307)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("TimeZone() got %q, %v, want %q, wantErr %t", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLDef_env(t *testing.T) {
+	tld := newBaseTLD()
+	if tld.env() != srv.providers {
+		t.Errorf("env() of a definition without providers isn't the server's")
+	}
+
+	tld.providers = newFakeProviders(newFakeClock(sunrise), &fakeImages{})
+	tld.CaptureTimes = CaptureTimes{}
+	if err := tld.SetCaptureTimes(sunrise); err != nil {
+		t.Fatal(err)
+	}
+	if tld.WebcamTZ != "America/Los_Angeles" || len(tld.CaptureTimes) != 3 || tld.CaptureTimes[0].In(srv.providers.Local).Hour() != 6 {
+		t.Errorf("SetCaptureTimes() with fake providers got %s, %v", tld.WebcamTZ, tld.CaptureTimes)
+	}
+
+	// lookups are recorded where the providers say
+	var recorded []string
+	tld.providers.Lookups = func(provider string, start time.Time, err error) {
+		recorded = append(recorded, provider)
+	}
+	tld.CaptureTimes = CaptureTimes{}
+	if err := tld.SetCaptureTimes(sunrise); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 2 || recorded[0] != lookupTimeZone || recorded[1] != lookupSolar {
+		t.Errorf("SetCaptureTimes() recorded lookups %v, want %s and %s", recorded, lookupTimeZone, lookupSolar)
+	}
+}
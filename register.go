@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultMaxShift  = 32  // largest translation searched, in pixels
+	maxMaxShift      = 128 // limit on maxshift, the coarse search grows with its square
+	coarseWidth      = 256 // the pyramid is halved until no wider than this
+	angleStep        = 0.5 // rotation search step, in degrees
+	denseSampleWidth = 512 // wider pyramid levels are sampled every other pixel
+)
+
+// Offset is the estimated displacement of one frame relative to the
+// reference frame: its content at (x+DX, y+DY), rotated by Angle degrees
+// about the center, matches the reference at (x, y)
+type Offset struct {
+	Frame
+	DX    int     `json:"dx"`
+	DY    int     `json:"dy"`
+	Angle float64 `json:"angle"`
+	Score float64 `json:"score"` // normalized cross-correlation at the estimate, 1 is a perfect match
+}
+
+// Registration aligns the frames of a sequence with its first frame, so
+// a camera that shakes or drifts produces a stable timelapse. Frames are
+// shifted and rotated back into place, then cropped to the region all
+// frames cover.
+type Registration struct {
+	MaxShift int             `json:"maxShift"`
+	MaxAngle float64         `json:"maxAngle"`
+	Crop     image.Rectangle `json:"crop"` // region of the reference frame kept in aligned frames
+	Offsets  []Offset        `json:"offsets"`
+}
+
+// NewRegistration estimates the offset of each frame, after applying the
+// pipeline, relative to the first frame. Translation up to maxShift pixels
+// and rotation up to maxAngle degrees are searched.
+func NewRegistration(frames []Frame, maxShift int, maxAngle float64, p Pipeline) (*Registration, error) {
+	sn := "NewRegistration"
+
+	if maxShift < 1 {
+		maxShift = defaultMaxShift
+	}
+	reg := &Registration{MaxShift: maxShift, MaxAngle: maxAngle, Offsets: []Offset{}}
+
+	var ref []*grayImage // pyramid of the reference frame, full resolution first
+	var bounds image.Rectangle
+	margin := 0
+	for _, frame := range frames {
+		img, _, err := LoadFrame(frame.Path)
+		if err != nil {
+			log.Printf("%s, skipping frame: %v\n", sn, err)
+			continue
+		}
+		if img, _, err = p.Apply(img); err != nil {
+			return nil, err
+		}
+
+		if ref == nil {
+			bounds = img.Bounds()
+			ref = newPyramid(img)
+			reg.Offsets = append(reg.Offsets, Offset{Frame: frame, Score: 1})
+			continue
+		}
+		if img.Bounds().Dx() != bounds.Dx() || img.Bounds().Dy() != bounds.Dy() {
+			return nil, fmt.Errorf("%s, %s is %v, reference frame is %v; add a resize transform",
+				sn, frame.Path, img.Bounds().Size(), bounds.Size())
+		}
+
+		o := estimateOffset(ref, newPyramid(img), maxShift, maxAngle)
+		o.Frame = frame
+		reg.Offsets = append(reg.Offsets, o)
+
+		// pixels near the edge rotate out of the frame too
+		rotation := math.Abs(math.Sin(o.Angle*math.Pi/180)) * float64(maxInt(bounds.Dx(), bounds.Dy())) / 2
+		margin = maxInt(margin, maxInt(abs(o.DX), abs(o.DY))+int(math.Ceil(rotation)))
+	}
+
+	if ref == nil {
+		return nil, fmt.Errorf("%s, no readable frames to register", sn)
+	}
+	if margin*2 >= bounds.Dx() || margin*2 >= bounds.Dy() {
+		return nil, fmt.Errorf("%s, offsets up to %d pixels leave nothing of the %v frames", sn, margin, bounds.Size())
+	}
+	reg.Crop = bounds.Inset(margin)
+	return reg, nil
+}
+
+// keep drops the offsets of the frames not in frames, a subsequence of
+// the frames registered, so Align is indexed as frames are
+func (reg *Registration) keep(frames []Frame) {
+	offsets := reg.Offsets[:0]
+	for _, o := range reg.Offsets {
+		if len(offsets) < len(frames) && o.Path == frames[len(offsets)].Path {
+			offsets = append(offsets, o)
+		}
+	}
+	reg.Offsets = offsets
+}
+
+// Align shifts and rotates img using the offset estimated for frame i, and
+// crops it to the region covered by all frames
+func (reg *Registration) Align(i int, img image.Image) image.Image {
+	o := reg.Offsets[i]
+	src := toRGBA(img)
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, reg.Crop.Dx(), reg.Crop.Dy()))
+
+	sin, cos := math.Sincos(o.Angle * math.Pi / 180)
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	for y := 0; y < reg.Crop.Dy(); y++ {
+		for x := 0; x < reg.Crop.Dx(); x++ {
+			rx := float64(reg.Crop.Min.X-b.Min.X+x) - cx
+			ry := float64(reg.Crop.Min.Y-b.Min.Y+y) - cy
+			sx := b.Min.X + int(math.Round(cos*rx-sin*ry+cx)) + o.DX
+			sy := b.Min.Y + int(math.Round(sin*rx+cos*ry+cy)) + o.DY
+			if image.Pt(sx, sy).In(b) {
+				si, di := src.PixOffset(sx, sy), dst.PixOffset(x, y)
+				copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+			}
+		}
+	}
+	return dst
+}
+
+// estimateOffset searches the coarsest pyramid level exhaustively for the
+// rotation and translation maximizing the correlation with the reference,
+// then refines the translation at each finer level
+func estimateOffset(ref, mov []*grayImage, maxShift int, maxAngle float64) Offset {
+	top := len(ref) - 1
+	levelShift := (maxShift + (1 << uint(top)) - 1) >> uint(top)
+
+	best := Offset{Score: math.Inf(-1)}
+	for angle := -maxAngle; angle <= maxAngle+1e-9; angle += angleStep {
+		for dy := -levelShift; dy <= levelShift; dy++ {
+			for dx := -levelShift; dx <= levelShift; dx++ {
+				if score := correlate(ref[top], mov[top], dx, dy, angle, levelShift); score > best.Score {
+					best = Offset{DX: dx, DY: dy, Angle: angle, Score: score}
+				}
+			}
+		}
+		if maxAngle <= 0 {
+			break
+		}
+	}
+
+	for level := top - 1; level >= 0; level-- {
+		levelShift = (maxShift + (1 << uint(level)) - 1) >> uint(level)
+		cx, cy := best.DX*2, best.DY*2
+		best.Score = math.Inf(-1)
+		for dy := cy - 1; dy <= cy+1; dy++ {
+			for dx := cx - 1; dx <= cx+1; dx++ {
+				if abs(dx) > levelShift || abs(dy) > levelShift {
+					continue
+				}
+				if score := correlate(ref[level], mov[level], dx, dy, best.Angle, levelShift); score > best.Score {
+					best.DX, best.DY, best.Score = dx, dy, score
+				}
+			}
+		}
+	}
+	return best
+}
+
+// correlate returns the normalized cross-correlation of the reference with
+// the moving image rotated by angle degrees and shifted by (dx, dy), over
+// the reference inset by border
+func correlate(ref, mov *grayImage, dx, dy int, angle float64, border int) float64 {
+	step := 1
+	if ref.w > denseSampleWidth {
+		step = 2
+	}
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx, cy := float64(ref.w)/2, float64(ref.h)/2
+
+	var n, sa, sb, saa, sbb, sab float64
+	for y := border; y < ref.h-border; y += step {
+		for x := border; x < ref.w-border; x += step {
+			mx, my := x+dx, y+dy
+			if angle != 0 {
+				rx, ry := float64(x)-cx, float64(y)-cy
+				mx = int(math.Round(cos*rx-sin*ry+cx)) + dx
+				my = int(math.Round(sin*rx+cos*ry+cy)) + dy
+			}
+			if mx < 0 || my < 0 || mx >= mov.w || my >= mov.h {
+				continue
+			}
+			a := float64(ref.pix[y*ref.w+x])
+			b := float64(mov.pix[my*mov.w+mx])
+			n++
+			sa += a
+			sb += b
+			saa += a * a
+			sbb += b * b
+			sab += a * b
+		}
+	}
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	va, vb := saa-sa*sa/n, sbb-sb*sb/n
+	if va <= 0 || vb <= 0 { // featureless, e.g., fog or night
+		return 0
+	}
+	return (sab - sa*sb/n) / math.Sqrt(va*vb)
+}
+
+// grayImage holds the luma of an image, for correlation
+type grayImage struct {
+	w, h int
+	pix  []float32
+}
+
+// newPyramid returns the luma of img at full resolution, followed by
+// successively halved copies down to coarseWidth
+func newPyramid(img image.Image) []*grayImage {
+	b := img.Bounds()
+	g := &grayImage{w: b.Dx(), h: b.Dy(), pix: make([]float32, b.Dx()*b.Dy())}
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			r, gr, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			g.pix[y*g.w+x] = float32(0.299*float64(r>>8) + 0.587*float64(gr>>8) + 0.114*float64(bl>>8))
+		}
+	}
+
+	pyramid := []*grayImage{g}
+	for g.w > coarseWidth && g.h > 1 {
+		g = g.half()
+		pyramid = append(pyramid, g)
+	}
+	return pyramid
+}
+
+// half returns the image downsampled by 2, averaging 2x2 blocks
+func (g *grayImage) half() *grayImage {
+	h := &grayImage{w: g.w / 2, h: g.h / 2}
+	h.pix = make([]float32, h.w*h.h)
+	for y := 0; y < h.h; y++ {
+		for x := 0; x < h.w; x++ {
+			i := 2*y*g.w + 2*x
+			h.pix[y*h.w+x] = (g.pix[i] + g.pix[i+1] + g.pix[i+g.w] + g.pix[i+g.w+1]) / 4
+		}
+	}
+	return h
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleRegistration is the handler for "/webcams/:name/registration.json",
+// reporting the per-frame offsets for a date range without rendering.
+// Query parameters "maxshift" (pixels) and "maxangle" (degrees) limit
+// the search.
+func (s *server) handleRegistration() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleRegistration"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkRenderDays(from, to); err != nil { // every frame in the range is decoded
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		maxShift, maxAngle, err := parseStabilize(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if maxShift == 0 {
+			maxShift = defaultMaxShift
+		}
+
+		frames, err := tld.Frames(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(frames) == 0 {
+			http.Error(w, fmt.Sprintf("%s no frames between %s and %s", tld.Name, from.Format(dateLayout), to.Format(dateLayout)), http.StatusNotFound)
+			return
+		}
+
+		reg, err := NewRegistration(frames, maxShift, maxAngle, nil)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		writeJSON(w, http.StatusOK, reg)
+	}
+}
+
+// parseStabilize returns the "maxshift" and "maxangle" query parameters,
+// 0 when absent
+func parseStabilize(q url.Values) (int, float64, error) {
+	var maxShift int
+	var maxAngle float64
+	var err error
+	if v := q.Get("maxshift"); v != "" {
+		if maxShift, err = strconv.Atoi(v); err != nil || maxShift < 0 || maxShift > maxMaxShift {
+			return 0, 0, fmt.Errorf("invalid maxshift %q, want 0-%d", v, maxMaxShift)
+		}
+	}
+	if v := q.Get("maxangle"); v != "" {
+		if maxAngle, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(maxAngle) || maxAngle < 0 || maxAngle > 10 {
+			return 0, 0, fmt.Errorf("invalid maxangle %q, want 0-10", v)
+		}
+	}
+	return maxShift, maxAngle, nil
+}
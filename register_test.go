@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeShiftedFrame stores a view of scene offset by (dx, dy) as a frame
+// captured at t, simulating a camera that has moved
+func writeShiftedFrame(t *testing.T, tld *TLDef, at time.Time, scene *image.Gray, dx, dy, w, h int) {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, scene.GrayAt(x+dx+50, y+dy+50))
+		}
+	}
+
+	f, err := os.Create(filepath.Join(tld.FolderPath, tld.Name+" "+at.Format(fileTimeLayout)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRegistration(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	// a textured scene, larger than the frames so they can be cut from it at any offset
+	rnd := rand.New(rand.NewSource(1))
+	scene := image.NewGray(image.Rect(0, 0, 420, 340))
+	for y := 0; y < 340; y += 4 {
+		for x := 0; x < 420; x += 4 {
+			v := uint8(rnd.Intn(256))
+			for i := 0; i < 16; i++ {
+				scene.SetGray(x+i%4, y+i/4, color.Gray{v})
+			}
+		}
+	}
+
+	offsets := []image.Point{{0, 0}, {5, -3}, {-7, 4}, {12, 9}}
+	for i, o := range offsets {
+		writeShiftedFrame(t, tld, sunrise.Add(time.Duration(i)*time.Minute), scene, o.X, o.Y, 320, 240)
+	}
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	frames, err := tld.Frames(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := NewRegistration(frames, 16, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Offsets) != len(offsets) {
+		t.Fatalf("NewRegistration() got %d offsets, want %d", len(reg.Offsets), len(offsets))
+	}
+	for i, want := range offsets {
+		got := reg.Offsets[i]
+		if got.DX != -want.X || got.DY != -want.Y { // the camera moved by want, so the content moved the other way
+			t.Errorf("NewRegistration() frame %d got (%d, %d), want (%d, %d)", i, got.DX, got.DY, -want.X, -want.Y)
+		}
+	}
+	if want := image.Rect(12, 12, 308, 228); reg.Crop != want {
+		t.Errorf("NewRegistration() crop got %v, want %v", reg.Crop, want)
+	}
+
+	// aligned frames all show the same part of the scene
+	want := reg.Align(0, mustLoadFrame(t, frames[0].Path)).(*image.RGBA)
+	for i := 1; i < len(frames); i++ {
+		got := reg.Align(i, mustLoadFrame(t, frames[i].Path)).(*image.RGBA)
+		if got.RGBAAt(100, 100) != want.RGBAAt(100, 100) || got.RGBAAt(10, 200) != want.RGBAAt(10, 200) {
+			t.Errorf("Registration.Align() frame %d not aligned with the reference", i)
+		}
+	}
+}
+
+func TestNewRegistration_noFrames(t *testing.T) {
+	_, err := NewRegistration(nil, 16, 0, nil)
+	if err == nil || !strings.Contains(err.Error(), "no readable frames") {
+		t.Errorf("NewRegistration() of no frames got %v, want no readable frames", err)
+	}
+}
+
+func Test_server_handleRegistration(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "too many days", query: "?from=2020-01-01&to=2020-12-31", wantStatus: http.StatusBadRequest},
+		{name: "NaN angle", query: "?date=2020-05-27&maxangle=NaN", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/webcams/"+url.PathEscape("Manzanita Lake")+"/registration.json"+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("handleRegistration() %s got %d, want %d: %s", tt.query, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRegistration_keep(t *testing.T) {
+	frame := func(name string) Frame { return Frame{Path: name} }
+	reg := &Registration{Offsets: []Offset{{Frame: frame("a")}, {Frame: frame("b"), DX: 1}, {Frame: frame("c"), DX: 2}}}
+
+	reg.keep([]Frame{frame("a"), frame("c")}) // "b" unreadable when deflickering
+	if len(reg.Offsets) != 2 || reg.Offsets[1].Path != "c" || reg.Offsets[1].DX != 2 {
+		t.Errorf("Registration.keep() got %+v, want the offsets of a and c", reg.Offsets)
+	}
+}
+
+func Test_parseStabilize(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"maxshift=16&maxangle=2.5", false},
+		{"maxshift=128", false},
+		{"maxshift=129", true},
+		{"maxshift=-1", true},
+		{"maxangle=11", true},
+		{"maxangle=NaN", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			if _, _, err := parseStabilize(q); (err != nil) != tt.wantErr {
+				t.Errorf("parseStabilize(%q) error %v, wantErr %t", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func mustLoadFrame(t *testing.T, path string) image.Image {
+	t.Helper()
+	img, _, err := LoadFrame(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	renderFolder  = "render" // sub-folder of FolderPath holding rendered sequences
+	maxRenderDays = 31       // rendered within the request, see handleRender
+)
+
+// RenderOptions configures rendering the frames of a date range
+type RenderOptions struct {
+	From      time.Time // first day rendered
+	To        time.Time // end of the range (exclusive)
+	Output    string    // folder receiving the rendered sequence
+	Pipeline  Pipeline  // transforms applied to each frame before stabilizing and deflickering
+	Stabilize int       // largest camera shift corrected, in pixels, 0 disables stabilizing
+	MaxAngle  float64   // largest camera rotation corrected when stabilizing, in degrees
+	Deflicker int       // deflicker smoothing window in frames, 0 disables deflickering
+	GIF       bool      // also assemble an animated GIF
+	GIFDelay  int       // delay between GIF frames, in 100ths of a second
+}
+
+// RenderResult describes a rendered sequence
+type RenderResult struct {
+	Name         string        `json:"name"`
+	Output       string        `json:"output"`
+	Frames       []string      `json:"frames"`               // numbered frames, e.g., "frame-00001.jpg", ready for ffmpeg
+	GIF          string        `json:"gif,omitempty"`        // animated GIF, when requested
+	Brightness   string        `json:"brightness,omitempty"` // before/after deflicker plot, when deflickering
+	Registration *Registration `json:"registration,omitempty"`
+	Deflicker    *Deflicker    `json:"deflicker,omitempty"`
+}
+
+// Render writes the frames captured in the range to the output folder as a
+// numbered JPEG sequence, applying the pipeline, stabilization and deflicker
+// corrections
+func (tld *TLDef) Render(opts RenderOptions) (*RenderResult, error) {
+	sn := "TLDef.Render"
+
+	frames, err := tld.Frames(opts.From, opts.To)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s, %s no frames between %s and %s", sn, tld.Name, opts.From.Format(dateLayout), opts.To.Format(dateLayout))
+	}
+
+	if err := os.MkdirAll(opts.Output, 0755); err != nil {
+		log.Printf("%s, %s os.MkdirAll: %v\n", sn, tld.Name, err)
+		return nil, err
+	}
+
+	result := &RenderResult{Name: tld.Name, Output: opts.Output, Frames: []string{}}
+	if opts.Stabilize > 0 {
+		if result.Registration, err = NewRegistration(frames, opts.Stabilize, opts.MaxAngle, opts.Pipeline); err != nil {
+			return nil, err
+		}
+		frames = frames[:0]
+		for _, o := range result.Registration.Offsets { // only frames that could be read
+			frames = append(frames, o.Frame)
+		}
+	}
+	if opts.Deflicker > 0 {
+		if result.Deflicker, err = NewDeflicker(frames, opts.Deflicker, opts.Pipeline); err != nil {
+			return nil, err
+		}
+		frames = frames[:0]
+		for _, s := range result.Deflicker.Samples { // only frames that could be read
+			frames = append(frames, s.Frame)
+		}
+		if result.Registration != nil { // index the offsets of the same frames as the samples
+			result.Registration.keep(frames)
+		}
+	}
+
+	var anim *gif.GIF
+	if opts.GIF {
+		anim = &gif.GIF{}
+	}
+
+	for i, frame := range frames {
+		img, _, err := LoadFrame(frame.Path)
+		if err != nil {
+			log.Printf("%s, %s skipping frame: %v\n", sn, tld.Name, err)
+			continue
+		}
+		img, quality, err := opts.Pipeline.Apply(img)
+		if err != nil {
+			return nil, err
+		}
+		if quality == 0 {
+			quality = defaultQuality
+		}
+		if result.Registration != nil {
+			img = result.Registration.Align(i, img)
+		}
+		if result.Deflicker != nil {
+			img = result.Deflicker.Correct(i, img)
+		}
+
+		name := fmt.Sprintf("frame-%05d.jpg", len(result.Frames)+1)
+		if err := SaveFrame(filepath.Join(opts.Output, name), img, "jpeg", quality); err != nil {
+			log.Printf("%s, %s SaveFrame: %v\n", sn, tld.Name, err)
+			return nil, err
+		}
+		result.Frames = append(result.Frames, name)
+
+		if anim != nil {
+			b := img.Bounds()
+			paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+			draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, b.Min)
+			anim.Image = append(anim.Image, paletted)
+			anim.Delay = append(anim.Delay, opts.GIFDelay)
+		}
+	}
+
+	if anim != nil {
+		result.GIF = "timelapse.gif"
+		if err := writeRenderFile(filepath.Join(opts.Output, result.GIF), func(f *os.File) error { return gif.EncodeAll(f, anim) }); err != nil {
+			log.Printf("%s, %s gif.EncodeAll: %v\n", sn, tld.Name, err)
+			return nil, err
+		}
+	}
+
+	if result.Deflicker != nil {
+		result.Brightness = "brightness.png"
+		plot := result.Deflicker.Image(800, 300)
+		if err := writeRenderFile(filepath.Join(opts.Output, result.Brightness), func(f *os.File) error { return png.Encode(f, plot) }); err != nil {
+			log.Printf("%s, %s png.Encode: %v\n", sn, tld.Name, err)
+			return nil, err
+		}
+	}
+
+	log.Printf("%s, %s rendered %d frames to %s\n", sn, tld.Name, len(result.Frames), opts.Output)
+	return result, nil
+}
+
+// writeRenderFile creates path and writes its content with encode
+func writeRenderFile(path string, encode func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encode(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderOptions returns the options rendering [from, to) into
+// FolderPath/render/<from>_<to>. Values "maxshift" (pixels, with optional
+// "maxangle"), "deflicker" (window) and "gif" (delay) enable those steps.
+func renderOptions(tld *TLDef, from, to time.Time, q url.Values) (RenderOptions, error) {
+	opts := RenderOptions{
+		From:   from,
+		To:     to,
+		Output: filepath.Join(tld.FolderPath, renderFolder, from.Format(dateLayout)+"_"+to.AddDate(0, 0, -1).Format(dateLayout)),
+	}
+	var err error
+	if opts.Stabilize, opts.MaxAngle, err = parseStabilize(q); err != nil {
+		return opts, err
+	}
+	if opts.MaxAngle > 0 && opts.Stabilize == 0 {
+		opts.Stabilize = defaultMaxShift
+	}
+	if v := q.Get("deflicker"); v != "" {
+		if opts.Deflicker, err = strconv.Atoi(v); err != nil || opts.Deflicker < 0 || opts.Deflicker > 0 && opts.Deflicker%2 == 0 {
+			return opts, fmt.Errorf("invalid deflicker %q, want an odd number of frames", v)
+		}
+	}
+	if v := q.Get("gif"); v != "" {
+		if opts.GIFDelay, err = strconv.Atoi(v); err != nil || opts.GIFDelay < 0 {
+			return opts, fmt.Errorf("invalid gif %q", v)
+		}
+		opts.GIF = true
+	}
+	return opts, nil
+}
+
+// checkRenderDays rejects ranges longer than maxRenderDays
+func checkRenderDays(from, to time.Time) error {
+	if to.After(from.AddDate(0, 0, maxRenderDays)) {
+		return fmt.Errorf("render at most %d days, or use the render command", maxRenderDays)
+	}
+	return nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleRender is the handler for POST "/webcams/:name/render", rendering
+// the selected date range, at most maxRenderDays as the response waits for
+// it, with the options in the query parameters, see renderOptions
+func (s *server) handleRender() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleRender"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkRenderDays(from, to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts, err := renderOptions(tld, from, to, r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := tld.Render(opts)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
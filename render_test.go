@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image/color"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLDef_Render(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		writeTestFrame(t, tld, sunrise.Add(time.Duration(i)*time.Hour), 40, 20, color.Gray{uint8(60 + 40*i)})
+	}
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+	output := filepath.Join(tld.FolderPath, renderFolder, "test")
+
+	result, err := tld.Render(RenderOptions{
+		From:      day,
+		To:        day.AddDate(0, 0, 1),
+		Output:    output,
+		Pipeline:  Pipeline{{Op: "resize", Width: 20}},
+		Deflicker: 3,
+		GIF:       true,
+		GIFDelay:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Frames) != 4 {
+		t.Errorf("TLDef.Render() got %d frames, want 4", len(result.Frames))
+	}
+	for _, name := range append(result.Frames, result.GIF, result.Brightness) {
+		if _, err := os.Stat(filepath.Join(output, name)); err != nil {
+			t.Errorf("TLDef.Render() %v", err)
+		}
+	}
+	img, _, err := LoadFrame(filepath.Join(output, result.Frames[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 10 {
+		t.Errorf("TLDef.Render() frame bounds got %v, want 20x10", img.Bounds())
+	}
+
+	if _, err := tld.Render(RenderOptions{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 0, 2), Output: output}); err == nil {
+		t.Errorf("TLDef.Render() without frames, want error")
+	}
+}
+
+func Test_renderOptions(t *testing.T) {
+	tld := newBaseTLD()
+	day := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"none", "", false},
+		{"deflicker", "deflicker=5", false},
+		{"deflicker even window", "deflicker=4", true},
+		{"deflicker negative", "deflicker=-1", true},
+		{"maxangle only", "maxangle=2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			if _, err := renderOptions(&tld, day, day.AddDate(0, 0, 1), q); (err != nil) != tt.wantErr {
+				t.Errorf("renderOptions(%q) error %v, wantErr %t", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := checkRenderDays(day, day.AddDate(0, 0, maxRenderDays+1)); err == nil {
+		t.Errorf("checkRenderDays() of %d days got nil", maxRenderDays+1)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxPreviewDays = 31 // sunrise-sunset.org is queried once per day previewed
+
+// previewFields are the form fields selecting the preview date range, not
+// part of the TLDef
+var previewFields = []string{"date", "from", "to"}
+
+// ScheduledCapture is one capture time of a previewed schedule
+type ScheduledCapture struct {
+	Slot   string    `json:"slot"`   // e.g., "sunrise +30" or "additional 2"
+	Webcam time.Time `json:"webcam"` // in the webcam's time zone
+	Server time.Time `json:"server"` // in the time zone where this code is running
+}
+
+// ScheduleDay is the capture schedule computed for one day, with solar
+// times in the webcam's time zone
+type ScheduleDay struct {
+	Date      string             `json:"date"`
+	Sunrise   time.Time          `json:"sunrise"`
+	SolarNoon time.Time          `json:"solarNoon"`
+	Sunset    time.Time          `json:"sunset"`
+	Captures  []ScheduledCapture `json:"captures"`
+	Anomalies []string           `json:"anomalies,omitempty"` // e.g., duplicate capture times
+}
+
+// Schedule is the capture schedule a definition produces over a date range
+type Schedule struct {
+	Name     string        `json:"name"`
+	WebcamTZ string        `json:"webcamTz"`
+	ServerTZ string        `json:"serverTz"` // abbreviation, e.g., "PDT"
+	Days     []ScheduleDay `json:"days"`
+}
+
+// Schedule computes the capture times SetCaptureTimes produces for each
+// day in [from, to), without changing tld
+func (tld *TLDef) Schedule(from, to time.Time) (*Schedule, error) {
+	sn := "TLDef.Schedule"
+
+	// use a copy, so the capture times of a running capture are not disturbed
+	probe := *tld
+	probe.CaptureTimes = []time.Time{}
+	if err := probe.SetWebcamTZ(); err != nil {
+		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+		return nil, err
+	}
+
+	local := tld.env().Local
+	serverTZ, _ := time.Now().In(local).Zone()
+	sched := &Schedule{Name: tld.Name, WebcamTZ: probe.WebcamTZ, ServerTZ: serverTZ, Days: []ScheduleDay{}}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		probe.CaptureTimes = []time.Time{}
+		if err := probe.SetDayCaptureTimes(day); err != nil {
+			log.Printf("%s, %s %s: %v\n", sn, tld.Name, day.Format(dateLayout), err)
+			return nil, err
+		}
+
+		sd := ScheduleDay{
+			Date:      day.Format(dateLayout),
+			Sunrise:   probe.SunriseUTC.In(probe.WebcamLoc),
+			SolarNoon: probe.SolarNoonUTC.In(probe.WebcamLoc),
+			Sunset:    probe.SunsetUTC.In(probe.WebcamLoc),
+			Captures:  []ScheduledCapture{},
+			Anomalies: probe.dayAnomalies(),
+		}
+		for i, ct := range probe.CaptureTimes {
+			sd.Captures = append(sd.Captures, ScheduledCapture{
+				Slot:   probe.SlotLabel(i),
+				Webcam: ct.In(probe.WebcamLoc),
+				Server: ct.In(local),
+			})
+		}
+		sched.Days = append(sched.Days, sd)
+	}
+
+	return sched, nil
+}
+
+// isPreviewField reports whether key is one of the previewFields
+func isPreviewField(key string) bool {
+	for _, f := range previewFields {
+		if key == f {
+			return true
+		}
+	}
+	return false
+}
+
+// previewRange returns the date range selected for a preview, in local,
+// limited to maxPreviewDays
+func previewRange(r *http.Request, local *time.Location) (time.Time, time.Time, error) {
+	from, to, err := parseDateRange(r, local)
+	if err != nil {
+		return from, to, err
+	}
+	return from, to, checkPreviewDays(from, to)
+}
+
+// checkPreviewDays rejects ranges longer than maxPreviewDays
+func checkPreviewDays(from, to time.Time) error {
+	if to.After(from.AddDate(0, 0, maxPreviewDays)) {
+		return fmt.Errorf("preview at most %d days", maxPreviewDays)
+	}
+	return nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handlePreview is the handler for POST "/preview" and
+// "/webcams/:name/preview", re-rendering the submitted form with the
+// schedule it produces. Nothing is saved.
+func (s *server) handlePreview() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handlePreview"
+
+		from, to, rangeErr := previewRange(r, s.providers.Local)
+
+		tld, errs, err := s.formTLDef(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if rangeErr != nil {
+			errs.add("from", rangeErr.Error())
+		}
+		if len(errs) > 0 {
+			s.formInvalid(w, r, tld, p.ByName("name"), r.Form, errs)
+			return
+		}
+
+		sched, err := tld.Schedule(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := formPage(tld, p.ByName("name"))
+		data.Preview = sched
+		data.From = from.Format(dateLayout)
+		data.To = to.AddDate(0, 0, -1).Format(dateLayout)
+		s.render(w, r, http.StatusOK, data)
+	}
+}
+
+// handleAPIPreview is the handler for POST "/api/v1/preview", returning the
+// schedule the TLDef in the request body produces for the "date", or "from"
+// and "to" query parameters. Nothing is saved.
+func (s *server) handleAPIPreview() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleAPIPreview"
+
+		from, to, err := previewRange(r, s.providers.Local)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		tld, ok := s.decodeTLDef(w, r)
+		if !ok {
+			return
+		}
+
+		sched, err := tld.Schedule(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sched)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLDef_Schedule(t *testing.T) {
+	tld := newBaseTLD()
+	tld.Additional = 3
+	from := time.Date(2020, 5, 27, 0, 0, 0, 0, srv.providers.Local)
+
+	sched, err := tld.Schedule(from, from.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("TLDef.Schedule() error: %v", err)
+	}
+
+	if sched.WebcamTZ != "America/Los_Angeles" {
+		t.Errorf("TLDef.Schedule() WebcamTZ got %q, want America/Los_Angeles", sched.WebcamTZ)
+	}
+	if len(sched.Days) != 2 {
+		t.Fatalf("TLDef.Schedule() got %d days, want 2", len(sched.Days))
+	}
+	for _, day := range sched.Days {
+		if len(day.Captures) != 5 {
+			t.Errorf("TLDef.Schedule() %s got %d captures, want 5", day.Date, len(day.Captures))
+			continue
+		}
+		if got := day.Captures[2].Slot; got != "solar noon" {
+			t.Errorf("TLDef.Schedule() %s middle capture %q, want solar noon", day.Date, got)
+		}
+		if !day.Captures[0].Webcam.Equal(day.Captures[0].Server) {
+			t.Errorf("TLDef.Schedule() %s webcam and server times differ", day.Date)
+		}
+	}
+	if len(tld.CaptureTimes) != 3 || tld.WebcamTZ != "" {
+		t.Errorf("TLDef.Schedule() changed the definition")
+	}
+}
+
+func Test_previewRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantDays int
+		wantErr  bool
+	}{
+		{name: "date", query: "date=2020-05-27", wantDays: 1},
+		{name: "range", query: "from=2020-05-01&to=2020-05-31", wantDays: 31},
+		{name: "too long", query: "from=2020-05-01&to=2020-06-01", wantErr: true},
+		{name: "invalid", query: "date=27-05-2020", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/preview?"+tt.query, nil)
+			from, to, err := previewRange(req, time.Local)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("previewRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && to.Sub(from) != time.Duration(tt.wantDays)*24*time.Hour {
+				t.Errorf("previewRange() got %v - %v, want %d days", from, to, tt.wantDays)
+			}
+		})
+	}
+}
+
+func Test_server_handlePreview(t *testing.T) {
+	valid := `{"name": "testPreview", "webcamUrl": "https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg",
+		"latitude": 40.437787, "longitude": -121.5360307, "firstSunrise": true, "lastSunset": true,
+		"additional": 3, "folder": "/tmp"}`
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "api invalid range",
+			path:        "/api/v1/preview?from=2020-05-01&to=2020-07-01",
+			contentType: "application/json",
+			body:        valid,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{name: "api invalid draft",
+			path:        "/api/v1/preview?date=2020-05-27",
+			contentType: "application/json",
+			body:        strings.Replace(valid, `"lastSunset": true`, `"lastSunset": false`, 1),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{name: "form invalid draft",
+			path:        "/preview",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"name": {"testPreview"}, "from": {"2020-05-27"}}.Encode(),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{name: "form invalid range",
+			path:        "/preview",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"name": {"testPreview"}, "from": {"2020-05-27"}, "to": {"2020-05-01"}}.Encode(),
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", tt.contentType)
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			}
+		})
+	}
+}
+
+func Test_previewTemplate(t *testing.T) {
+	tld := newBaseTLD()
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched := &Schedule{Name: tld.Name, WebcamTZ: la.String(), ServerTZ: "UTC", Days: []ScheduleDay{{
+		Date:      "2020-05-27",
+		Sunrise:   sunrise.In(la),
+		SolarNoon: solarNoon.In(la),
+		Sunset:    sunset.In(la),
+		Captures:  []ScheduledCapture{{Slot: "solar noon", Webcam: solarNoon.In(la), Server: solarNoon.UTC()}},
+	}}}
+
+	var b strings.Builder
+	data := page{Company: "Timelapse", Admin: true, Form: &tld, Action: "/new", PreviewAction: "/preview", Preview: sched}
+	if err := srv.tmpl.ExecuteTemplate(&b, "layout", data); err != nil {
+		t.Fatalf("ExecuteTemplate: %v", err)
+	}
+	for _, want := range []string{"Schedule preview", "America/Los_Angeles", "solar noon", `formaction="/preview"`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("preview page missing %q", want)
+		}
+	}
+}
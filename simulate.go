@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxSimulateDays = 366 // sunrise-sunset.org is queried once per day simulated
+
+// simClock is the Clock of a simulation, set to each capture time in turn.
+// Nothing waits for it: After moves it forward at once.
+type simClock struct {
+	now time.Time
+}
+
+// Now implements Clock
+func (c *simClock) Now() time.Time {
+	return c.now
+}
+
+// After implements Clock
+func (c *simClock) After(d time.Duration) <-chan time.Time {
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// onceTimeZone is a TimeZoneProvider looking up the time zone once, as the
+// location of a simulated webcam doesn't move from day to day
+type onceTimeZone struct {
+	provider TimeZoneProvider
+	zone     string
+}
+
+// TimeZone implements TimeZoneProvider
+func (tz *onceTimeZone) TimeZone(lat, lng float64) (string, error) {
+	if tz.zone == "" {
+		zone, err := tz.provider.TimeZone(lat, lng)
+		if err != nil {
+			return "", err
+		}
+		tz.zone = zone
+	}
+	return tz.zone, nil
+}
+
+// Simulate replays the scheduling of the capture go routine over the
+// webcam's days in [from, to), without capturing: SetCaptureTimes and UpdateNextCapture
+// are run against a simulated clock, moved to each capture time in turn.
+// The days of the resulting schedule flag their anomalies, e.g., capture
+// times falling back for a polar or short day, duplicate or skipped capture
+// times, and days without captures. tld is not changed.
+func (tld *TLDef) Simulate(from, to time.Time) (*Schedule, error) {
+	sn := "TLDef.Simulate"
+
+	// use a copy, so the capture times of a running capture are not disturbed
+	env := tld.env()
+	clock := &simClock{now: from}
+	probe := *tld
+	probe.providers = &Providers{Clock: clock, Solar: env.Solar, TimeZones: &onceTimeZone{provider: env.TimeZones}, Local: env.Local, Lookups: env.Lookups}
+	probe.CaptureTimes = []time.Time{}
+	probe.NextCapture = 0
+	if err := probe.SetWebcamTZ(); err != nil {
+		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+		return nil, err
+	}
+	from, to = probe.webcamDate(from), probe.webcamDate(to) // the webcam's days
+	clock.now = from
+	err := probe.SetCaptureTimes(from)
+	if err == nil {
+		err = probe.UpdateNextCapture(from)
+	}
+	if err = probe.skipPast(err, clock, to); err != nil {
+		log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+		return nil, err
+	}
+
+	serverTZ, _ := from.In(env.Local).Zone()
+	sched := &Schedule{Name: tld.Name, WebcamTZ: probe.WebcamTZ, ServerTZ: serverTZ, Days: []ScheduleDay{}}
+
+	date := from // the next date expected to be scheduled
+	missed := func(until string) {
+		for ; date.Before(to) && date.Format(dateLayout) < until; date = date.AddDate(0, 0, 1) {
+			sched.Days = append(sched.Days, probe.missedDay(date))
+		}
+	}
+
+	var day *ScheduleDay
+	scheduled := 0 // capture times of day in [from, to)
+	finish := func() {
+		if day == nil {
+			return
+		}
+		if skipped := scheduled - len(day.Captures); skipped > 0 {
+			day.Anomalies = append(day.Anomalies, fmt.Sprintf("%d of %d scheduled captures skipped", skipped, scheduled))
+		}
+		sched.Days = append(sched.Days, *day)
+	}
+
+	for probe.NextCapture < len(probe.CaptureTimes) {
+		next := probe.NextCaptureTime()
+		if !next.Before(to) {
+			break
+		}
+
+		if day == nil || !probe.SolarNoonUTC.Equal(day.SolarNoon) { // CaptureTimes set for another day
+			finish()
+			sd := probe.scheduleDay()
+			missed(sd.Date)
+			if date.Format(dateLayout) == sd.Date {
+				date = date.AddDate(0, 0, 1)
+			}
+			day = &sd
+			scheduled = 0
+			for _, ct := range probe.CaptureTimes {
+				if !ct.Before(from) && ct.Before(to) {
+					scheduled++
+				}
+			}
+		}
+
+		webcam := next.In(probe.WebcamLoc)
+		day.Captures = append(day.Captures, ScheduledCapture{
+			Slot:   probe.SlotLabel(probe.NextCapture),
+			Webcam: webcam,
+			Server: next.In(env.Local),
+		})
+		if d := webcam.Format(dateLayout); d != day.Date {
+			day.Anomalies = append(day.Anomalies, fmt.Sprintf("%s capture at %s falls on %s",
+				probe.SlotLabel(probe.NextCapture), webcam.Format("15:04 MST"), d))
+		}
+
+		clock.now = next.Add(time.Second) // the capture go routine polls after the capture time
+		if err = probe.skipPast(probe.UpdateNextCapture(clock.now), clock, to); err != nil {
+			break
+		}
+	}
+	finish()
+
+	if err != nil { // UpdateNextCapture couldn't set the following day's
+		err = fmt.Errorf("%s, %s: no capture times after %s: %w", sn, tld.Name, clock.now.Format(time.RFC3339), err)
+		log.Printf("%v\n", err)
+		return nil, err
+	}
+	missed(to.Format(dateLayout))
+
+	return sched, nil
+}
+
+// skipPast continues after err, from SetCaptureTimes or UpdateNextCapture,
+// reports days the fallback policy skips: as the capture go routine does,
+// the capture times are set from the first day not looked at, until there
+// are some. The error setting them is returned, nil once the days skipped
+// reach to, without capture times.
+func (tld *TLDef) skipPast(err error, clock *simClock, to time.Time) error {
+	var skipped *noCapturesError
+	for errors.As(err, &skipped) {
+		if !skipped.Until.Before(to) {
+			return nil
+		}
+		clock.now = skipped.Until
+		err = tld.SetCaptureTimes(skipped.Until)
+	}
+	return err
+}
+
+// scheduleDay returns the ScheduleDay of the CaptureTimes, dated by solar
+// noon in the webcam's time zone, with its anomalies but no captures yet
+func (tld *TLDef) scheduleDay() ScheduleDay {
+	return ScheduleDay{
+		Date:      tld.SolarNoonUTC.In(tld.WebcamLoc).Format(dateLayout),
+		Sunrise:   tld.SunriseUTC.In(tld.WebcamLoc),
+		SolarNoon: tld.SolarNoonUTC.In(tld.WebcamLoc),
+		Sunset:    tld.SunsetUTC.In(tld.WebcamLoc),
+		Captures:  []ScheduledCapture{},
+		Anomalies: tld.dayAnomalies(),
+	}
+}
+
+// missedDay returns the ScheduleDay of a date without captures, with the
+// solar times and the anomalies of its schedule
+func (tld TLDef) missedDay(date time.Time) ScheduleDay {
+	sd := ScheduleDay{Date: date.Format(dateLayout), Captures: []ScheduledCapture{}}
+	tld.CaptureTimes = []time.Time{}
+	if err := tld.SetDayCaptureTimes(date); err == nil {
+		sd.Sunrise = tld.SunriseUTC.In(tld.WebcamLoc)
+		sd.SolarNoon = tld.SolarNoonUTC.In(tld.WebcamLoc)
+		sd.Sunset = tld.SunsetUTC.In(tld.WebcamLoc)
+		sd.Anomalies = tld.dayAnomalies()
+	}
+	sd.Anomalies = append(sd.Anomalies, "no captures")
+	return sd
+}
+
+// dayAnomalies describes what is wrong with the (sorted) CaptureTimes of a
+// day: why they fall back, see SetDayCaptureTimes, and duplicate capture
+// times
+func (tld *TLDef) dayAnomalies() []string {
+	var anomalies []string
+	if tld.warning != "" {
+		anomalies = append(anomalies, tld.warning)
+	}
+
+	for i := 1; i < len(tld.CaptureTimes); i++ {
+		if tld.CaptureTimes[i].Equal(tld.CaptureTimes[i-1]) {
+			anomalies = append(anomalies, fmt.Sprintf("%s and %s both at %s", tld.SlotLabel(i-1), tld.SlotLabel(i),
+				tld.CaptureTimes[i].In(tld.WebcamLoc).Format("15:04 MST")))
+		}
+	}
+	return anomalies
+}
+
+// checkSimulateDays rejects ranges longer than maxSimulateDays
+func checkSimulateDays(from, to time.Time) error {
+	if to.After(from.AddDate(0, 0, maxSimulateDays)) {
+		return fmt.Errorf("simulate at most %d days", maxSimulateDays)
+	}
+	return nil
+}
+
+// writeScheduleCSV writes sched as CSV, a row per capture time, a day
+// without captures having a row without them. The anomalies of a day are
+// in its first row.
+func writeScheduleCSV(w io.Writer, sched *Schedule) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "slot", "webcam", "server", "anomalies"})
+	for _, day := range sched.Days {
+		anomalies := strings.Join(day.Anomalies, "; ")
+		if len(day.Captures) == 0 {
+			cw.Write([]string{day.Date, "", "", "", anomalies})
+			continue
+		}
+		for _, c := range day.Captures {
+			cw.Write([]string{day.Date, c.Slot, c.Webcam.Format(time.RFC3339), c.Server.Format(time.RFC3339), anomalies})
+			anomalies = ""
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleSimulate is the handler for "/webcams/:name/simulate.json" and
+// "/webcams/:name/simulate.csv", replaying the webcam's schedule over the
+// "date", or "from" and "to" range. Nothing is captured.
+func (s *server) handleSimulate(format string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleSimulate"
+
+		tld, from, to, ok := s.webcamAndRange(w, r, p)
+		if !ok {
+			return
+		}
+		if err := checkSimulateDays(from, to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sched, err := tld.Simulate(from, to)
+		if err != nil {
+			log.Printf("%s, %v\n", sn, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if format == "json" {
+			writeJSON(w, http.StatusOK, sched)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		if err := writeScheduleCSV(w, sched); err != nil {
+			log.Printf("%s, writeScheduleCSV: %v\n", sn, err)
+		}
+	}
+}
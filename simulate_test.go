@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solarHours is a fakeSolar with the sun rising, culminating and setting
+// at these hours of the day where the code is running, e.g., 28 for 4:00
+// the following day
+func solarHours(rise, noon, set int) fakeSolar {
+	return func(date time.Time) (SolarTimes, error) {
+		at := func(hour int) time.Time {
+			return time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, srv.providers.Local).UTC()
+		}
+		return SolarTimes{Sunrise: at(rise), SolarNoon: at(noon), Sunset: at(set)}, nil
+	}
+}
+
+// newSimulateTLD returns a definition scheduled with solar, in the time
+// zone where the code is running
+func newSimulateTLD(solar fakeSolar) *TLDef {
+	tld := newBaseTLD()
+	tld.Name = "test simulate"
+	tld.CaptureTimes = CaptureTimes{}
+	tld.providers = newFakeProviders(newFakeClock(time.Now()), &fakeImages{})
+	tld.providers.Solar = solar
+	tld.providers.TimeZones = fakeTimeZone(srv.providers.Local.String())
+	return &tld
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func TestTLDef_Simulate(t *testing.T) {
+	from := time.Date(2020, 6, 1, 0, 0, 0, 0, srv.providers.Local)
+	to := from.AddDate(0, 0, 3)
+
+	type day struct {
+		date      string
+		captures  int
+		anomalies int
+	}
+	tests := []struct {
+		name        string
+		solar       fakeSolar
+		first, last uint
+		want        []day
+		wantAnomaly string
+	}{
+		{"regular", fakeSolar(fakeSolarTimes), firstSunrise, lastSunset,
+			[]day{{"2020-06-01", 3, 0}, {"2020-06-02", 3, 0}, {"2020-06-03", 3, 0}}, ""},
+		{"short day", solarHours(11, 12, 13), firstSunrise60, lastSunset60,
+			[]day{{"2020-06-01", 1, 1}, {"2020-06-02", 1, 1}, {"2020-06-03", 1, 1}}, "falling back to solar noon"},
+		{"sunset after midnight", solarHours(16, 22, 28), firstSunrise, lastSunset,
+			[]day{{"2020-06-01", 3, 1}, {"2020-06-02", 0, 1}, {"2020-06-03", 2, 0}}, "no captures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := newSimulateTLD(tt.solar)
+			tld.FirstFlags, tld.LastFlags = tt.first, tt.last
+
+			sched, err := tld.Simulate(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sched.Days) != len(tt.want) {
+				t.Fatalf("Simulate() got %d days %+v, want %d", len(sched.Days), sched.Days, len(tt.want))
+			}
+			var anomalies []string
+			for i, d := range sched.Days {
+				got := day{d.Date, len(d.Captures), len(d.Anomalies)}
+				if got != tt.want[i] {
+					t.Errorf("Simulate() day %d got %+v %q, want %+v", i, got, d.Anomalies, tt.want[i])
+				}
+				anomalies = append(anomalies, d.Anomalies...)
+			}
+			if tt.wantAnomaly != "" && !strings.Contains(strings.Join(anomalies, "\n"), tt.wantAnomaly) {
+				t.Errorf("Simulate() anomalies %q, want %q", anomalies, tt.wantAnomaly)
+			}
+			if len(tld.CaptureTimes) != 0 {
+				t.Errorf("Simulate() changed CaptureTimes to %v", tld.CaptureTimes)
+			}
+		})
+	}
+}
+
+func Test_writeScheduleCSV(t *testing.T) {
+	at := time.Date(2020, 6, 1, 6, 0, 0, 0, time.UTC)
+	sched := &Schedule{Days: []ScheduleDay{
+		{Date: "2020-06-01", Captures: []ScheduledCapture{
+			{Slot: "sunrise", Webcam: at, Server: at},
+			{Slot: "sunset", Webcam: at.Add(12 * time.Hour), Server: at.Add(12 * time.Hour)},
+		}, Anomalies: []string{"one", "two"}},
+		{Date: "2020-06-02", Captures: []ScheduledCapture{}, Anomalies: []string{"no captures"}},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeScheduleCSV(&buf, sched); err != nil {
+		t.Fatal(err)
+	}
+	want := "date,slot,webcam,server,anomalies\n" +
+		"2020-06-01,sunrise,2020-06-01T06:00:00Z,2020-06-01T06:00:00Z,one; two\n" +
+		"2020-06-01,sunset,2020-06-01T18:00:00Z,2020-06-01T18:00:00Z,\n" +
+		"2020-06-02,,,,no captures\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeScheduleCSV() got\n%s, want\n%s", got, want)
+	}
+}
+
+func Test_handleSimulate(t *testing.T) {
+	tld := newSimulateTLD(fakeSolar(fakeSolarTimes))
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		srv.mu.Unlock()
+	}()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"csv", "/webcams/test%20simulate/simulate.csv?from=2020-06-01&to=2020-06-02", http.StatusOK, "date,slot,webcam,server,anomalies\n2020-06-01,sunrise,"},
+		{"json", "/webcams/test%20simulate/simulate.json?date=2020-06-01", http.StatusOK, `"date":"2020-06-01"`},
+		{"too long", "/webcams/test%20simulate/simulate.csv?from=2020-01-01&to=2021-06-01", http.StatusBadRequest, "at most"},
+		{"not found", "/webcams/nope/simulate.json", http.StatusNotFound, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus || !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("%s got %d %q, want %d containing %q", tt.path, rr.Code, rr.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			if tt.name == "json" {
+				var sched Schedule
+				if err := json.Unmarshal(rr.Body.Bytes(), &sched); err != nil || len(sched.Days) != 1 || len(sched.Days[0].Captures) != 3 {
+					t.Errorf("%s got %+v, %v, want 1 day of 3 captures", tt.path, sched, err)
+				}
+			}
+		})
+	}
+}
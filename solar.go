@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// SunElevation returns the approximate elevation of the sun in degrees
+// above the horizon at the specified time and latitude/longitude, using
+// the low-precision formulas of the Astronomical Almanac (accurate to
+// better than 1 degree, plenty for labelling captures)
+func SunElevation(t time.Time, latitude, longitude float64) float64 {
+	const rad = math.Pi / 180
+
+	n := float64(t.UTC().Unix())/86400 + 2440587.5 - 2451545.0 // days since J2000.0
+
+	meanLong := math.Mod(280.460+0.9856474*n, 360)
+	meanAnomaly := math.Mod(357.528+0.9856003*n, 360) * rad
+	eclipticLong := (meanLong + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly)) * rad
+	obliquity := (23.439 - 0.0000004*n) * rad
+
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLong), math.Cos(eclipticLong))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLong))
+
+	gmst := math.Mod(18.697374558+24.06570982441908*n, 24) // Greenwich mean sidereal time, hours
+	hourAngle := (gmst*15+longitude)*rad - rightAscension
+
+	lat := latitude * rad
+	elevation := math.Asin(math.Sin(lat)*math.Sin(declination) + math.Cos(lat)*math.Cos(declination)*math.Cos(hourAngle))
+	return elevation / rad
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFile = "state.json" // runtime state of the webcams, next to masterFile, see statePath
+
+// savedState is the content of the state file
+type savedState struct {
+	Saved   time.Time               `json:"saved"`
+	Webcams map[string]webcamStatus `json:"webcams"` // by webcam name
+}
+
+// statePath returns the path of the state file, in the configured folder
+func (s *server) statePath() string {
+	return filepath.Join(s.config.path, stateFile)
+}
+
+// saveState writes the status of the running webcams to the state file,
+// along with the restored state of webcams not started since. The file is
+// replaced atomically, so a crash never leaves it truncated.
+func (s *server) saveState() error {
+	sn := "saveState"
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state := savedState{Saved: s.providers.Clock.Now(), Webcams: make(map[string]webcamStatus)}
+	s.mu.Lock()
+	for name, st := range s.restored {
+		state.Webcams[name] = st
+	}
+	for tld, st := range s.status {
+		state.Webcams[tld.Name] = *st
+	}
+	s.mu.Unlock()
+
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Printf("%s, json.Marshal: %v\n", sn, err)
+		return err
+	}
+
+	path := s.statePath()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".state-*")
+	if err != nil {
+		log.Printf("%s, ioutil.TempFile: %v\n", sn, err)
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Printf("%s, %v\n", sn, err)
+		return err
+	}
+	return nil
+}
+
+// loadState reads the state file saved by a previous run, for restoreState.
+// A missing state file is not an error.
+func (s *server) loadState() error {
+	sn := "loadState"
+
+	path := s.statePath()
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("%s, ioutil.ReadFile: %v\n", sn, err)
+		return err
+	}
+
+	var state savedState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		log.Printf("%s, json.Unmarshal: %v\n", sn, err)
+		return err
+	}
+
+	s.mu.Lock()
+	s.restored = state.Webcams
+	s.mu.Unlock()
+	log.Printf("%s, %s, %d webcams saved %s\n", sn, path, len(state.Webcams), state.Saved.Format(time.RFC3339))
+	return nil
+}
+
+// restoreState resumes the saved state of a webcam whose capture go routine
+// just set the capture times of the day. The outcome of its last captures
+// is always restored. If the saved schedule is of the same day, it is
+// resumed along with the slots already captured, and a slot that failed is
+// retried with the saved backoff, even if later slots are due. restoreState
+// returns whether such a retry is pending; otherwise the caller picks the
+// next capture, see catchUp.
+func (s *server) restoreState(tld *TLDef) (retrying bool) {
+	sn := fmt.Sprintf("restoreState.%s", tld.Name)
+
+	s.mu.Lock()
+	saved, ok := s.restored[tld.Name]
+	delete(s.restored, tld.Name)
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	resume := len(saved.CaptureTimes) > 0 && len(tld.CaptureTimes) > 0 &&
+		tld.dayOf(saved.CaptureTimes[0]) == tld.dayOf(tld.CaptureTimes[0])
+	retry := -1 // index of the slot to retry
+	if resume && saved.Failures > 0 && !capturedAt(saved.Captured, saved.NextCapture) {
+		for i, t := range saved.CaptureTimes {
+			if t.Equal(saved.NextCapture) {
+				retry = i
+			}
+		}
+	}
+
+	s.updateStatus(tld, func(st *webcamStatus) {
+		st.LastSuccess, st.LastFile = saved.LastSuccess, saved.LastFile
+		st.LastFailure, st.LastError = saved.LastFailure, saved.LastError
+		if resume {
+			st.Captured = saved.Captured
+		}
+		if retry >= 0 {
+			st.Failures, st.Backoff = saved.Failures, saved.Backoff
+		}
+	})
+	if !resume {
+		return false
+	}
+
+	tld.CaptureTimes = append(CaptureTimes{}, saved.CaptureTimes...)
+	tld.SunriseUTC, tld.SolarNoonUTC, tld.SunsetUTC = saved.SunriseUTC, saved.SolarNoonUTC, saved.SunsetUTC
+	if retry < 0 {
+		log.Printf("%s, resumed schedule of %s, %d captured\n", sn, tld.dayOf(saved.CaptureTimes[0]), len(saved.Captured))
+		return false
+	}
+	tld.NextCapture, tld.Backoff = retry, saved.Backoff
+	log.Printf("%s, resumed schedule of %s, %d captured, retrying %s after %d failures\n",
+		sn, tld.dayOf(saved.CaptureTimes[0]), len(saved.Captured), saved.NextCapture.In(s.providers.Local).Format(time.Kitchen), saved.Failures)
+	return true
+}
+
+// capturedAt reports whether captured includes slot t
+func capturedAt(captured []time.Time, t time.Time) bool {
+	for _, c := range captured {
+		if c.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// shutdown stops the server gracefully, within timeout: the capture go
+// routines stop scheduling and event streams end, requests in flight are
+// completed and captures in flight may finish. Downloads still running at
+// the deadline are aborted, leaving no partial frames. Finally the state
+// is saved.
+func (s *server) shutdown(hs *http.Server, timeout time.Duration) {
+	sn := "shutdown"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		log.Printf("%s, http.Server.Shutdown: %v\n", sn, err)
+		hs.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Printf("%s, captures still in flight after %s, aborting them\n", sn, timeout)
+		s.abort()
+		<-drained
+	}
+
+	if err := s.saveState(); err == nil {
+		log.Printf("%s, state saved to %s\n", sn, s.statePath())
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStallingServer serves the first half of an image, then stalls until
+// the request is cancelled or the server closed
+func newStallingServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2048")
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+// folderEntries returns the names in dir
+func folderEntries(t *testing.T, dir string) []string {
+	t.Helper()
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range infos {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func Test_server_shutdown(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.CaptureTimes = CaptureTimes{sunrise}
+	stalling := newStallingServer()
+	defer stalling.Close()
+	tld.URL = stalling.URL
+
+	s := newServerFor(&Config{path: srv.config.path})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.status[tld] = &webcamStatus{Running: true, Failures: 2}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: http.NotFoundHandler()}
+	go hs.Serve(ln)
+
+	// a capture in flight, as the capture go routine runs it
+	captured := make(chan error, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		_, _, err := tld.CaptureImage(s.drain)
+		captured <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	statePath := filepath.Join(srv.config.path, stateFile)
+	defer os.Remove(statePath)
+	start := time.Now()
+	s.shutdown(hs, 300*time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+	if err := <-captured; err == nil {
+		t.Errorf("shutdown didn't abort the capture in flight")
+	}
+	if names := folderEntries(t, tld.FolderPath); len(names) != 0 {
+		t.Errorf("shutdown left %v", names)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Errorf("shutdown left the HTTP server running")
+	}
+
+	data, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil || state.Webcams[tld.Name].Failures != 2 {
+		t.Errorf("shutdown saved %s, %v", data, err)
+	}
+}
+
+func Test_server_loadState(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	statePath := filepath.Join(srv.config.path, stateFile)
+	defer os.Remove(statePath)
+
+	slot := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := newServerFor(&Config{path: srv.config.path})
+	s.status[tld] = &webcamStatus{Running: true, NextCapture: slot, CaptureTimes: []time.Time{slot}, Failures: 1, Backoff: 2}
+	s.restored = map[string]webcamStatus{"not started": {LastError: "folder missing"}}
+	if err := s.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newServerFor(&Config{path: srv.config.path})
+	if err := restarted.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	got := restarted.restored[tld.Name]
+	if !got.NextCapture.Equal(slot) || len(got.CaptureTimes) != 1 || got.Failures != 1 || got.Backoff != 2 || got.Running {
+		t.Errorf("loadState restored %+v", got)
+	}
+	if got := restarted.restored["not started"]; got.LastError != "folder missing" {
+		t.Errorf("loadState restored %+v for a webcam not started", got)
+	}
+
+	os.Remove(statePath)
+	if err := restarted.loadState(); err != nil {
+		t.Errorf("loadState without a state file: %v", err)
+	}
+}
+
+func Test_server_restoreState(t *testing.T) {
+	s := newServerFor(&Config{path: srv.config.path})
+	at := func(day, hour int) time.Time {
+		return time.Date(2020, 6, day, hour, 0, 0, 0, s.providers.Local)
+	}
+	today := []time.Time{at(1, 8), at(1, 12), at(1, 16)}
+	tomorrow := CaptureTimes{at(2, 8), at(2, 12), at(2, 16)}
+
+	tests := []struct {
+		name        string
+		schedule    CaptureTimes // set at startup
+		saved       webcamStatus
+		wantRetry   bool
+		wantNext    int
+		wantDay     int // of the resulting schedule
+		wantBackoff int64
+		wantFails   int
+		wantCapture int // slots captured
+	}{
+		{"retry failed slot", CaptureTimes(today), webcamStatus{CaptureTimes: today, NextCapture: at(1, 12), Captured: today[:1], Failures: 3, Backoff: 8}, true, 1, 1, 8, 3, 1},
+		{"retry last slot", CaptureTimes(today), webcamStatus{CaptureTimes: today, NextCapture: at(1, 16), Captured: today[:2], Failures: 5, Backoff: 32}, true, 2, 1, 32, 5, 2},
+		{"resume schedule", CaptureTimes(today), webcamStatus{CaptureTimes: today, NextCapture: at(1, 16), Captured: today[:2]}, false, 0, 1, 0, 0, 2},
+		{"failed slot captured since", CaptureTimes(today), webcamStatus{CaptureTimes: today, NextCapture: at(1, 12), Captured: today[:2], Failures: 1, Backoff: 1}, false, 0, 1, 0, 0, 2},
+		{"another day", tomorrow, webcamStatus{CaptureTimes: today, NextCapture: at(1, 12), Captured: today[:1], Failures: 2, Backoff: 4}, false, 0, 2, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := &TLDef{Name: "restored"}
+			tld.CaptureTimes = append(CaptureTimes{}, tt.schedule...)
+			tt.saved.LastFile = "/frames/last.jpg"
+			s.status = map[*TLDef]*webcamStatus{tld: {Running: true}}
+			s.restored = map[string]webcamStatus{tld.Name: tt.saved}
+
+			retrying := s.restoreState(tld)
+
+			st := s.status[tld]
+			if retrying != tt.wantRetry || tld.NextCapture != tt.wantNext || tld.CaptureTimes[0].Day() != tt.wantDay || tld.Backoff != tt.wantBackoff {
+				t.Errorf("restoreState %t, NextCapture %d of %v, Backoff %d, want %t, %d of day %d, %d",
+					retrying, tld.NextCapture, tld.CaptureTimes, tld.Backoff, tt.wantRetry, tt.wantNext, tt.wantDay, tt.wantBackoff)
+			}
+			if st.Failures != tt.wantFails || st.Backoff != tt.wantBackoff || len(st.Captured) != tt.wantCapture {
+				t.Errorf("restoreState status %+v", st)
+			}
+			if st.LastFile != tt.saved.LastFile {
+				t.Errorf("restoreState LastFile %q, want %q", st.LastFile, tt.saved.LastFile)
+			}
+			if _, ok := s.restored[tld.Name]; ok {
+				t.Errorf("restoreState kept the saved state for another start")
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const maxLatestAge = time.Hour // longest time /latest may be cached
+
+// WebcamStatusResponse is the JSON body of "/webcams/:name/status"
+type WebcamStatusResponse struct {
+	Name         string      `json:"name"`
+	Paused       bool        `json:"paused"`
+	Running      bool        `json:"running"`
+	NextCapture  *time.Time  `json:"nextCapture,omitempty"`
+	LastSuccess  *time.Time  `json:"lastSuccess,omitempty"`
+	LastFile     string      `json:"lastFile,omitempty"`
+	LastFailure  *time.Time  `json:"lastFailure,omitempty"`
+	LastError    string      `json:"lastError,omitempty"`
+	Failures     int         `json:"consecutiveFailures"`
+	Backoff      int64       `json:"backoffSeconds"`
+	CaptureTimes []time.Time `json:"captureTimes"`
+	Captured     []time.Time `json:"captured"`
+	Sunrise      *time.Time  `json:"sunrise,omitempty"`
+	SolarNoon    *time.Time  `json:"solarNoon,omitempty"`
+	Sunset       *time.Time  `json:"sunset,omitempty"`
+	Warning      string      `json:"warning,omitempty"`
+}
+
+// optionalTime returns nil for the zero time, so it is omitted from JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// latestFrame returns the path and time of the most recent successful
+// capture: the one recorded in st, or after a restart the newest non-empty
+// frame stored for the webcam. ok is false if there is none.
+func (tld *TLDef) latestFrame(st webcamStatus) (path string, at time.Time, ok bool) {
+	if st.LastFile != "" {
+		if _, err := os.Stat(st.LastFile); err == nil {
+			return st.LastFile, st.LastSuccess, true
+		}
+	}
+
+	frames, err := tld.allFrames()
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	for i := len(frames) - 1; i >= 0; i-- {
+		if fi, err := os.Stat(frames[i].Path); err == nil && fi.Size() > 0 {
+			return frames[i].Path, frames[i].Time, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// snapshot returns a webcam's paused flag and published status
+func (s *server) snapshot(tld *TLDef) (bool, webcamStatus) {
+	s.mu.Lock()
+	paused := tld.Paused
+	s.mu.Unlock()
+
+	return paused, s.webcamStatus(tld)
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// handleStatus is the handler for "/webcams/:name/status", reporting the
+// capture state of a webcam as JSON
+func (s *server) handleStatus() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		tld := s.findWebcam(p.ByName("name"))
+		if tld == nil {
+			writeAPIError(w, http.StatusNotFound, fmt.Errorf("webcam %q not found", p.ByName("name")))
+			return
+		}
+
+		paused, st := s.snapshot(tld)
+		resp := WebcamStatusResponse{
+			Name:         tld.Name,
+			Paused:       paused,
+			Running:      st.Running,
+			NextCapture:  optionalTime(st.NextCapture),
+			LastFailure:  optionalTime(st.LastFailure),
+			LastError:    st.LastError,
+			Failures:     st.Failures,
+			Backoff:      st.Backoff,
+			CaptureTimes: st.CaptureTimes,
+			Captured:     st.Captured,
+			Sunrise:      optionalTime(st.SunriseUTC),
+			SolarNoon:    optionalTime(st.SolarNoonUTC),
+			Sunset:       optionalTime(st.SunsetUTC),
+			Warning:      st.Warning,
+		}
+		if resp.CaptureTimes == nil {
+			resp.CaptureTimes = []time.Time{}
+		}
+		if resp.Captured == nil {
+			resp.Captured = []time.Time{}
+		}
+		if path, at, ok := tld.latestFrame(st); ok {
+			resp.LastSuccess, resp.LastFile = optionalTime(at), filepath.Base(path)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// handleLatest is the handler for "/webcams/:name/latest", serving the
+// most recent successful capture, or with query parameter "variant" its
+// "captioned" or "original" version. Responses carry an ETag and may be
+// cached until the next capture, only by the browser when sign in is
+// enabled.
+func (s *server) handleLatest() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleLatest"
+
+		tld := s.findWebcam(p.ByName("name"))
+		if tld == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, st := s.snapshot(tld)
+		latest, _, ok := tld.latestFrame(st)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no captures for %s", tld.Name), http.StatusNotFound)
+			return
+		}
+		path, err := tld.FramePath(filepath.Base(latest), r.URL.Query().Get("variant"))
+		if err != nil {
+			log.Printf("%s, %s: %v\n", sn, tld.Name, err)
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("%s, %s os.Open: %v\n", sn, tld.Name, err)
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Printf("%s, %s Stat: %v\n", sn, tld.Name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		maxAge := time.Duration(0)
+		if !st.NextCapture.IsZero() {
+			maxAge = time.Until(st.NextCapture)
+		}
+		if maxAge < 0 {
+			maxAge = 0
+		} else if maxAge > maxLatestAge {
+			maxAge = maxLatestAge
+		}
+		scope := "public"
+		if s.auth != nil { // shared caches must not hand a protected image to anyone
+			scope = "private"
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge/time.Second)))
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f) // handles If-None-Match and If-Modified-Since
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLDef_AdjustBackoff(t *testing.T) {
+	tld := &TLDef{}
+	want := []int64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 600, 600}
+	for i, w := range want {
+		tld.AdjustBackoff()
+		if tld.Backoff != w {
+			t.Fatalf("TLDef.AdjustBackoff() call %d got %d, want %d", i+1, tld.Backoff, w)
+		}
+	}
+}
+
+func Test_server_handleStatus(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	times := writeGalleryFrames(t, tld)
+	name := url.PathEscape(tld.Name)
+
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.status[tld] = &webcamStatus{Running: true}
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		delete(srv.status, tld)
+		srv.mu.Unlock()
+	}()
+
+	tld.CaptureTimes = CaptureTimes{times[3].AddDate(0, 0, 1), times[3].AddDate(0, 0, 1).Add(time.Hour)}
+	tld.SunriseUTC = tld.CaptureTimes[0].UTC()
+	srv.publishNextCapture(tld)
+	tld.Backoff = 4
+	srv.captureFailed(tld, errors.New("webcam offline"))
+
+	req, err := http.NewRequest("GET", "/webcams/"+name+"/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	srv.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handleStatus got %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var got WebcamStatusResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Running || got.Failures != 1 || got.Backoff != 4 || got.LastError != "webcam offline" {
+		t.Errorf("handleStatus got running %t failures %d backoff %d error %q", got.Running, got.Failures, got.Backoff, got.LastError)
+	}
+	if len(got.CaptureTimes) != 2 || got.NextCapture == nil || got.Sunrise == nil {
+		t.Errorf("handleStatus got schedule %v next %v sunrise %v", got.CaptureTimes, got.NextCapture, got.Sunrise)
+	}
+	wantFile := tld.Name + " " + times[3].Format(fileTimeLayout) // newest frame on disk
+	if got.LastFile != wantFile || got.LastSuccess == nil {
+		t.Errorf("handleStatus got last file %q, want %q", got.LastFile, wantFile)
+	}
+
+	req, _ = http.NewRequest("GET", "/webcams/nosuch/status", nil)
+	rr = httptest.NewRecorder()
+	srv.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handleStatus unknown webcam got %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func Test_server_handleLatest(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	times := writeGalleryFrames(t, tld)
+	name := url.PathEscape(tld.Name)
+
+	srv.mu.Lock()
+	srv.mtld.Append(tld)
+	srv.status[tld] = &webcamStatus{Running: true, NextCapture: time.Now().Add(10 * time.Minute)}
+	srv.mu.Unlock()
+	defer func() {
+		srv.mu.Lock()
+		srv.mtld.Remove(tld.Name)
+		delete(srv.status, tld)
+		srv.mu.Unlock()
+	}()
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/webcams/"+name+"/latest", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		srv.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("handleLatest got %d %q, want 200 image/png", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Errorf("handleLatest missing ETag")
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=599" && cc != "public, max-age=600" {
+		t.Errorf("handleLatest got Cache-Control %q, want max-age until the next capture", cc)
+	}
+
+	if rr := get(etag); rr.Code != http.StatusNotModified {
+		t.Errorf("handleLatest with If-None-Match got %d, want %d", rr.Code, http.StatusNotModified)
+	}
+
+	srv.auth = newTestAuth(t) // the router is not wrapped by authorize
+	rr = get("")
+	srv.auth = nil
+	if cc := rr.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private, ") {
+		t.Errorf("handleLatest with sign in got Cache-Control %q, want private", cc)
+	}
+
+	// a capture recorded by the capture go routine takes precedence
+	first := writeTestFrame(t, tld, times[0], 32, 32, color.Black)
+	srv.captureSucceeded(tld, first, 0)
+	if rr := get(etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("handleLatest after capture got %d ETag %q, want a new image", rr.Code, rr.Header().Get("ETag"))
+	}
+}
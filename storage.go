@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var (
+	errFolderRequired = errors.New("Folder path is required")
+	errOutsideRoots   = errors.New("Folder path is outside the storage roots")
+)
+
+// parseRoots splits a list of storage roots separated by
+// os.PathListSeparator, like $PATH, into cleaned absolute paths
+func parseRoots(list string) ([]string, error) {
+	var roots []string
+	for _, root := range filepath.SplitList(list) {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, abs)
+	}
+	return roots, nil
+}
+
+// defaultRoot returns the storage root folders are derived under, "" if
+// no roots are configured
+func (c *Config) defaultRoot() string {
+	if len(c.roots) == 0 {
+		return ""
+	}
+	return c.roots[0]
+}
+
+// resolveFolder validates and normalises tld.FolderPath. Without storage
+// roots any folder is accepted, made absolute. With roots, the folder must
+// be inside one of them, after resolving "..", and symlinks of the parts
+// that already exist. An empty folder is derived from the webcam name
+// under the default root, and a relative folder is taken as relative to it.
+func (c *Config) resolveFolder(tld *TLDef) error {
+	folder := strings.TrimSpace(tld.FolderPath)
+	if len(c.roots) == 0 {
+		if folder == "" {
+			return errFolderRequired
+		}
+		abs, err := filepath.Abs(folder)
+		if err != nil {
+			return err
+		}
+		tld.FolderPath = abs
+		return nil
+	}
+
+	switch {
+	case folder == "":
+		folder = filepath.Join(c.defaultRoot(), folderName(tld.Name))
+	case !filepath.IsAbs(folder):
+		folder = filepath.Join(c.defaultRoot(), folder)
+	default:
+		folder = filepath.Clean(folder)
+	}
+
+	resolved, err := resolveExisting(folder)
+	if err != nil {
+		return err
+	}
+	for _, root := range c.roots {
+		resolvedRoot, err := resolveExisting(root)
+		if err != nil {
+			return err
+		}
+		if within(root, folder) && within(resolvedRoot, resolved) {
+			tld.FolderPath = folder
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not inside %s", errOutsideRoots, folder, strings.Join(c.roots, ", "))
+}
+
+// within reports whether path is strictly inside root; both must be clean
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveExisting returns path with the symlinks in its longest existing
+// prefix resolved; the parts that don't exist yet are kept as they are
+func resolveExisting(path string) (string, error) {
+	rest := ""
+	for p := path; ; {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// folderName returns a folder name derived from a webcam name, keeping
+// letters, digits, spaces, '-', '_' and '.'
+func folderName(name string) string {
+	folder := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_.", r) {
+			return r
+		}
+		return '_'
+	}, name)
+	folder = strings.Trim(folder, " .")
+	if folder == "" {
+		return "webcam"
+	}
+	return folder
+}
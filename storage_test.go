@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfig_resolveFolder(t *testing.T) {
+	base, err := ioutil.TempDir("", "timelapse-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	base, _ = filepath.EvalSymlinks(base)
+
+	root := filepath.Join(base, "root")
+	other := filepath.Join(base, "other")
+	os.MkdirAll(filepath.Join(root, "existing"), 0755)
+	os.MkdirAll(other, 0755)
+	if err := os.Symlink(other, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Config{roots: []string{root}}
+	tests := []struct {
+		name    string
+		tld     TLDef
+		want    string
+		wantErr error
+	}{
+		{name: "inside root", tld: TLDef{Name: "cam", FolderPath: filepath.Join(root, "existing", "cam")}, want: filepath.Join(root, "existing", "cam")},
+		{name: "derived from name", tld: TLDef{Name: "Manzanita Lake/North"}, want: filepath.Join(root, "Manzanita Lake_North")},
+		{name: "relative", tld: TLDef{Name: "cam", FolderPath: "lake"}, want: filepath.Join(root, "lake")},
+		{name: "dot dot escape", tld: TLDef{Name: "cam", FolderPath: filepath.Join(root, "..", "other")}, wantErr: errOutsideRoots},
+		{name: "relative escape", tld: TLDef{Name: "cam", FolderPath: "../other"}, wantErr: errOutsideRoots},
+		{name: "root itself", tld: TLDef{Name: "cam", FolderPath: root}, wantErr: errOutsideRoots},
+		{name: "symlink escape", tld: TLDef{Name: "cam", FolderPath: filepath.Join(root, "escape", "cam")}, wantErr: errOutsideRoots},
+		{name: "elsewhere", tld: TLDef{Name: "cam", FolderPath: "/etc/cam"}, wantErr: errOutsideRoots},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.resolveFolder(&tt.tld)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Config.resolveFolder() error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && tt.tld.FolderPath != tt.want {
+				t.Errorf("Config.resolveFolder() got %q, want %q", tt.tld.FolderPath, tt.want)
+			}
+		})
+	}
+
+	unrestricted := &Config{}
+	if err := unrestricted.resolveFolder(&TLDef{Name: "cam"}); !errors.Is(err, errFolderRequired) {
+		t.Errorf("Config.resolveFolder() without roots or folder got %v, want %v", err, errFolderRequired)
+	}
+}
+
+func Test_server_handleNew_roots(t *testing.T) {
+	root, err := ioutil.TempDir("", "timelapse-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	srv.config.roots = []string{root}
+	defer func() { srv.config.roots = nil }()
+
+	form := url.Values{
+		"name":       {"testRoots"},
+		"webcamUrl":  {"https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg"},
+		"latitude":   {"40.437787"},
+		"longitude":  {"-121.5360307"},
+		"first":      {"sunrise"},
+		"last":       {"sunset"},
+		"additional": {"0"},
+		"folder":     {"/tmp/../etc/testRoots"},
+	}
+	req, err := http.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	srv.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "outside the storage roots") {
+		t.Errorf("handleNew folder outside roots got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "Leave empty for a folder named after the webcam in "+root) {
+		t.Errorf("handleNew want the default root in the folder help")
+	}
+	if _, err := os.Stat("/etc/testRoots"); !os.IsNotExist(err) {
+		t.Errorf("handleNew created /etc/testRoots")
+	}
+}
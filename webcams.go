@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	errWebcamNotFound = errors.New("webcam not found")
+	errWebcamExists   = errors.New("webcam already exists")
+)
+
+// webcamStatus is the runtime state of a webcam, published by its capture
+// go routine for the web UI and API, and saved in the state file
+type webcamStatus struct {
+	Running      bool        `json:"-"`            // capture go routine running
+	NextCapture  time.Time   `json:"nextCapture"`  // time of the next capture, zero while there is none, see scheduleRetry
+	CaptureTimes []time.Time `json:"captureTimes"` // capture times of the day being captured
+	Captured     []time.Time `json:"captured"`     // capture times of the day successfully captured
+	SunriseUTC   time.Time   `json:"sunrise"`      // solar times of the day being captured
+	SolarNoonUTC time.Time   `json:"solarNoon"`
+	SunsetUTC    time.Time   `json:"sunset"`
+	Warning      string      `json:"warning,omitempty"` // why the capture times of the day fall back, see SetDayCaptureTimes
+	LastSuccess  time.Time   `json:"lastSuccess"`       // time of the last successful capture
+	LastFile     string      `json:"lastFile"`          // path of the last successful capture
+	LastFailure  time.Time   `json:"lastFailure"`       // time of the last failed capture
+	LastError    string      `json:"lastError"`         // error of the last failed capture
+	Failures     int         `json:"failures"`          // consecutive failed captures
+	Backoff      int64       `json:"backoff"`           // seconds to wait before the next retrieval attempt
+	LastTick     time.Time   `json:"-"`                 // last loop of the capture go routine, see handleHealthz
+}
+
+// captureRun is a running capture go routine
+type captureRun struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the go routine exits
+}
+
+// startCapture launches the capture go routine for a webcam, with a
+// context that can be cancelled by stopCapture or by cancelling s.ctx. The
+// schedule of a webcam resumed after a pause is dropped, the go routine
+// sets the day's afresh.
+func (s *server) startCapture(tld *TLDef) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	run := &captureRun{cancel: cancel, done: make(chan struct{})}
+	if tld.providers == nil {
+		tld.providers = s.providers
+	}
+	tld.CaptureTimes, tld.NextCapture = []time.Time{}, 0
+
+	s.mu.Lock()
+	s.captures[tld] = run
+	s.status[tld] = &webcamStatus{Running: true, LastTick: tld.env().Clock.Now()}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer close(run.done)
+		s.capture(ctx, tld, s.config.pollSecs)
+	}()
+}
+
+// stopCapture cancels the capture go routine of a webcam, if running, and
+// waits for it to exit so tld can be safely reused
+func (s *server) stopCapture(tld *TLDef) {
+	s.mu.Lock()
+	run, ok := s.captures[tld]
+	delete(s.captures, tld)
+	delete(s.status, tld)
+	s.mu.Unlock()
+
+	if ok {
+		run.cancel()
+		<-run.done
+	}
+}
+
+// updateStatus applies update to the published status of a webcam
+func (s *server) updateStatus(tld *TLDef, update func(st *webcamStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.status[tld]; ok {
+		update(st)
+	}
+}
+
+// publishNextCapture records the next capture time of a webcam, and the
+// schedule it belongs to, in its status, and reports it as a "scheduled"
+// event, or "rescheduled" when the schedule moved to another day
+func (s *server) publishNextCapture(tld *TLDef) {
+	if !tld.scheduled() { // until the capture times are set again, see scheduleRetry
+		warning := tld.warning
+		s.updateStatus(tld, func(st *webcamStatus) {
+			st.NextCapture, st.Warning = time.Time{}, warning
+		})
+		return
+	}
+	next := tld.NextCaptureTime()
+	times := append([]time.Time{}, tld.CaptureTimes...) // the capture go routine owns tld
+	sunrise, noon, sunset, warning := tld.SunriseUTC, tld.SolarNoonUTC, tld.SunsetUTC, tld.warning
+	kind := eventScheduled
+	s.updateStatus(tld, func(st *webcamStatus) {
+		if len(st.CaptureTimes) > 0 && st.CaptureTimes[0].Format(dateLayout) != times[0].Format(dateLayout) {
+			kind = eventRescheduled
+			st.Captured = nil
+		}
+		st.NextCapture = next
+		st.CaptureTimes = times
+		st.SunriseUTC, st.SolarNoonUTC, st.SunsetUTC = sunrise, noon, sunset
+		st.Warning = warning
+	})
+	s.saveState()
+	s.events.publish(Event{Type: kind, Webcam: tld.Name, Next: &next})
+}
+
+// captureStarted reports a capture attempt, and how late it started
+func (s *server) captureStarted(tld *TLDef) {
+	var scheduled time.Time
+	if tld.NextCapture < len(tld.CaptureTimes) {
+		scheduled = tld.NextCaptureTime()
+	}
+	s.metrics.captureStarted(tld.Name, scheduled, tld.env().Clock.Now())
+	s.events.publish(Event{Type: eventStarted, Webcam: tld.Name})
+}
+
+// captureSucceeded records a successful capture of size bytes stored in path
+func (s *server) captureSucceeded(tld *TLDef, path string, size int64) {
+	now := tld.env().Clock.Now()
+	var slot time.Time
+	if tld.NextCapture < len(tld.CaptureTimes) {
+		slot = tld.NextCaptureTime()
+	}
+	s.updateStatus(tld, func(st *webcamStatus) {
+		st.LastSuccess, st.LastFile = now, path
+		st.Failures, st.Backoff = 0, 0
+		if !slot.IsZero() {
+			st.Captured = append(st.Captured, slot)
+		}
+	})
+	s.saveState()
+	s.events.publish(Event{Type: eventSucceeded, Webcam: tld.Name, Time: now, File: filepath.Base(path), Size: size})
+}
+
+// captureFailed records a failed capture and the resulting backoff
+func (s *server) captureFailed(tld *TLDef, err error) {
+	now, backoff := tld.env().Clock.Now(), tld.Backoff
+	s.updateStatus(tld, func(st *webcamStatus) {
+		st.LastFailure, st.LastError = now, err.Error()
+		st.Failures++
+		st.Backoff = backoff
+	})
+	s.saveState()
+	s.events.publish(Event{Type: eventFailed, Webcam: tld.Name, Time: now, Error: err.Error(), Backoff: backoff})
+}
+
+// webcamStatus returns a copy of the published status of a webcam
+func (s *server) webcamStatus(tld *TLDef) webcamStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.status[tld]; ok {
+		return *st
+	}
+	return webcamStatus{}
+}
+
+// addWebcam appends a validated definition, creates its folder, saves the
+// definitions and, unless paused, starts capturing
+func (s *server) addWebcam(tld *TLDef) error {
+	if err := s.saveWebcam(tld); err != nil {
+		return err
+	}
+
+	if !tld.Paused {
+		s.startCapture(tld)
+	}
+	return nil
+}
+
+// saveWebcam appends a validated definition, creates its folder and saves
+// the definitions, without starting to capture. A definition with the same
+// name is reported as errWebcamExists.
+func (s *server) saveWebcam(tld *TLDef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mtld.Find(tld.Name) != nil {
+		return fmt.Errorf("%q: %w", tld.Name, errWebcamExists)
+	}
+	if err := makeFolder(tld); err != nil {
+		return err
+	}
+	s.mtld.Append(tld)
+	err := s.mtld.Write(s.definitionsPath(), s.validate)
+	if err != nil {
+		*s.mtld = (*s.mtld)[:len(*s.mtld)-1]
+	}
+	return err
+}
+
+// updateWebcam replaces the named definition with a validated definition,
+// creates its folder, saves the definitions and restarts capturing, unless
+// paused, so the new schedule takes effect immediately
+func (s *server) updateWebcam(name string, tld *TLDef) error {
+	if err := makeFolder(tld); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if tld.Name != name && s.mtld.Find(tld.Name) != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%q: %w", tld.Name, errWebcamExists)
+	}
+	old := s.mtld.Find(name)
+	if old == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%q: %w", name, errWebcamNotFound)
+	}
+	s.mtld.Replace(name, tld)
+	err := s.mtld.Write(s.definitionsPath(), s.validate)
+	if err != nil {
+		s.mtld.Replace(tld.Name, old)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.stopCapture(old)
+	if !tld.Paused {
+		s.startCapture(tld)
+	}
+	return nil
+}
+
+// removeWebcam removes the named definition, saves the definitions and
+// stops capturing. Captured images are removed only if removeFrames is set.
+func (s *server) removeWebcam(name string, removeFrames bool) error {
+	sn := "removeWebcam"
+
+	s.mu.Lock()
+	old := s.mtld.Remove(name)
+	if old == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%q: %w", name, errWebcamNotFound)
+	}
+	err := s.mtld.Write(s.definitionsPath(), s.validate)
+	if err != nil {
+		s.mtld.Append(old)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.stopCapture(old)
+
+	if removeFrames {
+		n, err := old.RemoveFrames()
+		log.Printf("%s, %s removed %d captured images\n", sn, name, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeFolder creates the FolderPath directory, if it doesn't exist
+func makeFolder(tld *TLDef) error {
+	sn := "makeFolder"
+
+	if err := os.MkdirAll(tld.FolderPath, 0755); err != nil { // owner read/write/search, group/other read/search
+		log.Printf("%s, %s os.MkdirAll: %v\n", sn, tld.Name, err)
+		return err
+	}
+	return nil
+}
+
+// pauseWebcam stops or resumes capturing for the named webcam, keeping its
+// definition. The paused state is saved, so it survives restarts.
+func (s *server) pauseWebcam(name string, paused bool) error {
+	s.mu.Lock()
+	tld := s.mtld.Find(name)
+	if tld == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%q: %w", name, errWebcamNotFound)
+	}
+	if tld.Paused == paused {
+		s.mu.Unlock()
+		return nil
+	}
+	tld.Paused = paused
+	err := s.mtld.Write(s.definitionsPath(), s.validate)
+	if err != nil {
+		tld.Paused = !paused
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if paused {
+		s.stopCapture(tld)
+	} else {
+		s.startCapture(tld)
+	}
+	return nil
+}
+
+// RemoveFrames removes the images captured for this webcam from FolderPath,
+// with their captioned and original copies, returning the number removed.
+// Other files, and the folder itself, are left in place.
+func (tld *TLDef) RemoveFrames() (int, error) {
+	frames, err := tld.Frames(time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, frame := range frames {
+		if err := os.Remove(frame.Path); err != nil {
+			return n, err
+		}
+		n++
+		for _, sub := range []string{captionFolder, originalFolder} {
+			copy := filepath.Join(tld.FolderPath, sub, filepath.Base(frame.Path))
+			if err := os.Remove(copy); err != nil && !os.IsNotExist(err) {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// ********** ********** ********** ********** ********** **********
+
+// formPage returns the "layout" data for the form filled with tld, nil for
+// an empty form. For an existing definition, name is its current name, and
+// the form's actions apply to it.
+func formPage(tld *TLDef, name string) page {
+	data := page{
+		Company:       "Timelapse",
+		Form:          tld,
+		Action:        "/new",
+		PreviewAction: "/preview",
+		ProbeAction:   "/probe",
+	}
+	if name != "" {
+		base := "/webcams/" + url.PathEscape(name)
+		data.Editing = true
+		data.Action = base + "/edit"
+		data.PreviewAction = base + "/preview"
+		data.ProbeAction = base + "/probe"
+	}
+	return data
+}
+
+// handleEdit is the handler for GET "/webcams/:name/edit", rendering the
+// form pre-filled with the webcam's definition
+func (s *server) handleEdit() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		name := p.ByName("name")
+
+		s.mu.Lock()
+		tld := s.mtld.Find(name)
+		s.mu.Unlock()
+
+		if tld == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.render(w, r, http.StatusOK, formPage(tld, name))
+	}
+}
+
+// handleUpdate is the handler for POST "/webcams/:name/edit". Settings the
+// form doesn't show (transforms, caption, catch-up, paused) are carried over.
+func (s *server) handleUpdate() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleUpdate"
+		name := p.ByName("name")
+
+		tld, errs, err := s.formTLDef(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(errs) > 0 {
+			s.formInvalid(w, r, tld, name, r.Form, errs)
+			return
+		}
+
+		s.mu.Lock()
+		if old := s.mtld.Find(name); old != nil {
+			tld.Transforms = old.Transforms
+			tld.KeepOriginal = old.KeepOriginal
+			tld.Caption = old.Caption
+			tld.CatchUp = old.CatchUp
+			tld.Fallback = old.Fallback
+			tld.FileTime = old.FileTime
+			tld.Paused = old.Paused
+		}
+		s.mu.Unlock()
+
+		if err := s.updateWebcam(name, tld); err != nil {
+			log.Printf("%s, s.updateWebcam: %v\n", sn, err)
+			http.Error(w, err.Error(), webcamErrorStatus(err))
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// handlePause is the handler for POST "/webcams/:name/pause" (paused true)
+// and "/webcams/:name/resume" (paused false)
+func (s *server) handlePause(paused bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handlePause"
+
+		if err := s.pauseWebcam(p.ByName("name"), paused); err != nil {
+			log.Printf("%s, s.pauseWebcam: %v\n", sn, err)
+			http.Error(w, err.Error(), webcamErrorStatus(err))
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// handleDelete is the handler for POST "/webcams/:name/delete". Captured
+// images are kept unless form field "frames" is "delete".
+func (s *server) handleDelete() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		sn := "handleDelete"
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		removeFrames := r.Form.Get("frames") == "delete"
+
+		if err := s.removeWebcam(p.ByName("name"), removeFrames); err != nil {
+			log.Printf("%s, s.removeWebcam: %v\n", sn, err)
+			http.Error(w, err.Error(), webcamErrorStatus(err))
+			return
+		}
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
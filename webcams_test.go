@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_server_handleWebcams(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.Name = "testUI"
+	tld.Paused = true
+	frame := writeTestFrame(t, tld, solarNoon, 4, 4, color.White)
+
+	if err := srv.addWebcam(tld); err != nil {
+		t.Fatal(err)
+	}
+
+	edit := url.Values{
+		"name":         {"testUI"},
+		"webcamUrl":    {"https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg"},
+		"latitude":     {"40.437787"},
+		"longitude":    {"-121.5360307"},
+		"firstSunrise": {""},
+		"lastSunset":   {""},
+		"additional":   {"5"},
+		"folder":       {tld.FolderPath},
+		"from":         {""}, // preview date range, ignored when saving
+		"to":           {""},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		form       url.Values
+		wantStatus int
+		substring  string
+	}{
+		{name: "home lists webcams",
+			method:     "GET",
+			path:       "/",
+			wantStatus: http.StatusOK,
+			substring:  `/webcams/testUI/resume`,
+		},
+		{name: "edit form",
+			method:     "GET",
+			path:       "/webcams/testUI/edit",
+			wantStatus: http.StatusOK,
+			substring:  `action="/webcams/testUI/edit"`,
+		},
+		{name: "edit missing",
+			method:     "GET",
+			path:       "/webcams/testMissing/edit",
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "update invalid",
+			method:     "POST",
+			path:       "/webcams/testUI/edit",
+			form:       url.Values{"name": {"testUI"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{name: "update",
+			method:     "POST",
+			path:       "/webcams/testUI/edit",
+			form:       edit,
+			wantStatus: http.StatusSeeOther,
+		},
+		{name: "edit form updated",
+			method:     "GET",
+			path:       "/webcams/testUI/edit",
+			wantStatus: http.StatusOK,
+			substring:  `value="5"`,
+		},
+		{name: "update with radio buttons",
+			method: "POST",
+			path:   "/webcams/testUI/edit",
+			form: url.Values{
+				"name":       {"testUI"},
+				"webcamUrl":  {"https://www.nps.gov/webcams-lavo/kyvc_webcam1.jpg"},
+				"latitude":   {"40.437787"},
+				"longitude":  {"-121.5360307"},
+				"first":      {"sunrise60"},
+				"last":       {"sunset30"},
+				"additional": {"5"},
+				"folder":     {tld.FolderPath},
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{name: "edit form radio buttons",
+			method:     "GET",
+			path:       "/webcams/testUI/edit",
+			wantStatus: http.StatusOK,
+			substring:  `value="sunset30" checked`,
+		},
+		{name: "pause paused",
+			method:     "POST",
+			path:       "/webcams/testUI/pause",
+			wantStatus: http.StatusSeeOther,
+		},
+		{name: "resume missing",
+			method:     "POST",
+			path:       "/webcams/testMissing/resume",
+			wantStatus: http.StatusNotFound,
+		},
+		{name: "delete",
+			method:     "POST",
+			path:       "/webcams/testUI/delete",
+			form:       url.Values{"frames": {"delete"}},
+			wantStatus: http.StatusSeeOther,
+		},
+		{name: "delete missing",
+			method:     "POST",
+			path:       "/webcams/testUI/delete",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			srv.router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("%s, got %d, want %d: %s", tt.name, rr.Code, tt.wantStatus, rr.Body.String())
+			} else if tt.substring != "" && !strings.Contains(rr.Body.String(), tt.substring) {
+				t.Errorf("%s want substring %q, not found in %q", tt.name, tt.substring, rr.Body.String())
+			}
+		})
+	}
+
+	if _, err := os.Stat(frame); !os.IsNotExist(err) {
+		t.Errorf("captured image not removed with webcam: %v", err)
+	}
+}
+
+func Test_server_pauseWebcam_resume(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.Name = "testPauseResume"
+	start := time.Date(2020, 6, 1, 4, 0, 0, 0, srv.providers.Local)
+	clock := newFakeClock(start)
+	tld.providers = newFakeProviders(clock, &fakeImages{})
+
+	poll := time.Duration(srv.config.pollSecs) * time.Second
+	advance := func(until time.Time) {
+		for clock.Now().Before(until) {
+			clock.BlockUntil(t, 1)
+			clock.Advance(poll)
+		}
+	}
+
+	if err := srv.addWebcam(tld); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.removeWebcam(tld.Name, false)
+	advance(start.Add(4 * time.Hour)) // after the sunrise capture, before solar noon
+
+	// paused and resumed the same day, with the rest of the day's schedule ahead
+	if err := srv.pauseWebcam(tld.Name, true); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(poll) // fires the After of the stopped go routine
+	if err := srv.pauseWebcam(tld.Name, false); err != nil {
+		t.Fatal(err)
+	}
+	advance(start.Add(15 * time.Hour))
+	clock.BlockUntil(t, 1)
+	srv.stopCapture(tld)
+
+	frames, err := tld.Frames(start, start.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, f := range frames {
+		got = append(got, f.Time.Hour())
+	}
+	if len(got) != 3 || got[0] != 6 || got[1] != 12 || got[2] != 18 {
+		t.Errorf("captured at hours %v, want 6, 12 and 18", got)
+	}
+}
+
+func Test_server_saveWebcam_duplicate(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+	tld.Name = "testDuplicate"
+	tld.Paused = true
+
+	// concurrent submissions of the same name, only one is saved
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dup := *tld
+			errs <- srv.saveWebcam(&dup)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	defer srv.removeWebcam(tld.Name, false)
+
+	saved := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, errWebcamExists):
+			t.Errorf("server.saveWebcam() got %v, want nil or %v", err, errWebcamExists)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("server.saveWebcam() saved %d definitions named %q, want 1", saved, tld.Name)
+	}
+}
+
+func TestTLDef_RemoveFrames(t *testing.T) {
+	tld, cleanup := newFramesTLD(t)
+	defer cleanup()
+
+	frame := writeTestFrame(t, tld, sunrise, 4, 4, color.White)
+	writeTestFrame(t, tld, sunset, 4, 4, color.White)
+	other := filepath.Join(tld.FolderPath, "other file")
+	if err := ioutil.WriteFile(other, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	original := filepath.Join(tld.FolderPath, originalFolder, filepath.Base(frame))
+	if err := keepOriginal(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := tld.RemoveFrames()
+	if err != nil || n != 2 {
+		t.Errorf("TLDef.RemoveFrames() got %d, %v, want 2", n, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("TLDef.RemoveFrames() removed other file: %v", err)
+	}
+	if _, err := os.Stat(original); !os.IsNotExist(err) {
+		t.Errorf("TLDef.RemoveFrames() kept original copy: %v", err)
+	}
+}
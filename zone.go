@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+// time zones of the capture time stamped in file names, see FileTime
+const (
+	fileTimeServer = "server" // where this code is running, without offset, the default
+	fileTimeWebcam = "webcam" // the webcam's, with its offset, e.g., "-1000"
+	fileTimeUTC    = "utc"    // UTC, with offset "Z"
+)
+
+// fileZoneLayout is the layout of the capture time stamped in file names
+// with its offset, e.g., "20200601191500-1000" or "20200602051500Z"
+const fileZoneLayout = fileTimeLayout + "Z0700"
+
+// webcamLoc returns the webcam's time.Location, or where this code is
+// running while the webcam's time zone is unknown
+func (tld *TLDef) webcamLoc() *time.Location {
+	if tld.WebcamLoc == nil {
+		return tld.env().Local
+	}
+	return tld.WebcamLoc
+}
+
+// webcamDate returns the start of the webcam's day with the date of t, as
+// seen where t is, e.g., 2020-06-01 00:00 HST for 2020-06-01 00:00 PDT.
+// Days are counted with AddDate, so the 23 and 25 hour days of daylight
+// saving time changes are whole days.
+func (tld *TLDef) webcamDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, tld.webcamLoc())
+}
+
+// dayOf returns the webcam's date at t, e.g., "2020-05-27"
+func (tld *TLDef) dayOf(t time.Time) string {
+	return t.In(tld.webcamLoc()).Format(dateLayout)
+}
+
+// fileStamp formats capture time t for the file name, in the time zone
+// FileTime selects
+func (tld *TLDef) fileStamp(t time.Time) string {
+	switch tld.FileTime {
+	case fileTimeWebcam:
+		return t.In(tld.webcamLoc()).Format(fileZoneLayout)
+	case fileTimeUTC:
+		return t.UTC().Format(fileZoneLayout)
+	}
+	return t.In(tld.env().Local).Format(fileTimeLayout)
+}
+
+// parseFileStamp parses the capture time stamped in a file name by
+// fileStamp, in any time zone; stamps without offset are in local, where
+// this code is running
+func parseFileStamp(stamp string, local *time.Location) (time.Time, error) {
+	if len(stamp) == len(fileTimeLayout) { // without offset, where this code is running
+		return time.ParseInLocation(fileTimeLayout, stamp, local)
+	}
+	return time.Parse(fileZoneLayout, stamp)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// loadLocation loads the time zone named name, failing the test otherwise
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// webcamSolar is a fakeSolar with the sun rising, culminating and setting
+// at these clock times of the webcam's day in loc, whether or not daylight
+// saving time is in effect
+func webcamSolar(loc *time.Location, rise, noon, set time.Duration) fakeSolar {
+	return func(date time.Time) (SolarTimes, error) {
+		at := func(d time.Duration) time.Time {
+			return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, int(d.Seconds()), 0, loc).UTC()
+		}
+		return SolarTimes{Sunrise: at(rise), SolarNoon: at(noon), Sunset: at(set)}, nil
+	}
+}
+
+// newZoneTLD returns a definition of a webcam in loc, capturing at
+// sunrise, solar noon and sunset, the sun rising at 6:30, culminating at
+// 12:30 and setting at 18:30
+func newZoneTLD(loc *time.Location) *TLDef {
+	tld := newSimulateTLD(webcamSolar(loc, 6*time.Hour+30*time.Minute, 12*time.Hour+30*time.Minute, 18*time.Hour+30*time.Minute))
+	tld.providers.TimeZones = fakeTimeZone(loc.String())
+	tld.FirstFlags, tld.LastFlags = firstSunrise, lastSunset
+	return tld
+}
+
+// ********** ********** ********** ********** ********** **********
+
+func TestTLDef_webcamDate(t *testing.T) {
+	la := loadLocation(t, "America/Los_Angeles")
+	tld := &TLDef{WebcamLoc: la}
+
+	tests := []struct {
+		name  string
+		t     time.Time
+		want  string
+		hours float64 // length of the day
+	}{
+		{"regular", time.Date(2020, 6, 1, 22, 0, 0, 0, time.UTC), "2020-06-01 00:00 PDT", 24},
+		{"daylight saving time starts", time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC), "2020-03-08 00:00 PST", 23},
+		{"daylight saving time ends", time.Date(2020, 11, 1, 12, 0, 0, 0, la), "2020-11-01 00:00 PDT", 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tld.webcamDate(tt.t)
+			if got.Format("2006-01-02 15:04 MST") != tt.want {
+				t.Errorf("webcamDate(%s) got %s, want %s", tt.t, got, tt.want)
+			}
+			if hours := got.AddDate(0, 0, 1).Sub(got).Hours(); hours != tt.hours {
+				t.Errorf("webcamDate(%s) day of %v hours, want %v", tt.t, hours, tt.hours)
+			}
+		})
+	}
+}
+
+func TestTLDef_Simulate_dst(t *testing.T) {
+	la := loadLocation(t, "America/Los_Angeles")
+
+	tests := []struct {
+		name string
+		from time.Time
+	}{
+		{"daylight saving time starts", time.Date(2020, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{"daylight saving time ends", time.Date(2020, 10, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := newZoneTLD(la)
+			tld.providers.Local = time.UTC
+			sched, err := tld.Simulate(tt.from, tt.from.AddDate(0, 0, 3))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sched.Days) != 3 {
+				t.Fatalf("Simulate() got %d days %+v, want 3", len(sched.Days), sched.Days)
+			}
+			offsets := map[int]bool{}
+			for i, day := range sched.Days {
+				if want := tt.from.AddDate(0, 0, i).Format(dateLayout); day.Date != want || len(day.Anomalies) > 0 {
+					t.Errorf("Simulate() day %d got %s %q, want %s without anomalies", i, day.Date, day.Anomalies, want)
+				}
+				var got []string
+				for _, c := range day.Captures {
+					got = append(got, c.Webcam.Format("15:04"))
+					_, offset := c.Webcam.Zone()
+					offsets[offset] = true
+				}
+				if len(got) != 3 || got[0] != "06:30" || got[1] != "12:30" || got[2] != "18:30" {
+					t.Errorf("Simulate() %s captures at %v, want 06:30, 12:30 and 18:30 webcam time", day.Date, got)
+				}
+			}
+			if len(offsets) != 2 {
+				t.Errorf("Simulate() captures with offsets %v, want standard and daylight saving time", offsets)
+			}
+		})
+	}
+}
+
+func TestTLDef_UpdateNextCapture_webcamDay(t *testing.T) {
+	hawaii := loadLocation(t, "Pacific/Honolulu")
+
+	tld := newZoneTLD(hawaii)
+	tld.providers.Local = time.UTC // hours ahead of Hawaii, sunset is tomorrow
+	today := time.Date(2020, 6, 1, 12, 0, 0, 0, hawaii)
+	tld.providers.Clock = newFakeClock(today)
+	if err := tld.SetCaptureTimes(today); err != nil {
+		t.Fatal(err)
+	}
+	if got := tld.CaptureTimes[0].Format(dateLayout); got != "2020-06-01" {
+		t.Fatalf("SetCaptureTimes() schedule of %s, want 2020-06-01", got)
+	}
+
+	afterSunset := tld.CaptureTimes[2].Add(time.Second) // 2020-06-02 in UTC
+	tld.providers.Clock = newFakeClock(afterSunset)
+	tld.UpdateNextCapture(afterSunset)
+	if got := tld.NextCaptureTime(); !got.Equal(time.Date(2020, 6, 2, 6, 30, 0, 0, hawaii)) {
+		t.Errorf("UpdateNextCapture() next capture %s, want sunrise 2020-06-02 in Hawaii", got)
+	}
+}
+
+func TestTLDef_UpdateNextCapture_pastDays(t *testing.T) {
+	california := loadLocation(t, "America/Los_Angeles")
+
+	tld := newZoneTLD(california)
+	tld.providers.Local = loadLocation(t, "Pacific/Honolulu")
+	today := time.Date(2020, 6, 1, 12, 0, 0, 0, california)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{name: "after sunset",
+			now:  time.Date(2020, 6, 1, 20, 0, 0, 0, california),
+			want: time.Date(2020, 6, 2, 6, 30, 0, 0, california),
+		},
+		{name: "days later", // today's sunrise has passed too
+			now:  time.Date(2020, 6, 3, 10, 0, 0, 0, california),
+			want: time.Date(2020, 6, 3, 12, 30, 0, 0, california),
+		},
+		{name: "days later, after sunset",
+			now:  time.Date(2020, 6, 3, 20, 0, 0, 0, california),
+			want: time.Date(2020, 6, 4, 6, 30, 0, 0, california),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := tld.definition()
+			tld.providers.Clock = newFakeClock(today)
+			if err := tld.SetCaptureTimes(today); err != nil {
+				t.Fatal(err)
+			}
+			tld.providers.Clock = newFakeClock(tt.now)
+			if err := tld.UpdateNextCapture(tt.now); err != nil {
+				t.Fatal(err)
+			}
+			if got := tld.NextCaptureTime(); !got.Equal(tt.want) {
+				t.Errorf("UpdateNextCapture() next capture %s, want %s", got.In(california), tt.want)
+			}
+		})
+	}
+}
+
+func TestTLDef_fileStamp(t *testing.T) {
+	la := loadLocation(t, "America/Los_Angeles")
+
+	// 1:30 twice, as daylight saving time ends
+	pdt := time.Date(2020, 11, 1, 8, 30, 0, 0, time.UTC)
+	pst := pdt.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		fileTime string
+		at       time.Time
+		want     string
+	}{
+		{"default", "", pdt, "20201101083000"},
+		{"server", fileTimeServer, pdt, "20201101083000"},
+		{"utc", fileTimeUTC, pdt, "20201101083000Z"},
+		{"webcam daylight saving time", fileTimeWebcam, pdt, "20201101013000-0700"},
+		{"webcam standard time", fileTimeWebcam, pst, "20201101013000-0800"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tld := &TLDef{Name: "stamped", FileTime: tt.fileTime, WebcamLoc: la, providers: &Providers{Local: time.UTC}}
+			got := tld.fileStamp(tt.at)
+			if got != tt.want {
+				t.Errorf("fileStamp() got %q, want %q", got, tt.want)
+			}
+			if at, ok := tld.FrameTime(tld.Name + " " + got); !ok || !at.Equal(tt.at) {
+				t.Errorf("FrameTime(%q) got %s, %t, want %s", got, at, ok, tt.at)
+			}
+		})
+	}
+
+	if err := srv.validate.Struct(TLDef{Name: "x", URL: "http://x", Latitude: 1, Longitude: 1, FileTime: "local"}); err == nil {
+		t.Errorf("validate.Struct() of an unknown fileTime got nil")
+	}
+}